@@ -1,8 +1,13 @@
 package bptree
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+
+	"github.com/peterouob/gocloud/db/utils"
 )
 
 const (
@@ -13,58 +18,92 @@ type Comparable interface {
 	~int | ~int64 | ~string | ~float64
 }
 
-type BPTreeInterface[T Comparable] interface {
-	Insert(key T, value interface{})
-	Delete(key T)
-	Get(key T) []interface{}
-	Range(start, end T) []interface{}
-	Update(key T, value interface{})
+type BPTreeInterface[K Comparable, V any] interface {
+	Insert(key K, value V)
+	Delete(key K)
+	Get(key K) []V
+	Range(start, end K) []BPTreeItem[K, V]
+	Update(key K, value V)
+	Ascend(fn func(key K, value V) bool)
+	Descend(fn func(key K, value V) bool)
+	Min() (K, V, bool)
+	Max() (K, V, bool)
+	Len() int
+	Rank(key K) int
+	Select(i int) (K, V, bool)
+	CountRange(start, end K) int
 }
 
-type BPTree[T Comparable] struct {
+var _ BPTreeInterface[int, any] = (*BPTree[int, any])(nil)
+
+// BPTree is an ordered map safe for concurrent use. mutex is the structure
+// latch: operations touching a single leaf hold it shared and latch the leaf
+// alone, so writes to different leaves run in parallel while the internal
+// nodes cannot change. An insert that would split its leaf or raise its
+// MaxKey, or a delete that would underflow its leaf or lower its MaxKey,
+// gives the latches up and restarts holding mutex exclusive.
+type BPTree[K Comparable, V any] struct {
 	mutex sync.RWMutex
-	root  *BPTreeNode[T]
+	root  *BPTreeNode[K, V]
 	order int
+	size  atomic.Int64
+	// keyCodec and valueCodec encode the items of a snapshot.
+	keyCodec   utils.KeyCodec[K]
+	valueCodec utils.ValueCodec[V]
 }
 
-type BPTreeNode[T Comparable] struct {
-	MaxKey     T
-	Nodes      []*BPTreeNode[T]
-	Items      []BPTreeItem[T]
-	Next       *BPTreeNode[T]
+type BPTreeNode[K Comparable, V any] struct {
+	MaxKey K
+	Nodes  []*BPTreeNode[K, V]
+	Items  []BPTreeItem[K, V]
+	// Next and Prev link the leaves in key order.
+	Next       *BPTreeNode[K, V]
+	Prev       *BPTreeNode[K, V]
 	IsLeaf     bool
-	ParentNode *BPTreeNode[T]
+	ParentNode *BPTreeNode[K, V]
+	// count is the number of items below the node. It changes under the
+	// shared structure latch, so it is updated atomically.
+	count atomic.Int64
+	// latch guards the Items of a leaf under the shared structure latch.
+	latch sync.RWMutex
+}
+
+// Count returns the number of items below the node.
+func (n *BPTreeNode[K, V]) Count() int {
+	return int(n.count.Load())
 }
 
-type BPTreeItem[T Comparable] struct {
-	Key   T
-	Value interface{}
+type BPTreeItem[K Comparable, V any] struct {
+	Key   K
+	Value V
 }
 
-func NewBPTree[T Comparable](order int) *BPTree[T] {
+func NewBPTree[K Comparable, V any](order int) *BPTree[K, V] {
 	if order < 3 {
 		order = MaxOrder
 	}
-	root := NewBTreeNode[T](order, true)
-	return &BPTree[T]{
-		root:  root,
-		order: order,
+	root := NewBTreeNode[K, V](order, true)
+	return &BPTree[K, V]{
+		root:       root,
+		order:      order,
+		keyCodec:   utils.DefaultKeyCodec[K](),
+		valueCodec: utils.DefaultValueCodec[V](),
 	}
 }
 
-func NewBTreeNode[T Comparable](order int, isLeaf bool) *BPTreeNode[T] {
-	node := &BPTreeNode[T]{
+func NewBTreeNode[K Comparable, V any](order int, isLeaf bool) *BPTreeNode[K, V] {
+	node := &BPTreeNode[K, V]{
 		IsLeaf: isLeaf,
 	}
 	if isLeaf {
-		node.Items = make([]BPTreeItem[T], 0, order)
+		node.Items = make([]BPTreeItem[K, V], 0, order)
 	} else {
-		node.Nodes = make([]*BPTreeNode[T], 0, order+1)
+		node.Nodes = make([]*BPTreeNode[K, V], 0, order+1)
 	}
 	return node
 }
 
-func (b *BPTree[T]) findChildNode(node *BPTreeNode[T], key T) *BPTreeNode[T] {
+func (b *BPTree[K, V]) findChildNode(node *BPTreeNode[K, V], key K) *BPTreeNode[K, V] {
 	if node.IsLeaf {
 		return node
 	}
@@ -80,23 +119,59 @@ func (b *BPTree[T]) findChildNode(node *BPTreeNode[T], key T) *BPTreeNode[T] {
 	return node.Nodes[len(node.Nodes)-1]
 }
 
-func (b *BPTree[T]) Insert(key T, value interface{}) {
+func (b *BPTree[K, V]) Insert(key K, value V) {
+	if b.insertOptimistic(key, value) {
+		return
+	}
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	if b.root == nil {
-		b.root = NewBTreeNode[T](b.order, true)
-	}
-
 	leafNode := b.findChildNode(b.root, key)
 	b.insertIntoLeaf(leafNode, key, value)
+}
 
-	if len(b.root.Nodes) > b.order {
-		b.splitRoot()
+// insertOptimistic inserts key holding the structure latch shared and the
+// leaf latch. It reports false, changing nothing, when the leaf would split or
+// its MaxKey would change.
+func (b *BPTree[K, V]) insertOptimistic(key K, value V) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	leaf := b.findChildNode(b.root, key)
+	leaf.latch.Lock()
+	defer leaf.latch.Unlock()
+	i, found := leaf.search(key)
+	if found {
+		leaf.Items[i].Value = value
+		return true
+	}
+	if len(leaf.Items) >= b.order || len(leaf.Items) == 0 || key > leaf.MaxKey {
+		return false
 	}
+	leaf.Items = append(leaf.Items, BPTreeItem[K, V]{})
+	copy(leaf.Items[i+1:], leaf.Items[i:])
+	leaf.Items[i] = BPTreeItem[K, V]{Key: key, Value: value}
+	b.addCount(leaf, 1)
+	return true
 }
 
-func (b *BPTree[T]) insertIntoLeaf(node *BPTreeNode[T], key T, value interface{}) {
+// addCount adds delta to the count of leaf and of its ancestors, which are
+// stable under the shared structure latch.
+func (b *BPTree[K, V]) addCount(leaf *BPTreeNode[K, V], delta int64) {
+	b.size.Add(delta)
+	for node := leaf; node != nil; node = node.ParentNode {
+		node.count.Add(delta)
+	}
+}
+
+// search returns the position of the first item of the leaf whose key is >=
+// key and whether it is key.
+func (n *BPTreeNode[K, V]) search(key K) (int, bool) {
+	i := sort.Search(len(n.Items), func(i int) bool { return n.Items[i].Key >= key })
+	return i, i < len(n.Items) && n.Items[i].Key == key
+}
+
+func (b *BPTree[K, V]) insertIntoLeaf(node *BPTreeNode[K, V], key K, value V) {
 	insertIndex := 0
 	for insertIndex < len(node.Items) && node.Items[insertIndex].Key < key {
 		insertIndex++
@@ -105,28 +180,27 @@ func (b *BPTree[T]) insertIntoLeaf(node *BPTreeNode[T], key T, value interface{}
 	if insertIndex < len(node.Items) && node.Items[insertIndex].Key == key {
 		node.Items[insertIndex].Value = value
 	} else {
-		newItem := BPTreeItem[T]{
+		b.size.Add(1)
+		newItem := BPTreeItem[K, V]{
 			Key:   key,
 			Value: value,
 		}
 
-		node.Items = append(node.Items, BPTreeItem[T]{})
+		node.Items = append(node.Items, BPTreeItem[K, V]{})
 		copy(node.Items[insertIndex+1:], node.Items[insertIndex:])
 		node.Items[insertIndex] = newItem
 	}
 
-	if len(node.Items) > 0 {
-		node.MaxKey = node.Items[len(node.Items)-1].Key
-	}
+	b.refresh(node)
 
 	if len(node.Items) > b.order {
 		b.splitLeafNode(node)
 	}
 }
 
-func (b *BPTree[T]) splitLeafNode(node *BPTreeNode[T]) {
+func (b *BPTree[K, V]) splitLeafNode(node *BPTreeNode[K, V]) {
 	midIndex := len(node.Items) / 2
-	newNode := NewBTreeNode[T](b.order, true)
+	newNode := NewBTreeNode[K, V](b.order, true)
 
 	newNode.Items = append(newNode.Items, node.Items[midIndex:]...)
 	node.Items = node.Items[:midIndex]
@@ -135,14 +209,20 @@ func (b *BPTree[T]) splitLeafNode(node *BPTreeNode[T]) {
 	node.MaxKey = node.Items[len(node.Items)-1].Key
 
 	newNode.Next = node.Next
+	newNode.Prev = node
+	if node.Next != nil {
+		node.Next.Prev = newNode
+	}
 	node.Next = newNode
 
 	b.insertIntoParent(node, newNode)
+	b.refresh(node)
+	b.refresh(newNode)
 }
 
-func (b *BPTree[T]) insertIntoParent(leftNode, rightNode *BPTreeNode[T]) {
+func (b *BPTree[K, V]) insertIntoParent(leftNode, rightNode *BPTreeNode[K, V]) {
 	if leftNode.ParentNode == nil {
-		newRoot := NewBTreeNode[T](b.order, false)
+		newRoot := NewBTreeNode[K, V](b.order, false)
 		newRoot.Nodes = append(newRoot.Nodes, leftNode, rightNode)
 		newRoot.MaxKey = rightNode.MaxKey
 		leftNode.ParentNode = newRoot
@@ -152,10 +232,7 @@ func (b *BPTree[T]) insertIntoParent(leftNode, rightNode *BPTreeNode[T]) {
 	}
 
 	parentNode := leftNode.ParentNode
-	insertIndex := 0
-	for insertIndex < len(parentNode.Nodes) && parentNode.Nodes[insertIndex].MaxKey < rightNode.MaxKey {
-		insertIndex++
-	}
+	insertIndex := childIndex(parentNode, leftNode) + 1
 
 	parentNode.Nodes = append(parentNode.Nodes, nil)
 	copy(parentNode.Nodes[insertIndex+1:], parentNode.Nodes[insertIndex:])
@@ -169,9 +246,9 @@ func (b *BPTree[T]) insertIntoParent(leftNode, rightNode *BPTreeNode[T]) {
 	}
 }
 
-func (b *BPTree[T]) splitParentNode(node *BPTreeNode[T]) {
+func (b *BPTree[K, V]) splitParentNode(node *BPTreeNode[K, V]) {
 	midIndex := len(node.Nodes) / 2
-	newNode := NewBTreeNode[T](b.order, false)
+	newNode := NewBTreeNode[K, V](b.order, false)
 
 	newNode.Nodes = append(newNode.Nodes, node.Nodes[midIndex:]...)
 	node.Nodes = node.Nodes[:midIndex]
@@ -184,45 +261,62 @@ func (b *BPTree[T]) splitParentNode(node *BPTreeNode[T]) {
 	node.MaxKey = node.Nodes[len(node.Nodes)-1].MaxKey
 
 	b.insertIntoParent(node, newNode)
+	b.refresh(node)
+	b.refresh(newNode)
 }
 
-func (b *BPTree[T]) splitRoot() {
-	midIndex := len(b.root.Nodes) / 2
-	newRoot := NewBTreeNode[T](b.order, false)
-
-	leftNodes := b.root.Nodes[:midIndex]
-	rightNodes := b.root.Nodes[midIndex:]
-
-	newRoot.Nodes = append(newRoot.Nodes, leftNodes[len(leftNodes)-1], rightNodes[0])
-	newRoot.MaxKey = rightNodes[0].MaxKey
-
-	for _, node := range leftNodes {
-		node.ParentNode = newRoot
-	}
-	for _, node := range rightNodes {
-		node.ParentNode = newRoot
+// refresh sets the MaxKey and Count of node and of its ancestors from their
+// items or children. An empty leaf keeps its MaxKey.
+func (b *BPTree[K, V]) refresh(node *BPTreeNode[K, V]) {
+	for ; node != nil; node = node.ParentNode {
+		if node.IsLeaf {
+			node.count.Store(int64(len(node.Items)))
+			if len(node.Items) > 0 {
+				node.MaxKey = node.Items[len(node.Items)-1].Key
+			}
+			continue
+		}
+		var count int64
+		for _, child := range node.Nodes {
+			count += child.count.Load()
+		}
+		node.count.Store(count)
+		if len(node.Nodes) > 0 {
+			node.MaxKey = node.Nodes[len(node.Nodes)-1].MaxKey
+		}
 	}
+}
 
-	b.root = newRoot
+// childIndex returns the position of child in node.Nodes, -1 when missing.
+func childIndex[K Comparable, V any](node, child *BPTreeNode[K, V]) int {
+	for i, n := range node.Nodes {
+		if n == child {
+			return i
+		}
+	}
+	return -1
 }
 
-func (b *BPTree[T]) Get(key T) []interface{} {
+func (b *BPTree[K, V]) Get(key K) []V {
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
 
 	leafNode := b.findChildNode(b.root, key)
-	var results []interface{}
+	leafNode.latch.RLock()
+	defer leafNode.latch.RUnlock()
+	var results []V
 
-	for _, item := range leafNode.Items {
-		if item.Key == key {
-			results = append(results, item.Value)
-		}
+	if i, found := leafNode.search(key); found {
+		results = append(results, leafNode.Items[i].Value)
 	}
 
 	return results
 }
 
-func (b *BPTree[T]) Delete(key T) {
+func (b *BPTree[K, V]) Delete(key K) {
+	if b.deleteOptimistic(key) {
+		return
+	}
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
@@ -230,61 +324,147 @@ func (b *BPTree[T]) Delete(key T) {
 	b.deleteFromLeaf(leafNode, key)
 }
 
-func (b *BPTree[T]) deleteFromLeaf(node *BPTreeNode[T], key T) {
+// deleteOptimistic deletes key holding the structure latch shared and the
+// leaf latch. It reports false, changing nothing, when the leaf would
+// underflow or lose its largest key.
+func (b *BPTree[K, V]) deleteOptimistic(key K) bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	leaf := b.findChildNode(b.root, key)
+	leaf.latch.Lock()
+	defer leaf.latch.Unlock()
+	i, found := leaf.search(key)
+	if !found {
+		return true
+	}
+	if i == len(leaf.Items)-1 || leaf != b.root && len(leaf.Items) <= b.minEntries() {
+		return false
+	}
+	leaf.Items = append(leaf.Items[:i], leaf.Items[i+1:]...)
+	b.addCount(leaf, -1)
+	return true
+}
+
+func (b *BPTree[K, V]) deleteFromLeaf(node *BPTreeNode[K, V], key K) {
 	for i, item := range node.Items {
 		if item.Key == key {
 			node.Items = append(node.Items[:i], node.Items[i+1:]...)
-
-			if len(node.Items) > 0 {
-				node.MaxKey = node.Items[len(node.Items)-1].Key
-			}
-
-			if len(node.Items) < b.order/2 {
-				b.rebalanceLeaf(node)
-			}
+			b.size.Add(-1)
+			b.refresh(node)
+			b.rebalance(node)
 			return
 		}
 	}
 }
 
-func (b *BPTree[T]) rebalanceLeaf(node *BPTreeNode[T]) {
-	if node.Next != nil && len(node.Next.Items) > b.order/2 {
-		borrowedItem := node.Next.Items[0]
-		node.Items = append(node.Items, borrowedItem)
-		node.Next.Items = node.Next.Items[1:]
+// minEntries is the fewest items or children a node other than the root
+// holds: a split of order+1 entries leaves minEntries on the left.
+func (b *BPTree[K, V]) minEntries() int {
+	return (b.order + 1) / 2
+}
+
+func entries[K Comparable, V any](node *BPTreeNode[K, V]) int {
+	if node.IsLeaf {
+		return len(node.Items)
+	}
+	return len(node.Nodes)
+}
 
-		node.MaxKey = node.Items[len(node.Items)-1].Key
-		node.Next.MaxKey = node.Next.Items[len(node.Next.Items)-1].Key
-	} else if node.ParentNode != nil {
-		parentIndex := 0
-		for i, n := range node.ParentNode.Nodes {
-			if n == node {
-				parentIndex = i
-				break
-			}
+// rebalance restores the fill of node after a delete: it borrows an entry
+// from a sibling holding more than the minimum, or merges with a sibling and
+// rebalances the parent. A root left with one child is replaced by it.
+func (b *BPTree[K, V]) rebalance(node *BPTreeNode[K, V]) {
+	parent := node.ParentNode
+	if parent == nil {
+		if !node.IsLeaf && len(node.Nodes) == 1 {
+			b.root = node.Nodes[0]
+			b.root.ParentNode = nil
 		}
+		return
+	}
+	if entries(node) >= b.minEntries() {
+		return
+	}
 
-		if parentIndex > 0 {
-			leftSibling := node.ParentNode.Nodes[parentIndex-1]
-			leftSibling.Items = append(leftSibling.Items, node.Items...)
-			leftSibling.MaxKey = leftSibling.Items[len(leftSibling.Items)-1].Key
+	idx := childIndex(parent, node)
+	var left, right *BPTreeNode[K, V]
+	if idx > 0 {
+		left = parent.Nodes[idx-1]
+	}
+	if idx < len(parent.Nodes)-1 {
+		right = parent.Nodes[idx+1]
+	}
 
-			node.ParentNode.Nodes = append(node.ParentNode.Nodes[:parentIndex], node.ParentNode.Nodes[parentIndex+1:]...)
+	switch {
+	case left != nil && entries(left) > b.minEntries():
+		if node.IsLeaf {
+			node.Items = append([]BPTreeItem[K, V]{left.Items[len(left.Items)-1]}, node.Items...)
+			left.Items = left.Items[:len(left.Items)-1]
+		} else {
+			moved := left.Nodes[len(left.Nodes)-1]
+			node.Nodes = append([]*BPTreeNode[K, V]{moved}, node.Nodes...)
+			left.Nodes = left.Nodes[:len(left.Nodes)-1]
+			moved.ParentNode = node
 		}
+		b.refresh(left)
+		b.refresh(node)
+	case right != nil && entries(right) > b.minEntries():
+		if node.IsLeaf {
+			node.Items = append(node.Items, right.Items[0])
+			right.Items = right.Items[1:]
+		} else {
+			moved := right.Nodes[0]
+			node.Nodes = append(node.Nodes, moved)
+			right.Nodes = right.Nodes[1:]
+			moved.ParentNode = node
+		}
+		b.refresh(right)
+		b.refresh(node)
+	case left != nil:
+		b.merge(left, node, idx)
+	case right != nil:
+		b.merge(node, right, idx+1)
+	default:
+		// an only child: the parent is a root being collapsed
+		b.rebalance(parent)
 	}
 }
 
-func (b *BPTree[T]) Range(start, end T) []interface{} {
+// merge moves the entries of right into its left sibling and removes right,
+// the child at idx of their parent, then rebalances the parent.
+func (b *BPTree[K, V]) merge(left, right *BPTreeNode[K, V], idx int) {
+	if left.IsLeaf {
+		left.Items = append(left.Items, right.Items...)
+		left.Next = right.Next
+		if right.Next != nil {
+			right.Next.Prev = left
+		}
+	} else {
+		for _, child := range right.Nodes {
+			child.ParentNode = left
+		}
+		left.Nodes = append(left.Nodes, right.Nodes...)
+	}
+	parent := left.ParentNode
+	parent.Nodes = append(parent.Nodes[:idx], parent.Nodes[idx+1:]...)
+	right.ParentNode = nil
+	b.refresh(left)
+	b.rebalance(parent)
+}
+
+// Range returns the items whose key is in [start, end], in key order.
+func (b *BPTree[K, V]) Range(start, end K) []BPTreeItem[K, V] {
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
 
-	var results []interface{}
+	var results []BPTreeItem[K, V]
 	leafNode := b.findChildNode(b.root, start)
 
 	for leafNode != nil {
-		for _, item := range leafNode.Items {
+		for _, item := range leafNode.items() {
 			if item.Key >= start && item.Key <= end {
-				results = append(results, item.Value)
+				results = append(results, item)
 			}
 			if item.Key > end {
 				return results
@@ -296,24 +476,127 @@ func (b *BPTree[T]) Range(start, end T) []interface{} {
 	return results
 }
 
-func (b *BPTree[T]) Update(key T, value interface{}) {
+// items returns a copy of the items of a leaf, taken under its latch.
+func (n *BPTreeNode[K, V]) items() []BPTreeItem[K, V] {
+	n.latch.RLock()
+	defer n.latch.RUnlock()
+	return append([]BPTreeItem[K, V](nil), n.Items...)
+}
+
+func (b *BPTree[K, V]) Update(key K, value V) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	leafNode := b.findChildNode(b.root, key)
+	leafNode.latch.Lock()
+	defer leafNode.latch.Unlock()
+	if i, found := leafNode.search(key); found {
+		leafNode.Items[i].Value = value
+	}
+}
+
+// CheckInvariants verifies the structure of the tree: sorted keys, every
+// leaf at the same depth, nodes other than the root at least half full, the
+// MaxKey of every node the largest key below it, the Count of every node,
+// parent links, the Next and Prev chains visiting every leaf in key order, and
+// Len. It holds the structure latch exclusive.
+func (b *BPTree[K, V]) CheckInvariants() error {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	leafNode := b.findChildNode(b.root, key)
-	for i, item := range leafNode.Items {
-		if item.Key == key {
-			leafNode.Items[i].Value = value
-			return
+	if b.root.ParentNode != nil {
+		return errors.New("root has a parent")
+	}
+	if !b.root.IsLeaf && len(b.root.Nodes) < 2 {
+		return fmt.Errorf("internal root has %d children", len(b.root.Nodes))
+	}
+	var leaves []*BPTreeNode[K, V]
+	leafDepth := -1
+	var check func(node *BPTreeNode[K, V], depth int) error
+	check = func(node *BPTreeNode[K, V], depth int) error {
+		if node != b.root {
+			if n := entries(node); n < b.minEntries() || n > b.order {
+				return fmt.Errorf("node with max key %v holds %d entries, want %d to %d", node.MaxKey, n, b.minEntries(), b.order)
+			}
+		}
+		if node.IsLeaf {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if depth != leafDepth {
+				return fmt.Errorf("leaf at depth %d, others at %d", depth, leafDepth)
+			}
+			for i := 1; i < len(node.Items); i++ {
+				if node.Items[i-1].Key >= node.Items[i].Key {
+					return fmt.Errorf("leaf keys %v and %v out of order", node.Items[i-1].Key, node.Items[i].Key)
+				}
+			}
+			if node.Count() != len(node.Items) {
+				return fmt.Errorf("leaf Count %d, %d items", node.Count(), len(node.Items))
+			}
+			if len(node.Items) > 0 && node.MaxKey != node.Items[len(node.Items)-1].Key {
+				return fmt.Errorf("leaf MaxKey %v, last key %v", node.MaxKey, node.Items[len(node.Items)-1].Key)
+			}
+			leaves = append(leaves, node)
+			return nil
 		}
+		for i, child := range node.Nodes {
+			if child.ParentNode != node {
+				return fmt.Errorf("child with max key %v has a wrong parent", child.MaxKey)
+			}
+			if i > 0 && node.Nodes[i-1].MaxKey >= child.MaxKey {
+				return fmt.Errorf("children with max keys %v and %v out of order", node.Nodes[i-1].MaxKey, child.MaxKey)
+			}
+			if err := check(child, depth+1); err != nil {
+				return err
+			}
+			if i > 0 && child.IsLeaf && child.Items[0].Key <= node.Nodes[i-1].MaxKey {
+				return fmt.Errorf("leaf key %v not above its left sibling max %v", child.Items[0].Key, node.Nodes[i-1].MaxKey)
+			}
+		}
+		count := 0
+		for _, child := range node.Nodes {
+			count += child.Count()
+		}
+		if node.Count() != count {
+			return fmt.Errorf("internal Count %d, children hold %d", node.Count(), count)
+		}
+		if node.MaxKey != node.Nodes[len(node.Nodes)-1].MaxKey {
+			return fmt.Errorf("internal MaxKey %v, last child max %v", node.MaxKey, node.Nodes[len(node.Nodes)-1].MaxKey)
+		}
+		return nil
+	}
+	if err := check(b.root, 0); err != nil {
+		return err
+	}
+
+	size := 0
+	for i, leaf := range leaves {
+		var prev, next *BPTreeNode[K, V]
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i+1 < len(leaves) {
+			next = leaves[i+1]
+		}
+		if leaf.Next != next {
+			return fmt.Errorf("Next of leaf %d does not point to the following leaf", i)
+		}
+		if leaf.Prev != prev {
+			return fmt.Errorf("Prev of leaf %d does not point to the preceding leaf", i)
+		}
+		size += len(leaf.Items)
+	}
+	if size != int(b.size.Load()) {
+		return fmt.Errorf("tree holds %d items, Len is %d", size, b.size.Load())
 	}
+	return nil
 }
 
-func (b *BPTree[T]) PrintTree() {
+func (b *BPTree[K, V]) PrintTree() {
 	b.printNode(b.root, 0)
 }
 
-func (b *BPTree[T]) printNode(node *BPTreeNode[T], level int) {
+func (b *BPTree[K, V]) printNode(node *BPTreeNode[K, V], level int) {
 	indent := ""
 	for i := 0; i < level; i++ {
 		indent += "  "
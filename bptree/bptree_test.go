@@ -8,7 +8,7 @@ import (
 )
 
 func TestBasicInsertion(t *testing.T) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	testCases := []struct {
 		key   int
@@ -38,7 +38,7 @@ func TestBasicInsertion(t *testing.T) {
 }
 
 func TestDuplicateInsertion(t *testing.T) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	tree.Insert(10, "first")
 	tree.Insert(10, "second")
@@ -53,7 +53,7 @@ func TestDuplicateInsertion(t *testing.T) {
 }
 
 func TestUpdate(t *testing.T) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	tree.Insert(10, "original")
 
@@ -64,7 +64,7 @@ func TestUpdate(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	testKeys := []int{10, 20, 30, 40, 50}
 	for _, key := range testKeys {
@@ -79,8 +79,66 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestRandomInsertDelete(t *testing.T) {
+	for _, order := range []int{3, 4, 5, 8} {
+		tree := NewBPTree[int, string](order)
+		rnd := rand.New(rand.NewSource(int64(order)))
+		want := make(map[int]string)
+
+		for i := 0; i < 5000; i++ {
+			key := rnd.Intn(1000)
+			if rnd.Intn(3) == 0 {
+				tree.Delete(key)
+				delete(want, key)
+			} else {
+				value := fmt.Sprintf("value_%d", i)
+				tree.Insert(key, value)
+				want[key] = value
+			}
+			if i%50 == 0 {
+				if err := tree.CheckInvariants(); err != nil {
+					t.Fatalf("order %d after %d operations: %v", order, i, err)
+				}
+			}
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("order %d: %v", order, err)
+		}
+
+		for key := 0; key < 1000; key++ {
+			values := tree.Get(key)
+			if value, ok := want[key]; ok != (len(values) == 1) || ok && values[0] != value {
+				t.Fatalf("order %d key %d: got %v, want %q", order, key, values, value)
+			}
+		}
+		if n := len(tree.Range(0, 1000)); n != len(want) {
+			t.Errorf("order %d: range returned %d values, want %d", order, n, len(want))
+		}
+
+		keys := make([]int, 0, len(want))
+		for key := range want {
+			keys = append(keys, key)
+		}
+		rnd.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+		for i, key := range keys {
+			tree.Delete(key)
+			if i%25 == 0 {
+				if err := tree.CheckInvariants(); err != nil {
+					t.Fatalf("order %d draining, %d left: %v", order, len(keys)-i-1, err)
+				}
+			}
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatalf("order %d: %v", order, err)
+		}
+		if !tree.root.IsLeaf || len(tree.root.Items) != 0 {
+			t.Errorf("order %d: empty tree has a root of %d entries", order, entries(tree.root))
+		}
+	}
+}
+
 func TestRangeQuery(t *testing.T) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	testData := []int{5, 10, 15, 20, 25, 30, 35, 40}
 	for _, key := range testData {
@@ -97,7 +155,7 @@ func TestRangeQuery(t *testing.T) {
 }
 
 func TestLargeDataset(t *testing.T) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	datasetSize := 1000
 	insertedData := make(map[int]string)
@@ -128,7 +186,7 @@ func TestLargeDataset(t *testing.T) {
 }
 
 func TestConcurrentAccess(t *testing.T) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	done := make(chan bool)
 	for i := 0; i < 100; i++ {
@@ -162,7 +220,7 @@ func TestConcurrentAccess(t *testing.T) {
 }
 
 func TestStringKeyBPTree(t *testing.T) {
-	tree := NewBPTree[string](4)
+	tree := NewBPTree[string, string](4)
 
 	testData := []string{"apple", "banana", "cherry", "date", "elderberry"}
 	for _, key := range testData {
@@ -179,7 +237,7 @@ func TestStringKeyBPTree(t *testing.T) {
 }
 
 func BenchmarkInsertion(b *testing.B) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -188,7 +246,7 @@ func BenchmarkInsertion(b *testing.B) {
 }
 
 func BenchmarkSearch(b *testing.B) {
-	tree := NewBPTree[int](4)
+	tree := NewBPTree[int, string](4)
 
 	for i := 0; i < 10000; i++ {
 		tree.Insert(i, fmt.Sprintf("value_%d", i))
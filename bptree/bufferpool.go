@@ -0,0 +1,283 @@
+package bptree
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// EvictionPolicy selects the frame a BufferPool reuses when it is full.
+type EvictionPolicy int
+
+const (
+	// EvictLRU reuses the unpinned frame used least recently.
+	EvictLRU EvictionPolicy = iota
+	// EvictClock sweeps the frames, giving the recently used ones a second
+	// chance; it approximates LRU without reordering on every access.
+	EvictClock
+)
+
+var ErrPoolFull = errors.New("every frame of the buffer pool is pinned")
+
+// Frame holds a page in memory. Data may be changed while the frame is
+// pinned; Unpin with dirty set has it written back before eviction.
+type Frame struct {
+	ID    PageID
+	Data  []byte
+	pins  int
+	dirty bool
+	idx   int
+}
+
+// replacer tracks the unpinned frames a BufferPool may evict.
+type replacer interface {
+	// pin removes frame idx from the candidates.
+	pin(idx int)
+	// unpin makes frame idx a candidate again, as just used.
+	unpin(idx int)
+	// victim picks a candidate and removes it.
+	victim() (int, bool)
+}
+
+// BufferPool caches up to capacity pages of a Pager. Fetched pages stay in
+// memory while pinned.
+type BufferPool struct {
+	mu       sync.Mutex
+	pager    *Pager
+	frames   []*Frame
+	pages    map[PageID]*Frame
+	free     []int
+	replacer replacer
+	hits     int64
+	misses   int64
+}
+
+func NewBufferPool(pager *Pager, capacity int, policy EvictionPolicy) *BufferPool {
+	if capacity < 1 {
+		capacity = 1
+	}
+	b := &BufferPool{
+		pager:  pager,
+		frames: make([]*Frame, capacity),
+		pages:  make(map[PageID]*Frame, capacity),
+	}
+	for i := range b.frames {
+		b.frames[i] = &Frame{Data: make([]byte, pager.PageSize()), idx: i}
+		b.free = append(b.free, capacity-1-i)
+	}
+	if policy == EvictClock {
+		b.replacer = newClockReplacer(capacity)
+	} else {
+		b.replacer = newLRUReplacer()
+	}
+	return b
+}
+
+// Fetch returns page id pinned, reading it from the pager when not cached.
+func (b *BufferPool) Fetch(id PageID) (*Frame, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.pages[id]; ok {
+		b.hits++
+		b.pinLocked(f)
+		return f, nil
+	}
+	b.misses++
+	f, err := b.frameLocked()
+	if err != nil {
+		return nil, err
+	}
+	if err := b.pager.Read(id, f.Data); err != nil {
+		b.free = append(b.free, f.idx)
+		return nil, err
+	}
+	b.installLocked(f, id)
+	return f, nil
+}
+
+// NewPage allocates a page and returns it pinned, zeroed and dirty.
+func (b *BufferPool) NewPage() (*Frame, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	f, err := b.frameLocked()
+	if err != nil {
+		return nil, err
+	}
+	id, err := b.pager.Allocate()
+	if err != nil {
+		b.free = append(b.free, f.idx)
+		return nil, err
+	}
+	clear(f.Data)
+	b.installLocked(f, id)
+	f.dirty = true
+	return f, nil
+}
+
+// Unpin releases a pin of f; dirty marks its data changed. Unpinning a frame
+// more often than it was pinned panics, since the frame could then be evicted
+// while in use.
+func (b *BufferPool) Unpin(f *Frame, dirty bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f.pins <= 0 {
+		panic(fmt.Sprintf("bptree: unpin of page %d, which is not pinned", f.ID))
+	}
+	f.dirty = f.dirty || dirty
+	f.pins--
+	if f.pins == 0 {
+		b.replacer.unpin(f.idx)
+	}
+}
+
+// FreePage drops page id, which must not be pinned, from the pool and hands
+// it back to the pager.
+func (b *BufferPool) FreePage(id PageID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if f, ok := b.pages[id]; ok {
+		if f.pins > 0 {
+			return errors.New("error in free page : page is pinned")
+		}
+		b.replacer.pin(f.idx)
+		delete(b.pages, id)
+		f.dirty = false
+		b.free = append(b.free, f.idx)
+	}
+	return b.pager.Free(id)
+}
+
+// FlushAll writes every dirty page back.
+func (b *BufferPool) FlushAll() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, f := range b.pages {
+		if f.dirty {
+			if err := b.pager.Write(f.ID, f.Data); err != nil {
+				return err
+			}
+			f.dirty = false
+		}
+	}
+	return nil
+}
+
+// Stats returns the fetches served from memory and from the pager.
+func (b *BufferPool) Stats() (hits, misses int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.hits, b.misses
+}
+
+func (b *BufferPool) pinLocked(f *Frame) {
+	if f.pins == 0 {
+		b.replacer.pin(f.idx)
+	}
+	f.pins++
+}
+
+func (b *BufferPool) installLocked(f *Frame, id PageID) {
+	f.ID, f.pins, f.dirty = id, 1, false
+	b.pages[id] = f
+}
+
+// frameLocked returns an unused frame, evicting a page when none is free.
+func (b *BufferPool) frameLocked() (*Frame, error) {
+	if n := len(b.free); n > 0 {
+		f := b.frames[b.free[n-1]]
+		b.free = b.free[:n-1]
+		return f, nil
+	}
+	idx, ok := b.replacer.victim()
+	if !ok {
+		return nil, ErrPoolFull
+	}
+	f := b.frames[idx]
+	if f.dirty {
+		if err := b.pager.Write(f.ID, f.Data); err != nil {
+			b.replacer.unpin(idx)
+			return nil, err
+		}
+		f.dirty = false
+	}
+	delete(b.pages, f.ID)
+	return f, nil
+}
+
+type lruReplacer struct {
+	order *list.List
+	elems map[int]*list.Element
+}
+
+func newLRUReplacer() *lruReplacer {
+	return &lruReplacer{order: list.New(), elems: make(map[int]*list.Element)}
+}
+
+func (r *lruReplacer) pin(idx int) {
+	if e, ok := r.elems[idx]; ok {
+		r.order.Remove(e)
+		delete(r.elems, idx)
+	}
+}
+
+func (r *lruReplacer) unpin(idx int) {
+	r.pin(idx)
+	r.elems[idx] = r.order.PushBack(idx)
+}
+
+func (r *lruReplacer) victim() (int, bool) {
+	e := r.order.Front()
+	if e == nil {
+		return 0, false
+	}
+	idx := r.order.Remove(e).(int)
+	delete(r.elems, idx)
+	return idx, true
+}
+
+type clockReplacer struct {
+	hand      int
+	evictable []bool
+	ref       []bool
+	n         int
+}
+
+func newClockReplacer(capacity int) *clockReplacer {
+	return &clockReplacer{evictable: make([]bool, capacity), ref: make([]bool, capacity)}
+}
+
+func (r *clockReplacer) pin(idx int) {
+	if r.evictable[idx] {
+		r.evictable[idx] = false
+		r.n--
+	}
+}
+
+func (r *clockReplacer) unpin(idx int) {
+	if !r.evictable[idx] {
+		r.evictable[idx] = true
+		r.n++
+	}
+	r.ref[idx] = true
+}
+
+func (r *clockReplacer) victim() (int, bool) {
+	if r.n == 0 {
+		return 0, false
+	}
+	for {
+		idx := r.hand
+		r.hand = (r.hand + 1) % len(r.evictable)
+		if !r.evictable[idx] {
+			continue
+		}
+		if r.ref[idx] {
+			r.ref[idx] = false
+			continue
+		}
+		r.evictable[idx] = false
+		r.n--
+		return idx, true
+	}
+}
@@ -0,0 +1,122 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrUnsorted = errors.New("bulk load keys are not strictly ascending")
+
+// LoadIterator yields the items of a BulkLoad in strictly ascending key
+// order. Err reports why Next stopped early.
+type LoadIterator[K Comparable, V any] interface {
+	Next() bool
+	Key() K
+	Value() V
+	Err() error
+}
+
+// ByteIterator is an iterator over byte keys and values, such as the scan of
+// an sstable.LSMTree.
+type ByteIterator interface {
+	Next() bool
+	Key() []byte
+	Value() []byte
+	Err() error
+}
+
+// BytesLoader adapts it to a LoadIterator of string keys, so a tree can be
+// rebuilt from an SST scan ordered bytewise. The values are copied.
+func BytesLoader(it ByteIterator) LoadIterator[string, []byte] {
+	return bytesLoader{it}
+}
+
+type bytesLoader struct{ ByteIterator }
+
+func (l bytesLoader) Key() string { return string(l.ByteIterator.Key()) }
+
+func (l bytesLoader) Value() []byte { return append([]byte(nil), l.ByteIterator.Value()...) }
+
+// BulkLoad builds a tree of the given order from sorted items without a
+// descent per key: leaves are packed to fill, a fraction of order, then every
+// internal level is built over the one below in a single pass. A fill outside
+// (0, 1] packs full nodes. Nodes never hold less than half of order, so the
+// tree stays valid for later inserts and deletes.
+func BulkLoad[K Comparable, V any](order int, fill float64, it LoadIterator[K, V]) (*BPTree[K, V], error) {
+	b := NewBPTree[K, V](order)
+	if fill <= 0 || fill > 1 {
+		fill = 1
+	}
+	per := max(int(float64(b.order)*fill), b.minEntries())
+
+	var items []BPTreeItem[K, V]
+	for it.Next() {
+		key := it.Key()
+		if n := len(items); n > 0 && items[n-1].Key >= key {
+			return nil, fmt.Errorf("%w: %v after %v", ErrUnsorted, key, items[n-1].Key)
+		}
+		items = append(items, BPTreeItem[K, V]{Key: key, Value: it.Value()})
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("bulk load: %w", err)
+	}
+	b.build(items, per)
+	return b, nil
+}
+
+// build replaces the content of the empty tree b with items, sorted by key,
+// packing per entries in every node.
+func (b *BPTree[K, V]) build(items []BPTreeItem[K, V], per int) {
+	if len(items) == 0 {
+		return
+	}
+
+	b.size.Store(int64(len(items)))
+	var level []*BPTreeNode[K, V]
+	var prev *BPTreeNode[K, V]
+	for _, size := range b.groupSizes(len(items), per) {
+		leaf := &BPTreeNode[K, V]{IsLeaf: true, Items: items[:size:size], Prev: prev}
+		leaf.count.Store(int64(size))
+		leaf.MaxKey = leaf.Items[size-1].Key
+		items = items[size:]
+		if prev != nil {
+			prev.Next = leaf
+		}
+		level = append(level, leaf)
+		prev = leaf
+	}
+
+	for len(level) > 1 {
+		var parents []*BPTreeNode[K, V]
+		for _, size := range b.groupSizes(len(level), per) {
+			parent := &BPTreeNode[K, V]{Nodes: level[:size:size]}
+			for _, child := range parent.Nodes {
+				child.ParentNode = parent
+				parent.count.Add(child.count.Load())
+			}
+			parent.MaxKey = parent.Nodes[size-1].MaxKey
+			level = level[size:]
+			parents = append(parents, parent)
+		}
+		level = parents
+	}
+	b.root = level[0]
+}
+
+// groupSizes splits n entries into nodes of per entries. A short last node
+// takes entries from the one before it, or is merged into it when both fit
+// in one node.
+func (b *BPTree[K, V]) groupSizes(n, per int) []int {
+	var sizes []int
+	for ; n > 0; n -= min(per, n) {
+		sizes = append(sizes, min(per, n))
+	}
+	if k := len(sizes); k > 1 && sizes[k-1] < b.minEntries() {
+		total := sizes[k-2] + sizes[k-1]
+		if total <= b.order {
+			return append(sizes[:k-2], total)
+		}
+		sizes[k-2], sizes[k-1] = total-total/2, total/2
+	}
+	return sizes
+}
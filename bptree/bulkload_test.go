@@ -0,0 +1,97 @@
+package bptree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type sliceLoader struct {
+	keys []int
+	pos  int
+	err  error
+}
+
+func (l *sliceLoader) Next() bool {
+	l.pos++
+	return l.pos <= len(l.keys)
+}
+
+func (l *sliceLoader) Key() int { return l.keys[l.pos-1] }
+
+func (l *sliceLoader) Value() string { return fmt.Sprintf("value_%d", l.keys[l.pos-1]) }
+
+func (l *sliceLoader) Err() error { return l.err }
+
+func TestBulkLoad(t *testing.T) {
+	for _, order := range []int{3, 4, 7, 32} {
+		for _, fill := range []float64{0, 0.5, 0.7, 1} {
+			for _, n := range []int{0, 1, 2, order, order + 1, 1000, 1001} {
+				keys := make([]int, n)
+				for i := range keys {
+					keys[i] = i * 3
+				}
+				tree, err := BulkLoad[int, string](order, fill, &sliceLoader{keys: keys})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := tree.CheckInvariants(); err != nil {
+					t.Fatalf("order %d fill %v n %d: %v", order, fill, n, err)
+				}
+				if tree.Len() != n {
+					t.Fatalf("order %d fill %v: Len %d, want %d", order, fill, tree.Len(), n)
+				}
+				for _, k := range keys {
+					if v := tree.Get(k); len(v) != 1 || v[0] != fmt.Sprintf("value_%d", k) {
+						t.Fatalf("order %d fill %v n %d: get %d = %v", order, fill, n, k, v)
+					}
+				}
+
+				// the loaded tree takes writes like any other
+				for i := 0; i < n; i += 2 {
+					tree.Delete(keys[i])
+				}
+				for i := 0; i < 50; i++ {
+					tree.Insert(i*3+1, "new")
+				}
+				if err := tree.CheckInvariants(); err != nil {
+					t.Fatalf("order %d fill %v n %d after writes: %v", order, fill, n, err)
+				}
+			}
+		}
+	}
+}
+
+func TestBulkLoadFill(t *testing.T) {
+	keys := make([]int, 1000)
+	for i := range keys {
+		keys[i] = i
+	}
+	full, _ := BulkLoad[int, string](10, 1, &sliceLoader{keys: keys})
+	half, _ := BulkLoad[int, string](10, 0.5, &sliceLoader{keys: keys})
+	leaves := func(tree *BPTree[int, string]) int {
+		n := 0
+		for leaf := tree.leftmost(); leaf != nil; leaf = leaf.Next {
+			n++
+		}
+		return n
+	}
+	if leaves(full) != 100 || leaves(half) != 200 {
+		t.Errorf("%d leaves at fill 1, %d at fill 0.5", leaves(full), leaves(half))
+	}
+}
+
+func TestBulkLoadUnsorted(t *testing.T) {
+	for _, keys := range [][]int{{1, 3, 2}, {1, 1}} {
+		if _, err := BulkLoad[int, string](4, 1, &sliceLoader{keys: keys}); !errors.Is(err, ErrUnsorted) {
+			t.Errorf("keys %v: %v", keys, err)
+		}
+	}
+}
+
+func TestBulkLoadLoaderError(t *testing.T) {
+	failed := errors.New("read failed")
+	if _, err := BulkLoad[int, string](4, 1, &sliceLoader{keys: []int{1, 2}, err: failed}); !errors.Is(err, failed) {
+		t.Errorf("loader error not wrapped: %v", err)
+	}
+}
@@ -0,0 +1,528 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// node page layout after the checksum: type, entry count and the next page,
+// the right sibling of a leaf or the head of the free list for a free page
+const (
+	pageTypeOffset  = pageChecksumSize
+	pageCountOffset = pageTypeOffset + 1
+	pageNextOffset  = pageCountOffset + 2
+	pageHeaderSize  = pageNextOffset + 8
+)
+
+var ErrEntryTooLarge = errors.New("entry too large for a page")
+
+// DiskOptions configures a DiskBPTree.
+type DiskOptions struct {
+	// PageSize is fixed when the file is created.
+	PageSize int
+	// PoolCapacity is the number of pages the buffer pool holds in memory.
+	PoolCapacity int
+	Eviction     EvictionPolicy
+}
+
+func DefaultDiskOptions() DiskOptions {
+	return DiskOptions{PageSize: DefaultPageSize, PoolCapacity: 256, Eviction: EvictLRU}
+}
+
+// DiskBPTree is a B+ tree of byte keys and values, ordered bytewise, stored
+// in the pages of a file and cached by a BufferPool. Leaves hold the entries
+// and are chained to their right sibling for range scans; internal nodes hold
+// n separator keys and n+1 children, child i holding the keys below key i.
+type DiskBPTree struct {
+	mu    sync.RWMutex
+	pager *Pager
+	pool  *BufferPool
+	// maxEntry bounds key plus value so that a split node always fits.
+	maxEntry int
+}
+
+// diskNode is a page decoded into memory.
+type diskNode struct {
+	leaf     bool
+	next     PageID
+	keys     [][]byte
+	values   [][]byte
+	children []PageID
+}
+
+// OpenDiskBPTree opens the tree stored at path, creating an empty one when
+// the file does not exist.
+func OpenDiskBPTree(path string, opts DiskOptions) (*DiskBPTree, error) {
+	if opts.PageSize == 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	pager, err := OpenPager(path, opts.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	t := &DiskBPTree{
+		pager:    pager,
+		pool:     NewBufferPool(pager, opts.PoolCapacity, opts.Eviction),
+		maxEntry: (opts.PageSize-pageHeaderSize)/4 - 12,
+	}
+	if pager.Root() == InvalidPage {
+		f, err := t.pool.NewPage()
+		if err != nil {
+			pager.Close()
+			return nil, err
+		}
+		encodeNode(f.Data, &diskNode{leaf: true})
+		t.pool.Unpin(f, true)
+		pager.SetRoot(f.ID)
+	}
+	return t, nil
+}
+
+// Pool returns the buffer pool of the tree.
+func (t *DiskBPTree) Pool() *BufferPool { return t.pool }
+
+// Pager returns the pager of the tree.
+func (t *DiskBPTree) Pager() *Pager { return t.pager }
+
+func (t *DiskBPTree) Get(key []byte) ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	id := t.pager.Root()
+	for {
+		n, err := t.readNode(id)
+		if err != nil {
+			return nil, err
+		}
+		if n.leaf {
+			if i, ok := n.search(key); ok {
+				return n.values[i], nil
+			}
+			return nil, utils.ErrNotFound
+		}
+		id = n.children[n.childIndex(key)]
+	}
+}
+
+// Put inserts key or replaces its value.
+func (t *DiskBPTree) Put(key, value []byte) error {
+	if len(key)+len(value) > t.maxEntry {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrEntryTooLarge, len(key)+len(value), t.maxEntry)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	root := t.pager.Root()
+	sep, right, err := t.insert(root, key, value)
+	if err != nil || right == InvalidPage {
+		return err
+	}
+	return t.writeNew(&diskNode{keys: [][]byte{sep}, children: []PageID{root, right}}, func(id PageID) {
+		t.pager.SetRoot(id)
+	})
+}
+
+// insert puts key in the subtree of page id. When the node splits, it returns
+// the first key of the new right node and its page.
+func (t *DiskBPTree) insert(id PageID, key, value []byte) ([]byte, PageID, error) {
+	n, err := t.readNode(id)
+	if err != nil {
+		return nil, InvalidPage, err
+	}
+	if n.leaf {
+		i, ok := n.search(key)
+		if ok {
+			n.values[i] = clone(value)
+		} else {
+			n.keys = insertAt(n.keys, i, clone(key))
+			n.values = insertAt(n.values, i, clone(value))
+		}
+	} else {
+		i := n.childIndex(key)
+		sep, right, err := t.insert(n.children[i], key, value)
+		if err != nil || right == InvalidPage {
+			return nil, InvalidPage, err
+		}
+		n.keys = insertAt(n.keys, i, sep)
+		n.children = insertAt(n.children, i+1, right)
+	}
+	if n.size() <= t.pager.PageSize() {
+		return nil, InvalidPage, t.writeNode(id, n)
+	}
+	return t.split(id, n)
+}
+
+// split moves the upper half of the bytes of n to a new page.
+func (t *DiskBPTree) split(id PageID, n *diskNode) ([]byte, PageID, error) {
+	mid := n.splitPoint()
+	right := &diskNode{leaf: n.leaf}
+	var sep []byte
+	if n.leaf {
+		right.keys = append(right.keys, n.keys[mid:]...)
+		right.values = append(right.values, n.values[mid:]...)
+		n.keys, n.values = n.keys[:mid], n.values[:mid]
+		sep = right.keys[0]
+	} else {
+		// the middle key moves up
+		sep = n.keys[mid]
+		right.keys = append(right.keys, n.keys[mid+1:]...)
+		right.children = append(right.children, n.children[mid+1:]...)
+		n.keys, n.children = n.keys[:mid], n.children[:mid+1]
+	}
+	right.next = n.next
+	var rightID PageID
+	if err := t.writeNew(right, func(rid PageID) { rightID = rid }); err != nil {
+		return nil, InvalidPage, err
+	}
+	if n.leaf {
+		n.next = rightID
+	}
+	if err := t.writeNode(id, n); err != nil {
+		return nil, InvalidPage, err
+	}
+	return sep, rightID, nil
+}
+
+// Delete removes key. Leaves are not merged, but an emptied leaf is taken out
+// of the leaf chain and its parent and its page freed, along with every
+// internal node left without children.
+func (t *DiskBPTree) Delete(key []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var path []pathEntry
+	id := t.pager.Root()
+	for {
+		n, err := t.readNode(id)
+		if err != nil {
+			return err
+		}
+		if !n.leaf {
+			i := n.childIndex(key)
+			path = append(path, pathEntry{id: id, n: n, idx: i})
+			id = n.children[i]
+			continue
+		}
+		i, ok := n.search(key)
+		if !ok {
+			return utils.ErrNotFound
+		}
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.values = append(n.values[:i], n.values[i+1:]...)
+		if len(n.keys) > 0 || len(path) == 0 {
+			return t.writeNode(id, n)
+		}
+		return t.freeLeaf(path, id, n)
+	}
+}
+
+// pathEntry is an internal node on the way down to a leaf and the index of
+// the child taken.
+type pathEntry struct {
+	id  PageID
+	n   *diskNode
+	idx int
+}
+
+// freeLeaf unlinks the empty leaf id, reached through path, and frees its
+// page. The root is shrunk while it is an internal node of a single child.
+func (t *DiskBPTree) freeLeaf(path []pathEntry, id PageID, leaf *diskNode) error {
+	prev, err := t.prevLeaf(path)
+	if err != nil {
+		return err
+	}
+	if prev != InvalidPage {
+		p, err := t.readNode(prev)
+		if err != nil {
+			return err
+		}
+		p.next = leaf.next
+		if err := t.writeNode(prev, p); err != nil {
+			return err
+		}
+	}
+
+	for k := len(path) - 1; k >= 0; k-- {
+		if err := t.pool.FreePage(id); err != nil {
+			return err
+		}
+		parent := path[k]
+		n, i := parent.n, parent.idx
+		n.children = append(n.children[:i], n.children[i+1:]...)
+		if len(n.keys) > 0 {
+			j := max(i-1, 0)
+			n.keys = append(n.keys[:j], n.keys[j+1:]...)
+		}
+		if len(n.children) > 0 {
+			if err := t.writeNode(parent.id, n); err != nil {
+				return err
+			}
+			break
+		}
+		if k == 0 {
+			// the root lost its last child
+			if err := t.writeNode(parent.id, &diskNode{leaf: true}); err != nil {
+				return err
+			}
+		}
+		id = parent.id
+	}
+
+	for {
+		root := t.pager.Root()
+		n, err := t.readNode(root)
+		if err != nil {
+			return err
+		}
+		if n.leaf || len(n.children) > 1 {
+			return nil
+		}
+		t.pager.SetRoot(n.children[0])
+		if err := t.pool.FreePage(root); err != nil {
+			return err
+		}
+	}
+}
+
+// prevLeaf returns the leaf left of the one reached through path, or
+// InvalidPage for the leftmost leaf.
+func (t *DiskBPTree) prevLeaf(path []pathEntry) (PageID, error) {
+	for k := len(path) - 1; k >= 0; k-- {
+		if path[k].idx == 0 {
+			continue
+		}
+		id := path[k].n.children[path[k].idx-1]
+		for {
+			n, err := t.readNode(id)
+			if err != nil {
+				return InvalidPage, err
+			}
+			if n.leaf {
+				return id, nil
+			}
+			id = n.children[len(n.children)-1]
+		}
+	}
+	return InvalidPage, nil
+}
+
+// Range calls fn with the entries whose key is in [start, end), in key order,
+// until fn returns false. A nil end scans to the last key.
+func (t *DiskBPTree) Range(start, end []byte, fn func(key, value []byte) bool) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	id := t.pager.Root()
+	for {
+		n, err := t.readNode(id)
+		if err != nil {
+			return err
+		}
+		if n.leaf {
+			break
+		}
+		id = n.children[n.childIndex(start)]
+	}
+	for id != InvalidPage {
+		n, err := t.readNode(id)
+		if err != nil {
+			return err
+		}
+		i, _ := n.search(start)
+		for ; i < len(n.keys); i++ {
+			if end != nil && bytes.Compare(n.keys[i], end) >= 0 {
+				return nil
+			}
+			if !fn(n.keys[i], n.values[i]) {
+				return nil
+			}
+		}
+		id = n.next
+	}
+	return nil
+}
+
+// Sync writes the dirty pages and the meta page and syncs the file.
+func (t *DiskBPTree) Sync() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.pool.FlushAll(); err != nil {
+		return err
+	}
+	return t.pager.Sync()
+}
+
+func (t *DiskBPTree) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.pool.FlushAll(); err != nil {
+		t.pager.Close()
+		return err
+	}
+	return t.pager.Close()
+}
+
+func (t *DiskBPTree) readNode(id PageID) (*diskNode, error) {
+	f, err := t.pool.Fetch(id)
+	if err != nil {
+		return nil, err
+	}
+	defer t.pool.Unpin(f, false)
+	n, err := decodeNode(f.Data)
+	if err != nil {
+		return nil, utils.NewCorruptionError(t.pager.path, uint64(id)*uint64(t.pager.PageSize()), err)
+	}
+	return n, nil
+}
+
+func (t *DiskBPTree) writeNode(id PageID, n *diskNode) error {
+	f, err := t.pool.Fetch(id)
+	if err != nil {
+		return err
+	}
+	encodeNode(f.Data, n)
+	t.pool.Unpin(f, true)
+	return nil
+}
+
+// writeNew writes n to a new page, passed to set.
+func (t *DiskBPTree) writeNew(n *diskNode, set func(PageID)) error {
+	f, err := t.pool.NewPage()
+	if err != nil {
+		return err
+	}
+	encodeNode(f.Data, n)
+	set(f.ID)
+	t.pool.Unpin(f, true)
+	return nil
+}
+
+// search returns the index of the first key >= key and whether it is key.
+func (n *diskNode) search(key []byte) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(n.keys[i], key) >= 0 })
+	return i, i < len(n.keys) && bytes.Equal(n.keys[i], key)
+}
+
+// childIndex returns the child of an internal node holding key.
+func (n *diskNode) childIndex(key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return bytes.Compare(key, n.keys[i]) < 0 })
+}
+
+// size returns the bytes of the encoded node.
+func (n *diskNode) size() int {
+	size := pageHeaderSize
+	for i, k := range n.keys {
+		size += 2 + len(k)
+		if n.leaf {
+			size += 4 + len(n.values[i])
+		}
+	}
+	if !n.leaf {
+		size += 8 * len(n.children)
+	}
+	return size
+}
+
+// splitPoint returns the first key of the upper half of the bytes of n,
+// leaving at least one key on each side.
+func (n *diskNode) splitPoint() int {
+	half, cum := (n.size()-pageHeaderSize)/2, 0
+	for i, k := range n.keys {
+		cum += 2 + len(k) + 8
+		if n.leaf {
+			cum += 4 + len(n.values[i]) - 8
+		}
+		if cum >= half {
+			return min(max(i, 1), len(n.keys)-1)
+		}
+	}
+	return len(n.keys) / 2
+}
+
+// encodeNode writes n into page: a leaf as key length, value length, key and
+// value per entry; an internal node as its first child then key length, key
+// and child per key.
+func encodeNode(page []byte, n *diskNode) {
+	clear(page[pageChecksumSize:])
+	page[pageTypeOffset] = pageInternal
+	if n.leaf {
+		page[pageTypeOffset] = pageLeaf
+	}
+	binary.LittleEndian.PutUint16(page[pageCountOffset:], uint16(len(n.keys)))
+	binary.LittleEndian.PutUint64(page[pageNextOffset:], uint64(n.next))
+	b := page[pageHeaderSize:pageHeaderSize]
+	if !n.leaf {
+		b = binary.LittleEndian.AppendUint64(b, uint64(n.children[0]))
+	}
+	for i, k := range n.keys {
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(k)))
+		if n.leaf {
+			b = binary.LittleEndian.AppendUint32(b, uint32(len(n.values[i])))
+			b = append(b, k...)
+			b = append(b, n.values[i]...)
+		} else {
+			b = append(b, k...)
+			b = binary.LittleEndian.AppendUint64(b, uint64(n.children[i+1]))
+		}
+	}
+}
+
+func decodeNode(page []byte) (*diskNode, error) {
+	typ := page[pageTypeOffset]
+	if typ != pageLeaf && typ != pageInternal {
+		return nil, fmt.Errorf("page of type %d is not a node", typ)
+	}
+	n := &diskNode{leaf: typ == pageLeaf, next: PageID(binary.LittleEndian.Uint64(page[pageNextOffset:]))}
+	count := int(binary.LittleEndian.Uint16(page[pageCountOffset:]))
+	b := page[pageHeaderSize:]
+	errShort := errors.New("node entries overrun the page")
+	if !n.leaf {
+		if len(b) < 8 {
+			return nil, errShort
+		}
+		n.children = append(n.children, PageID(binary.LittleEndian.Uint64(b)))
+		b = b[8:]
+	}
+	for i := 0; i < count; i++ {
+		if len(b) < 2 {
+			return nil, errShort
+		}
+		klen := int(binary.LittleEndian.Uint16(b))
+		b = b[2:]
+		if n.leaf {
+			if len(b) < 4 {
+				return nil, errShort
+			}
+			vlen := int(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+			if len(b) < klen+vlen {
+				return nil, errShort
+			}
+			n.keys = append(n.keys, clone(b[:klen]))
+			n.values = append(n.values, clone(b[klen:klen+vlen]))
+			b = b[klen+vlen:]
+			continue
+		}
+		if len(b) < klen+8 {
+			return nil, errShort
+		}
+		n.keys = append(n.keys, clone(b[:klen]))
+		n.children = append(n.children, PageID(binary.LittleEndian.Uint64(b[klen:])))
+		b = b[klen+8:]
+	}
+	return n, nil
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func clone(b []byte) []byte {
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,369 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+func TestPagerFreeList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pages")
+	p, err := OpenPager(path, MinPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []PageID
+	for i := 0; i < 4; i++ {
+		id, err := p.Allocate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, MinPageSize)
+		buf[pageHeaderSize] = byte(i)
+		if err := p.Write(id, buf); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := p.Free(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Free(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err = OpenPager(path, MinPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.FreePages() != 2 || p.NumPages() != 5 {
+		t.Fatalf("free %d pages %d after reopen", p.FreePages(), p.NumPages())
+	}
+	for _, want := range []PageID{ids[2], ids[1], 5} {
+		if id, err := p.Allocate(); err != nil || id != want {
+			t.Fatalf("allocated %d, %v; want %d", id, err, want)
+		}
+	}
+	if _, err := OpenPager(path, 2*MinPageSize); !errors.Is(err, ErrPageSize) {
+		t.Errorf("page size mismatch not detected: %v", err)
+	}
+}
+
+func TestPagerChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pages")
+	p, err := OpenPager(path, MinPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := p.Allocate()
+	buf := make([]byte, MinPageSize)
+	copy(buf[pageHeaderSize:], "payload")
+	if err := p.Write(id, buf); err != nil {
+		t.Fatal(err)
+	}
+	p.Close()
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte{'X'}, int64(id)*MinPageSize+pageHeaderSize)
+	f.Close()
+
+	p, err = OpenPager(path, MinPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	err = p.Read(id, buf)
+	var corruption *utils.CorruptionError
+	if !errors.Is(err, utils.ErrCorruption) || !errors.As(err, &corruption) || corruption.Offset != uint64(id)*MinPageSize {
+		t.Errorf("corrupted page read: %v", err)
+	}
+}
+
+func TestBufferPoolEviction(t *testing.T) {
+	for _, policy := range []EvictionPolicy{EvictLRU, EvictClock} {
+		p, err := OpenPager(filepath.Join(t.TempDir(), "pages"), MinPageSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := NewBufferPool(p, 2, policy)
+		var ids []PageID
+		for i := 0; i < 3; i++ {
+			f, err := pool.NewPage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			encodeNode(f.Data, &diskNode{leaf: true, keys: [][]byte{{byte(i)}}, values: [][]byte{nil}})
+			ids = append(ids, f.ID)
+			pool.Unpin(f, true)
+		}
+
+		// the evicted dirty page was written back
+		for i, id := range ids {
+			f, err := pool.Fetch(id)
+			if err != nil {
+				t.Fatal(policy, err)
+			}
+			if n, err := decodeNode(f.Data); err != nil || n.keys[0][0] != byte(i) {
+				t.Errorf("policy %d page %d: %v %v", policy, id, n, err)
+			}
+			pool.Unpin(f, false)
+		}
+
+		a, _ := pool.Fetch(ids[0])
+		b, _ := pool.Fetch(ids[1])
+		if _, err := pool.Fetch(ids[2]); !errors.Is(err, ErrPoolFull) {
+			t.Errorf("policy %d: fetch with every frame pinned: %v", policy, err)
+		}
+		pool.Unpin(a, false)
+		pool.Unpin(b, false)
+		if _, misses := pool.Stats(); misses < 3 {
+			t.Errorf("policy %d: %d misses", policy, misses)
+		}
+		p.Close()
+	}
+}
+
+func TestLRUReplacerOrder(t *testing.T) {
+	r := newLRUReplacer()
+	r.unpin(0)
+	r.unpin(1)
+	r.unpin(2)
+	r.unpin(0)
+	r.pin(1)
+	for _, want := range []int{2, 0} {
+		if idx, ok := r.victim(); !ok || idx != want {
+			t.Fatalf("victim %d, want %d", idx, want)
+		}
+	}
+	if _, ok := r.victim(); ok {
+		t.Error("victim from an empty replacer")
+	}
+}
+
+func TestClockReplacerSecondChance(t *testing.T) {
+	r := newClockReplacer(3)
+	r.unpin(0)
+	r.unpin(1)
+	r.unpin(2)
+	// every frame is referenced: the sweep clears them and takes the first
+	if idx, _ := r.victim(); idx != 0 {
+		t.Fatalf("victim %d, want 0", idx)
+	}
+	r.unpin(1)
+	if idx, _ := r.victim(); idx != 2 {
+		t.Fatalf("victim %d, want 2 after 1 is used again", idx)
+	}
+}
+
+func TestDiskBPTree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	opts := DiskOptions{PageSize: MinPageSize, PoolCapacity: 8, Eviction: EvictClock}
+	tree, err := OpenDiskBPTree(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := make(map[string]string)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 3000; i++ {
+		k := fmt.Sprintf("key%05d", rnd.Intn(2000))
+		v := fmt.Sprintf("value%d", i)
+		if err := tree.Put([]byte(k), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+		want[k] = v
+	}
+	for i := 0; i < 2000; i += 3 {
+		k := fmt.Sprintf("key%05d", i)
+		err := tree.Delete([]byte(k))
+		if _, ok := want[k]; ok != (err == nil) {
+			t.Fatalf("delete %s: %v", k, err)
+		}
+		delete(want, k)
+	}
+	if err := tree.Put([]byte("big"), make([]byte, MinPageSize)); !errors.Is(err, ErrEntryTooLarge) {
+		t.Errorf("large entry: %v", err)
+	}
+	if tree.Pager().NumPages() < 20 {
+		t.Errorf("tree of %d pages did not split", tree.Pager().NumPages())
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = OpenDiskBPTree(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 2000; i++ {
+		k := fmt.Sprintf("key%05d", i)
+		v, err := tree.Get([]byte(k))
+		if w, ok := want[k]; ok {
+			if err != nil || string(v) != w {
+				t.Fatalf("get %s: %q %v, want %q", k, v, err, w)
+			}
+		} else if !errors.Is(err, utils.ErrNotFound) {
+			t.Fatalf("get deleted %s: %q %v", k, v, err)
+		}
+	}
+
+	var prev []byte
+	n := 0
+	err = tree.Range([]byte("key00100"), []byte("key01000"), func(k, v []byte) bool {
+		if bytes.Compare(k, prev) <= 0 || string(k) < "key00100" || string(k) >= "key01000" {
+			t.Fatalf("range returned %s after %s", k, prev)
+		}
+		if want[string(k)] != string(v) {
+			t.Fatalf("range %s: %s", k, v)
+		}
+		prev = append(prev[:0], k...)
+		n++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := 0
+	for k := range want {
+		if k >= "key00100" && k < "key01000" {
+			expected++
+		}
+	}
+	if n != expected {
+		t.Errorf("range returned %d entries, want %d", n, expected)
+	}
+	if hits, _ := tree.Pool().Stats(); hits == 0 {
+		t.Error("no buffer pool hits")
+	}
+}
+
+func TestDiskBPTreeFreesEmptyLeaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tree")
+	tree, err := OpenDiskBPTree(path, DiskOptions{PageSize: MinPageSize, PoolCapacity: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	value := bytes.Repeat([]byte{'v'}, 40)
+	for i := 0; i < 2000; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%05d", i)), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages := tree.Pager().NumPages()
+
+	// empty the middle and the ends of the key space
+	for i := 0; i < 2000; i++ {
+		if i >= 200 && i < 1000 || i >= 1500 && i < 1900 {
+			continue
+		}
+		if err := tree.Delete([]byte(fmt.Sprintf("key%05d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	freed := tree.Pager().FreePages()
+	if freed < int(pages)/3 {
+		t.Fatalf("%d of %d pages freed", freed, pages)
+	}
+	var keys []string
+	if err := tree.Range([]byte(""), nil, func(k, v []byte) bool {
+		keys = append(keys, string(k))
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1200 || keys[0] != "key00200" || keys[799] != "key00999" || keys[800] != "key01500" {
+		t.Fatalf("range after deletes returned %d keys", len(keys))
+	}
+
+	// the freed pages are used before the file grows
+	for i := 0; i < 200; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%05d", i)), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tree.Pager().NumPages() != pages || tree.Pager().FreePages() >= freed {
+		t.Errorf("pages %d free %d after inserts, had %d free of %d", tree.Pager().NumPages(), tree.Pager().FreePages(), freed, pages)
+	}
+	for i := 0; i < 2000; i++ {
+		_, err := tree.Get([]byte(fmt.Sprintf("key%05d", i)))
+		if want := i < 1000 || i >= 1500 && i < 1900; want != (err == nil) {
+			t.Fatalf("get key%05d: %v", i, err)
+		}
+	}
+
+	// deleting everything leaves an empty root leaf
+	for i := 0; i < 2000; i++ {
+		tree.Delete([]byte(fmt.Sprintf("key%05d", i)))
+	}
+	if tree.Pager().FreePages() != int(tree.Pager().NumPages())-2 {
+		t.Errorf("%d of %d pages free in an empty tree", tree.Pager().FreePages(), tree.Pager().NumPages())
+	}
+	if err := tree.Put([]byte("again"), value); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := tree.Get([]byte("again")); err != nil || !bytes.Equal(v, value) {
+		t.Errorf("get after emptying: %q %v", v, err)
+	}
+}
+
+func TestPagerLimits(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenPager(filepath.Join(dir, "big"), 2*MaxPageSize); !errors.Is(err, ErrPageSize) {
+		t.Errorf("page size above the maximum: %v", err)
+	}
+
+	// two free pages pointing at each other
+	path := filepath.Join(dir, "cycle")
+	p, err := OpenPager(path, MinPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := p.Allocate()
+	b, _ := p.Allocate()
+	p.Free(a)
+	p.Free(b)
+	buf := make([]byte, MinPageSize)
+	buf[pageTypeOffset] = pageFree
+	binary.LittleEndian.PutUint64(buf[pageNextOffset:], uint64(b))
+	p.Write(a, buf)
+	p.Close()
+	if _, err := OpenPager(path, MinPageSize); !errors.Is(err, utils.ErrCorruption) {
+		t.Errorf("free list cycle: %v", err)
+	}
+}
+
+func TestBufferPoolUnpinTwice(t *testing.T) {
+	p, err := OpenPager(filepath.Join(t.TempDir(), "pages"), MinPageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	pool := NewBufferPool(p, 2, EvictLRU)
+	f, err := pool.NewPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Unpin(f, true)
+	defer func() {
+		if recover() == nil {
+			t.Error("second unpin did not panic")
+		}
+	}()
+	pool.Unpin(f, false)
+}
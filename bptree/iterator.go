@@ -0,0 +1,223 @@
+package bptree
+
+import "sort"
+
+// Iterator walks the items of a BPTree in key order. It holds a copy of the
+// current leaf, taken under the structure latch and the leaf latch, and finds
+// the next leaf again from the last key, so it may be used while the tree is
+// written: every leaf is seen as of some point of the walk.
+type Iterator[K Comparable, V any] struct {
+	tree    *BPTree[K, V]
+	items   []BPTreeItem[K, V]
+	pos     int
+	started bool
+}
+
+// NewIterator returns an iterator before the first item: Next moves it to the
+// first item and Prev to the last.
+func (b *BPTree[K, V]) NewIterator() *Iterator[K, V] {
+	return &Iterator[K, V]{tree: b}
+}
+
+// Valid reports whether the iterator is at an item.
+func (it *Iterator[K, V]) Valid() bool {
+	return it.pos >= 0 && it.pos < len(it.items)
+}
+
+// Seek moves to the first item whose key is >= key.
+func (it *Iterator[K, V]) Seek(key K) bool {
+	it.started = true
+	return it.after(key, true)
+}
+
+// First moves to the smallest item.
+func (it *Iterator[K, V]) First() bool {
+	it.started = true
+	b := it.tree
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return it.forward(b.leftmost(), func([]BPTreeItem[K, V]) int { return 0 })
+}
+
+// Last moves to the largest item.
+func (it *Iterator[K, V]) Last() bool {
+	it.started = true
+	b := it.tree
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return it.backward(b.rightmost(), func(items []BPTreeItem[K, V]) int { return len(items) - 1 })
+}
+
+func (it *Iterator[K, V]) Next() bool {
+	if !it.started {
+		return it.First()
+	}
+	if !it.Valid() {
+		return false
+	}
+	if it.pos++; it.pos < len(it.items) {
+		return true
+	}
+	return it.after(it.items[it.pos-1].Key, false)
+}
+
+func (it *Iterator[K, V]) Prev() bool {
+	if !it.started {
+		return it.Last()
+	}
+	if !it.Valid() {
+		return false
+	}
+	if it.pos--; it.pos >= 0 {
+		return true
+	}
+	return it.before(it.items[0].Key)
+}
+
+func (it *Iterator[K, V]) Key() K { return it.items[it.pos].Key }
+
+func (it *Iterator[K, V]) Value() V { return it.items[it.pos].Value }
+
+// after moves to the first item above key, or at key when inclusive.
+func (it *Iterator[K, V]) after(key K, inclusive bool) bool {
+	b := it.tree
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return it.forward(b.findChildNode(b.root, key), func(items []BPTreeItem[K, V]) int {
+		return sort.Search(len(items), func(i int) bool {
+			return items[i].Key > key || inclusive && items[i].Key == key
+		})
+	})
+}
+
+// before moves to the last item below key.
+func (it *Iterator[K, V]) before(key K) bool {
+	b := it.tree
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return it.backward(b.findChildNode(b.root, key), func(items []BPTreeItem[K, V]) int {
+		return sort.Search(len(items), func(i int) bool { return items[i].Key >= key }) - 1
+	})
+}
+
+// forward copies leaf and sets pos to start(items), moving to the next leaves
+// while pos is past their items. The structure latch must be held.
+func (it *Iterator[K, V]) forward(leaf *BPTreeNode[K, V], start func([]BPTreeItem[K, V]) int) bool {
+	it.items, it.pos = nil, 0
+	if leaf == nil {
+		return false
+	}
+	it.items = leaf.items()
+	for it.pos = start(it.items); it.pos >= len(it.items); it.pos = 0 {
+		if leaf = leaf.Next; leaf == nil {
+			it.items = nil
+			return false
+		}
+		it.items = leaf.items()
+	}
+	return true
+}
+
+// backward copies leaf and sets pos to start(items), moving to the previous
+// leaves while pos is before their items. The structure latch must be held.
+func (it *Iterator[K, V]) backward(leaf *BPTreeNode[K, V], start func([]BPTreeItem[K, V]) int) bool {
+	it.items, it.pos = nil, 0
+	if leaf == nil {
+		return false
+	}
+	it.items = leaf.items()
+	for it.pos = start(it.items); it.pos < 0; it.pos = len(it.items) - 1 {
+		if leaf = leaf.Prev; leaf == nil {
+			it.items = nil
+			return false
+		}
+		it.items = leaf.items()
+	}
+	return true
+}
+
+func (b *BPTree[K, V]) leftmost() *BPTreeNode[K, V] {
+	node := b.root
+	for !node.IsLeaf {
+		node = node.Nodes[0]
+	}
+	return node
+}
+
+func (b *BPTree[K, V]) rightmost() *BPTreeNode[K, V] {
+	node := b.root
+	for !node.IsLeaf {
+		node = node.Nodes[len(node.Nodes)-1]
+	}
+	return node
+}
+
+// Ascend calls fn with every item in ascending key order until fn returns
+// false. Each leaf is copied under its latch, so fn sees every leaf as of
+// some point of the scan; fn must not write the tree.
+func (b *BPTree[K, V]) Ascend(fn func(key K, value V) bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for leaf := b.leftmost(); leaf != nil; leaf = leaf.Next {
+		for _, item := range leaf.items() {
+			if !fn(item.Key, item.Value) {
+				return
+			}
+		}
+	}
+}
+
+// AscendRange calls fn with the items whose key is in [start, end) in
+// ascending key order until fn returns false.
+func (b *BPTree[K, V]) AscendRange(start, end K, fn func(key K, value V) bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for leaf := b.findChildNode(b.root, start); leaf != nil; leaf = leaf.Next {
+		for _, item := range leaf.items() {
+			if item.Key < start {
+				continue
+			}
+			if item.Key >= end || !fn(item.Key, item.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Descend calls fn with every item in descending key order until fn returns
+// false.
+func (b *BPTree[K, V]) Descend(fn func(key K, value V) bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for leaf := b.rightmost(); leaf != nil; leaf = leaf.Prev {
+		items := leaf.items()
+		for i := len(items) - 1; i >= 0; i-- {
+			if !fn(items[i].Key, items[i].Value) {
+				return
+			}
+		}
+	}
+}
+
+// Min returns the smallest item; ok is false for an empty tree.
+func (b *BPTree[K, V]) Min() (key K, value V, ok bool) {
+	b.Ascend(func(k K, v V) bool {
+		key, value, ok = k, v, true
+		return false
+	})
+	return key, value, ok
+}
+
+// Max returns the largest item; ok is false for an empty tree.
+func (b *BPTree[K, V]) Max() (key K, value V, ok bool) {
+	b.Descend(func(k K, v V) bool {
+		key, value, ok = k, v, true
+		return false
+	})
+	return key, value, ok
+}
+
+// Len returns the number of items.
+func (b *BPTree[K, V]) Len() int {
+	return int(b.size.Load())
+}
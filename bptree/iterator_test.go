@@ -0,0 +1,124 @@
+package bptree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	tree := NewBPTree[int, int](4)
+	it := tree.NewIterator()
+	if it.Next() || it.Prev() || it.Seek(0) {
+		t.Fatal("iterator over an empty tree is valid")
+	}
+	if _, _, ok := tree.Min(); ok {
+		t.Fatal("min of an empty tree")
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	var keys []int
+	for _, k := range rnd.Perm(500) {
+		tree.Insert(k*2, k)
+		keys = append(keys, k*2)
+	}
+	for _, k := range keys[:100] {
+		tree.Delete(k)
+	}
+	keys = keys[100:]
+	sort.Ints(keys)
+	if tree.Len() != len(keys) {
+		t.Fatalf("Len %d, want %d", tree.Len(), len(keys))
+	}
+
+	var got []int
+	for it := tree.NewIterator(); it.Next(); {
+		if it.Value()*2 != it.Key() {
+			t.Fatalf("key %d has value %d", it.Key(), it.Value())
+		}
+		got = append(got, it.Key())
+	}
+	if !equalInts(got, keys) {
+		t.Fatalf("forward iteration returned %d keys, want %d", len(got), len(keys))
+	}
+	got = got[:0]
+	for it := tree.NewIterator(); it.Prev(); {
+		got = append(got, it.Key())
+	}
+	for i, j := 0, len(got)-1; i < j; i, j = i+1, j-1 {
+		got[i], got[j] = got[j], got[i]
+	}
+	if !equalInts(got, keys) {
+		t.Fatal("backward iteration does not match")
+	}
+
+	for _, target := range []int{-1, keys[0], keys[10] + 1, keys[len(keys)-1], keys[len(keys)-1] + 1} {
+		i := sort.SearchInts(keys, target)
+		ok := it.Seek(target)
+		if ok != (i < len(keys)) || ok && it.Key() != keys[i] {
+			t.Fatalf("seek %d: %v", target, ok)
+		}
+	}
+	it.Seek(keys[10] + 1)
+	if !it.Prev() || it.Key() != keys[10] || !it.Next() || it.Key() != keys[11] {
+		t.Fatal("Prev and Next around a seek")
+	}
+	if it.Last(); it.Next() || it.Valid() {
+		t.Fatal("Next past the last item")
+	}
+
+	if k, _, _ := tree.Min(); k != keys[0] {
+		t.Errorf("min %d, want %d", k, keys[0])
+	}
+	if k, v, _ := tree.Max(); k != keys[len(keys)-1] || v*2 != k {
+		t.Errorf("max %d %d, want %d", k, v, keys[len(keys)-1])
+	}
+}
+
+func TestAscendDescend(t *testing.T) {
+	tree := NewBPTree[string, int](3)
+	for i, k := range []string{"d", "b", "a", "e", "c", "f"} {
+		tree.Insert(k, i)
+	}
+
+	var got string
+	tree.Ascend(func(k string, _ int) bool {
+		got += k
+		return k != "d"
+	})
+	if got != "abcd" {
+		t.Errorf("ascend stopped at %q", got)
+	}
+	got = ""
+	tree.Descend(func(k string, _ int) bool {
+		got += k
+		return true
+	})
+	if got != "fedcba" {
+		t.Errorf("descend returned %q", got)
+	}
+	got = ""
+	tree.AscendRange("b", "e", func(k string, _ int) bool {
+		got += k
+		return true
+	})
+	if got != "bcd" {
+		t.Errorf("ascend range returned %q", got)
+	}
+	items := tree.Range("b", "c")
+	if len(items) != 2 || items[0].Key != "b" || items[1].Value != 4 {
+		t.Errorf("range returned %v", items)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
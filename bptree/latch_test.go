@@ -0,0 +1,170 @@
+package bptree
+
+import (
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConcurrentStress(t *testing.T) {
+	const writers, keysPerWriter = 8, 2000
+	tree := NewBPTree[int, int](8)
+	var wg sync.WaitGroup
+	var stop atomic.Bool
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(r)))
+			for !stop.Load() {
+				k := rnd.Intn(writers * keysPerWriter)
+				if v := tree.Get(k); len(v) == 1 && v[0] != k {
+					t.Errorf("key %d has value %d", k, v[0])
+					return
+				}
+				prev := -1
+				tree.AscendRange(k, k+200, func(key, value int) bool {
+					if key <= prev {
+						t.Errorf("ascend returned %d after %d", key, prev)
+					}
+					prev = key
+					return true
+				})
+				tree.Rank(k)
+				tree.Select(k % 100)
+				// let the writers in on a single CPU
+				runtime.Gosched()
+			}
+		}(r)
+	}
+
+	// each writer owns the keys equal to its index modulo writers, so the
+	// final content does not depend on the interleaving
+	var writersWg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writersWg.Add(1)
+		go func(w int) {
+			defer writersWg.Done()
+			rnd := rand.New(rand.NewSource(int64(100 + w)))
+			for _, i := range rnd.Perm(keysPerWriter) {
+				tree.Insert(i*writers+w, i*writers+w)
+			}
+			for i := 0; i < keysPerWriter; i += 2 {
+				tree.Delete(i*writers + w)
+			}
+		}(w)
+	}
+	writersWg.Wait()
+	stop.Store(true)
+	wg.Wait()
+
+	if err := tree.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Len() != writers*keysPerWriter/2 {
+		t.Fatalf("Len %d, want %d", tree.Len(), writers*keysPerWriter/2)
+	}
+	for k := 0; k < writers*keysPerWriter; k++ {
+		v := tree.Get(k)
+		if deleted := (k/writers)%2 == 0; deleted != (len(v) == 0) {
+			t.Fatalf("key %d: %v", k, v)
+		}
+	}
+	if r := tree.Rank(writers * keysPerWriter); r != tree.Len() {
+		t.Errorf("rank past the last key is %d", r)
+	}
+}
+
+func TestIteratorConcurrentWrites(t *testing.T) {
+	tree := NewBPTree[int, int](4)
+	for k := 0; k < 2000; k += 2 {
+		tree.Insert(k, k)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// odd keys come and go, splitting and merging the leaves under the
+		// iterators
+		for round := 0; round < 3; round++ {
+			for k := 1; k < 2000; k += 2 {
+				tree.Insert(k, k)
+			}
+			for k := 1; k < 2000; k += 2 {
+				tree.Delete(k)
+			}
+		}
+	}()
+
+	for pass := 0; pass < 20; pass++ {
+		prev, even := -1, 0
+		for it := tree.NewIterator(); it.Next(); {
+			if it.Key() <= prev || it.Value() != it.Key() {
+				t.Fatalf("pass %d: key %d value %d after %d", pass, it.Key(), it.Value(), prev)
+			}
+			if prev = it.Key(); prev%2 == 0 {
+				even++
+			}
+		}
+		if even != 1000 {
+			t.Fatalf("pass %d: forward walk saw %d of the 1000 stable keys", pass, even)
+		}
+		prev, even = 2000, 0
+		for it := tree.NewIterator(); it.Prev(); {
+			if it.Key() >= prev {
+				t.Fatalf("pass %d: key %d before %d", pass, it.Key(), prev)
+			}
+			if prev = it.Key(); prev%2 == 0 {
+				even++
+			}
+		}
+		if even != 1000 {
+			t.Fatalf("pass %d: backward walk saw %d of the 1000 stable keys", pass, even)
+		}
+		runtime.Gosched()
+	}
+	wg.Wait()
+}
+
+// BenchmarkParallelInsert inserts random keys from every goroutine into a
+// tree large enough for most inserts to land on distinct leaves; run it with
+// -cpu 1,2,4,8 to see how writes scale with GOMAXPROCS.
+func BenchmarkParallelInsert(b *testing.B) {
+	tree := NewBPTree[int, int](64)
+	for i := 0; i < 1<<20; i += 16 {
+		tree.Insert(i, i)
+	}
+	var seed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rnd := rand.New(rand.NewSource(seed.Add(1)))
+		for pb.Next() {
+			k := rnd.Intn(1 << 20)
+			tree.Insert(k, k)
+		}
+	})
+}
+
+// BenchmarkParallelReadWrite mixes nine lookups for every insert.
+func BenchmarkParallelReadWrite(b *testing.B) {
+	tree := NewBPTree[int, int](64)
+	for i := 0; i < 1<<20; i += 4 {
+		tree.Insert(i, i)
+	}
+	var seed atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		rnd := rand.New(rand.NewSource(seed.Add(1)))
+		for i := 0; pb.Next(); i++ {
+			k := rnd.Intn(1 << 20)
+			if i%10 == 0 {
+				tree.Insert(k, k)
+			} else {
+				tree.Get(k)
+			}
+		}
+	})
+}
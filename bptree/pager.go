@@ -0,0 +1,269 @@
+package bptree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// PageID is the index of a page in the file; page 0 holds the meta page.
+type PageID uint64
+
+const (
+	// InvalidPage is never a node: it ends the leaf chain and the free list.
+	InvalidPage PageID = 0
+
+	DefaultPageSize = 4096
+	MinPageSize     = 512
+	// MaxPageSize keeps entry counts and key lengths within their uint16
+	// fields.
+	MaxPageSize = 64 << 10
+
+	pageMagic = 0x42505431 // "BPT1"
+	// every page starts with the crc32c of the rest of the page
+	pageChecksumSize = 4
+	// the meta page holds the magic, the page size, the root, the head of the
+	// free list and the page count
+	metaSize = pageChecksumSize + 4 + 4 + 8 + 8 + 8
+)
+
+const (
+	pageFree byte = iota + 1
+	pageLeaf
+	pageInternal
+)
+
+var (
+	ErrPageSize    = errors.New("page size does not match the file")
+	ErrInvalidPage = errors.New("invalid page id")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Pager reads and writes the fixed-size pages of a file. Freed pages are
+// chained in a free list, through the next pointer of their header, and
+// handed out again before the file grows.
+type Pager struct {
+	mu       sync.Mutex
+	f        *os.File
+	path     string
+	pageSize int
+	root     PageID
+	freeHead PageID
+	numPages uint64
+	free     int
+}
+
+// OpenPager opens the paged file at path, creating it with pages of pageSize
+// bytes when it does not exist.
+func OpenPager(path string, pageSize int) (*Pager, error) {
+	if pageSize < MinPageSize || pageSize > MaxPageSize {
+		return nil, fmt.Errorf("%w: %d outside %d to %d", ErrPageSize, pageSize, MinPageSize, MaxPageSize)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.New("error in open page file : " + err.Error())
+	}
+	p := &Pager{f: f, path: path, pageSize: pageSize, numPages: 1}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if err := p.writeMeta(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return p, nil
+	}
+	if err := p.readMeta(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	for id := p.freeHead; id != InvalidPage; {
+		// a free list longer than the file loops
+		if p.free++; uint64(p.free) >= p.numPages {
+			f.Close()
+			return nil, utils.NewCorruptionError(path, uint64(id)*uint64(p.pageSize), errors.New("free list cycle"))
+		}
+		buf := make([]byte, p.pageSize)
+		if err := p.read(id, buf); err != nil {
+			f.Close()
+			return nil, err
+		}
+		id = PageID(binary.LittleEndian.Uint64(buf[pageNextOffset:]))
+	}
+	return p, nil
+}
+
+func (p *Pager) PageSize() int { return p.pageSize }
+
+// NumPages returns the pages of the file, the meta page and the free pages
+// included.
+func (p *Pager) NumPages() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.numPages
+}
+
+// FreePages returns the length of the free list.
+func (p *Pager) FreePages() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.free
+}
+
+// Root returns the page recorded as the root of the tree.
+func (p *Pager) Root() PageID {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.root
+}
+
+// SetRoot records root in the meta page, written by the next Sync.
+func (p *Pager) SetRoot(root PageID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.root = root
+}
+
+// Read reads page id into buf and verifies its checksum.
+func (p *Pager) Read(id PageID, buf []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id == InvalidPage || uint64(id) >= p.numPages {
+		return fmt.Errorf("%w: %d", ErrInvalidPage, id)
+	}
+	return p.read(id, buf)
+}
+
+func (p *Pager) read(id PageID, buf []byte) error {
+	off := int64(id) * int64(p.pageSize)
+	if _, err := p.f.ReadAt(buf[:p.pageSize], off); err != nil {
+		if errors.Is(err, io.EOF) {
+			return utils.NewCorruptionError(p.path, uint64(off), io.ErrUnexpectedEOF)
+		}
+		return errors.New("error in read page : " + err.Error())
+	}
+	if binary.LittleEndian.Uint32(buf) != crc32.Checksum(buf[pageChecksumSize:p.pageSize], crcTable) {
+		return utils.NewCorruptionError(p.path, uint64(off), errors.New("page checksum mismatch"))
+	}
+	return nil
+}
+
+// Write stamps the checksum of buf and writes it as page id.
+func (p *Pager) Write(id PageID, buf []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id == InvalidPage || uint64(id) >= p.numPages {
+		return fmt.Errorf("%w: %d", ErrInvalidPage, id)
+	}
+	return p.write(id, buf)
+}
+
+func (p *Pager) write(id PageID, buf []byte) error {
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(buf[pageChecksumSize:p.pageSize], crcTable))
+	if _, err := p.f.WriteAt(buf[:p.pageSize], int64(id)*int64(p.pageSize)); err != nil {
+		return errors.New("error in write page : " + err.Error())
+	}
+	return nil
+}
+
+// Allocate returns a page off the free list, or a new page at the end of the
+// file. The page must be written before it is read.
+func (p *Pager) Allocate() (PageID, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.freeHead == InvalidPage {
+		id := PageID(p.numPages)
+		p.numPages++
+		return id, nil
+	}
+	id := p.freeHead
+	buf := make([]byte, p.pageSize)
+	if err := p.read(id, buf); err != nil {
+		return InvalidPage, err
+	}
+	if buf[pageTypeOffset] != pageFree {
+		return InvalidPage, utils.NewCorruptionError(p.path, uint64(id)*uint64(p.pageSize), errors.New("free list holds a used page"))
+	}
+	p.freeHead = PageID(binary.LittleEndian.Uint64(buf[pageNextOffset:]))
+	p.free--
+	return id, nil
+}
+
+// Free pushes page id on the free list.
+func (p *Pager) Free(id PageID) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if id == InvalidPage || uint64(id) >= p.numPages {
+		return fmt.Errorf("%w: %d", ErrInvalidPage, id)
+	}
+	buf := make([]byte, p.pageSize)
+	buf[pageTypeOffset] = pageFree
+	binary.LittleEndian.PutUint64(buf[pageNextOffset:], uint64(p.freeHead))
+	if err := p.write(id, buf); err != nil {
+		return err
+	}
+	p.freeHead = id
+	p.free++
+	return nil
+}
+
+// Sync writes the meta page and syncs the file.
+func (p *Pager) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.writeMeta(); err != nil {
+		return err
+	}
+	return p.f.Sync()
+}
+
+func (p *Pager) Close() error {
+	if err := p.Sync(); err != nil {
+		p.f.Close()
+		return err
+	}
+	return p.f.Close()
+}
+
+func (p *Pager) writeMeta() error {
+	buf := make([]byte, p.pageSize)
+	b := buf[pageChecksumSize:]
+	binary.LittleEndian.PutUint32(b, pageMagic)
+	binary.LittleEndian.PutUint32(b[4:], uint32(p.pageSize))
+	binary.LittleEndian.PutUint64(b[8:], uint64(p.root))
+	binary.LittleEndian.PutUint64(b[16:], uint64(p.freeHead))
+	binary.LittleEndian.PutUint64(b[24:], p.numPages)
+	return p.write(0, buf)
+}
+
+func (p *Pager) readMeta() error {
+	head := make([]byte, metaSize)
+	if _, err := p.f.ReadAt(head, 0); err != nil {
+		return utils.NewCorruptionError(p.path, 0, err)
+	}
+	b := head[pageChecksumSize:]
+	if binary.LittleEndian.Uint32(b) != pageMagic {
+		return utils.NewCorruptionError(p.path, 0, errors.New("bad magic"))
+	}
+	if size := int(binary.LittleEndian.Uint32(b[4:])); size != p.pageSize {
+		return fmt.Errorf("%w: file has %d, want %d", ErrPageSize, size, p.pageSize)
+	}
+	buf := make([]byte, p.pageSize)
+	if err := p.read(0, buf); err != nil {
+		return err
+	}
+	p.root = PageID(binary.LittleEndian.Uint64(b[8:]))
+	p.freeHead = PageID(binary.LittleEndian.Uint64(b[16:]))
+	p.numPages = binary.LittleEndian.Uint64(b[24:])
+	return nil
+}
@@ -0,0 +1,67 @@
+package bptree
+
+// Rank returns the number of keys below key, the position key has or would
+// have in the tree.
+func (b *BPTree[K, V]) Rank(key K) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.rank(key, false)
+}
+
+// rank counts the keys below key, or up to key when inclusive, adding the
+// Count of the children left of the path down to the leaf of key.
+func (b *BPTree[K, V]) rank(key K, inclusive bool) int {
+	rank := 0
+	node := b.root
+	for !node.IsLeaf {
+		i := 0
+		for ; i < len(node.Nodes)-1 && key > node.Nodes[i].MaxKey; i++ {
+			rank += node.Nodes[i].Count()
+		}
+		node = node.Nodes[i]
+	}
+	for _, item := range node.items() {
+		if item.Key > key || item.Key == key && !inclusive {
+			break
+		}
+		rank++
+	}
+	return rank
+}
+
+// Select returns the item of rank i, counting from zero; ok is false when i
+// is out of range.
+func (b *BPTree[K, V]) Select(i int) (key K, value V, ok bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if i < 0 || i >= b.root.Count() {
+		return key, value, false
+	}
+	node := b.root
+	for !node.IsLeaf {
+		for _, child := range node.Nodes {
+			if i < child.Count() {
+				node = child
+				break
+			}
+			i -= child.Count()
+		}
+	}
+	// a concurrent write may change the leaf after its count was read
+	items := node.items()
+	if i >= len(items) {
+		return key, value, false
+	}
+	return items[i].Key, items[i].Value, true
+}
+
+// CountRange returns the number of keys in [start, end], the items Range
+// returns.
+func (b *BPTree[K, V]) CountRange(start, end K) int {
+	if end < start {
+		return 0
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.rank(end, true) - b.rank(start, false)
+}
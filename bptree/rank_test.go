@@ -0,0 +1,76 @@
+package bptree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestOrderStatistics(t *testing.T) {
+	for _, order := range []int{3, 4, 9} {
+		tree := NewBPTree[int, int](order)
+		rnd := rand.New(rand.NewSource(int64(order)))
+		present := make(map[int]bool)
+		for i := 0; i < 4000; i++ {
+			k := rnd.Intn(800)
+			if rnd.Intn(3) == 0 {
+				tree.Delete(k)
+				delete(present, k)
+			} else {
+				tree.Insert(k, -k)
+				present[k] = true
+			}
+		}
+		if err := tree.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+		keys := make([]int, 0, len(present))
+		for k := range present {
+			keys = append(keys, k)
+		}
+		sort.Ints(keys)
+
+		for k := -1; k <= 801; k++ {
+			if got, want := tree.Rank(k), sort.SearchInts(keys, k); got != want {
+				t.Fatalf("order %d: rank of %d is %d, want %d", order, k, got, want)
+			}
+		}
+		for i, k := range keys {
+			if got, v, ok := tree.Select(i); !ok || got != k || v != -k {
+				t.Fatalf("order %d: select %d is %d %v, want %d", order, i, got, ok, k)
+			}
+		}
+		if _, _, ok := tree.Select(len(keys)); ok {
+			t.Errorf("order %d: select past the last key", order)
+		}
+		if _, _, ok := tree.Select(-1); ok {
+			t.Errorf("order %d: select -1", order)
+		}
+		for i := 0; i < 200; i++ {
+			start, end := rnd.Intn(820)-10, rnd.Intn(820)-10
+			if got, want := tree.CountRange(start, end), len(tree.Range(start, end)); got != want {
+				t.Fatalf("order %d: count of [%d, %d] is %d, want %d", order, start, end, got, want)
+			}
+		}
+	}
+}
+
+func TestOrderStatisticsBulkLoad(t *testing.T) {
+	keys := make([]int, 500)
+	for i := range keys {
+		keys[i] = i * 2
+	}
+	tree, err := BulkLoad[int, string](5, 0.8, &sliceLoader{keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, _, _ := tree.Select(123); k != 246 {
+		t.Errorf("select 123 is %d", k)
+	}
+	if r := tree.Rank(247); r != 124 {
+		t.Errorf("rank of 247 is %d", r)
+	}
+	if n := tree.CountRange(10, 20); n != 6 {
+		t.Errorf("count of [10, 20] is %d", n)
+	}
+}
@@ -0,0 +1,262 @@
+package bptree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// A snapshot is the magic and the version, then the order and the number of
+// items as uvarints, the items in ascending key order as uvarint-prefixed key
+// and value encodings, and the crc32c of all of the above.
+const (
+	snapshotMagic   = 0x42505453 // "BPTS"
+	snapshotVersion = 1
+	// snapshotFile names snapshots in the corruption errors of ReadFrom.
+	snapshotFile = "snapshot"
+)
+
+var ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+var (
+	_ io.WriterTo   = (*BPTree[int, any])(nil)
+	_ io.ReaderFrom = (*BPTree[int, any])(nil)
+)
+
+// SetCodecs replaces the default codecs turning the keys and values into the
+// bytes of a snapshot. A snapshot must be read with the codecs it was written
+// with.
+func (b *BPTree[K, V]) SetCodecs(keys utils.KeyCodec[K], values utils.ValueCodec[V]) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.keyCodec, b.valueCodec = keys, values
+}
+
+// WriteTo writes a snapshot of the tree to w. The items are collected holding
+// the tree exclusively, so the snapshot sees the tree at a single point;
+// writes are not held up while they are encoded.
+func (b *BPTree[K, V]) WriteTo(w io.Writer) (int64, error) {
+	b.mutex.Lock()
+	items := make([]BPTreeItem[K, V], 0, b.Len())
+	for leaf := b.leftmost(); leaf != nil; leaf = leaf.Next {
+		items = append(items, leaf.Items...)
+	}
+	order, keys, values := b.order, b.keyCodec, b.valueCodec
+	b.mutex.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	crc := crc32.New(crcTable)
+	out := io.MultiWriter(bw, crc)
+
+	buf := binary.BigEndian.AppendUint32(nil, snapshotMagic)
+	buf = append(buf, snapshotVersion)
+	buf = binary.AppendUvarint(buf, uint64(order))
+	buf = binary.AppendUvarint(buf, uint64(len(items)))
+	if _, err := out.Write(buf); err != nil {
+		return cw.n, fmt.Errorf("write snapshot: %w", err)
+	}
+	for _, item := range items {
+		key, err := keys.EncodeKey(item.Key)
+		if err != nil {
+			return cw.n, err
+		}
+		value, err := values.EncodeValue(item.Value)
+		if err != nil {
+			return cw.n, err
+		}
+		buf = binary.AppendUvarint(buf[:0], uint64(len(key)))
+		buf = append(buf, key...)
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		buf = append(buf, value...)
+		if _, err := out.Write(buf); err != nil {
+			return cw.n, fmt.Errorf("write snapshot: %w", err)
+		}
+	}
+	if _, err := bw.Write(crc.Sum(nil)); err != nil {
+		return cw.n, fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, fmt.Errorf("write snapshot: %w", err)
+	}
+	return cw.n, nil
+}
+
+// ReadFrom replaces the content and the order of the tree with a snapshot
+// read from r. The tree is left as it was unless the whole snapshot decodes
+// and its checksum matches; damaged snapshots are reported with an error
+// matching utils.ErrCorruption. When r is an io.ByteReader, ReadFrom stops at
+// the end of the snapshot, otherwise it may read past it.
+func (b *BPTree[K, V]) ReadFrom(r io.Reader) (int64, error) {
+	b.mutex.RLock()
+	keys, values := b.keyCodec, b.valueCodec
+	b.mutex.RUnlock()
+
+	sr := &snapshotReader{}
+	if br, ok := r.(byteReader); ok {
+		sr.r = br
+	} else {
+		sr.r = bufio.NewReader(r)
+	}
+
+	var header [5]byte
+	if err := sr.read(header[:]); err != nil {
+		return sr.n, err
+	}
+	if binary.BigEndian.Uint32(header[:]) != snapshotMagic {
+		return sr.n, sr.corruption(errors.New("bad snapshot magic"))
+	}
+	if header[4] != snapshotVersion {
+		return sr.n, fmt.Errorf("%w: %d", ErrSnapshotVersion, header[4])
+	}
+	order, err := sr.uvarint()
+	if err != nil {
+		return sr.n, err
+	}
+	count, err := sr.uvarint()
+	if err != nil {
+		return sr.n, err
+	}
+
+	// count is not trusted before the checksum is
+	items := make([]BPTreeItem[K, V], 0, min(count, 1<<16))
+	for i := uint64(0); i < count; i++ {
+		buf, err := sr.bytes()
+		if err != nil {
+			return sr.n, err
+		}
+		key, err := keys.DecodeKey(buf)
+		if err != nil {
+			return sr.n, err
+		}
+		if buf, err = sr.bytes(); err != nil {
+			return sr.n, err
+		}
+		value, err := values.DecodeValue(buf)
+		if err != nil {
+			return sr.n, err
+		}
+		if n := len(items); n > 0 && items[n-1].Key >= key {
+			return sr.n, sr.corruption(fmt.Errorf("%w: %v after %v", ErrUnsorted, key, items[n-1].Key))
+		}
+		items = append(items, BPTreeItem[K, V]{Key: key, Value: value})
+	}
+
+	sum := sr.crc
+	var trailer [4]byte
+	if err := sr.read(trailer[:]); err != nil {
+		return sr.n, err
+	}
+	if binary.BigEndian.Uint32(trailer[:]) != sum {
+		return sr.n, sr.corruption(errors.New("snapshot checksum mismatch"))
+	}
+	if order > 1<<20 {
+		return sr.n, sr.corruption(fmt.Errorf("order %d", order))
+	}
+
+	t := NewBPTree[K, V](int(order))
+	t.build(items, t.order)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.root, b.order = t.root, t.order
+	b.size.Store(t.size.Load())
+	return sr.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// snapshotReader tracks the offset and the checksum of the bytes consumed
+// from a snapshot.
+type snapshotReader struct {
+	r   byteReader
+	n   int64
+	crc uint32
+	// err is the last error of r, telling read failures from bad varints.
+	err error
+}
+
+func (s *snapshotReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	s.crc = crc32.Update(s.crc, crcTable, p[:n])
+	return n, err
+}
+
+func (s *snapshotReader) ReadByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		s.err = err
+		return c, err
+	}
+	s.n++
+	s.crc = crc32.Update(s.crc, crcTable, []byte{c})
+	return c, nil
+}
+
+func (s *snapshotReader) corruption(err error) error {
+	return utils.NewCorruptionError(snapshotFile, uint64(s.n), err)
+}
+
+// fail reports a short snapshot as corruption.
+func (s *snapshotReader) fail(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return s.corruption(io.ErrUnexpectedEOF)
+	}
+	return fmt.Errorf("read snapshot: %w", err)
+}
+
+func (s *snapshotReader) read(buf []byte) error {
+	if _, err := io.ReadFull(s, buf); err != nil {
+		return s.fail(err)
+	}
+	return nil
+}
+
+func (s *snapshotReader) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(s)
+	if err != nil {
+		if s.err != nil {
+			return 0, s.fail(err)
+		}
+		return 0, s.corruption(err)
+	}
+	return v, nil
+}
+
+// bytes reads a uvarint-prefixed string of bytes. A damaged length cannot
+// allocate more than the bytes actually present.
+func (s *snapshotReader) bytes() ([]byte, error) {
+	n, err := s.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n <= 4096 {
+		buf := make([]byte, n)
+		return buf, s.read(buf)
+	}
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, s, int64(min(n, 1<<62))); err != nil {
+		return nil, s.fail(err)
+	}
+	return buf.Bytes(), nil
+}
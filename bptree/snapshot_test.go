@@ -0,0 +1,169 @@
+package bptree
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 1000} {
+		tree := NewBPTree[int, string](5)
+		for i := 0; i < n; i++ {
+			tree.Insert(i*7-300, fmt.Sprintf("value_%d", i))
+		}
+		for i := 0; i < n; i += 3 {
+			tree.Delete(i*7 - 300)
+		}
+
+		var buf bytes.Buffer
+		written, err := tree.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if written != int64(buf.Len()) {
+			t.Errorf("WriteTo returned %d for %d bytes", written, buf.Len())
+		}
+
+		loaded := NewBPTree[int, string](3)
+		loaded.Insert(1, "replaced")
+		read, err := loaded.ReadFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read != written {
+			t.Errorf("ReadFrom returned %d, want %d", read, written)
+		}
+		if err := loaded.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+		if loaded.order != 5 || loaded.Len() != tree.Len() {
+			t.Fatalf("n %d: order %d Len %d, want 5 and %d", n, loaded.order, loaded.Len(), tree.Len())
+		}
+		want := tree.Range(-1000, 10000)
+		if got := loaded.Range(-1000, 10000); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("n %d: loaded %v, want %v", n, got, want)
+		}
+
+		loaded.Insert(-1000, "new")
+		loaded.Delete(4)
+		if err := loaded.CheckInvariants(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	tree := NewBPTree[string, []byte](4)
+	for i := 0; i < 100; i++ {
+		tree.Insert(fmt.Sprintf("key%03d", i), []byte(fmt.Sprint(i)))
+	}
+	var buf bytes.Buffer
+	if _, err := tree.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := buf.Bytes()
+
+	loaded := NewBPTree[string, []byte](4)
+	loaded.Insert("kept", nil)
+	for _, off := range []int{0, 6, len(snapshot) / 2, len(snapshot) - 1} {
+		damaged := bytes.Clone(snapshot)
+		damaged[off] ^= 0x40
+		if _, err := loaded.ReadFrom(bytes.NewReader(damaged)); !errors.Is(err, utils.ErrCorruption) {
+			t.Errorf("byte %d flipped: %v", off, err)
+		}
+	}
+	for _, size := range []int{0, 3, len(snapshot) / 2, len(snapshot) - 1} {
+		if _, err := loaded.ReadFrom(bytes.NewReader(snapshot[:size])); !errors.Is(err, utils.ErrCorruption) {
+			t.Errorf("snapshot cut at %d: %v", size, err)
+		}
+	}
+	if loaded.Len() != 1 || len(loaded.Get("kept")) != 1 {
+		t.Error("failed loads changed the tree")
+	}
+
+	future := bytes.Clone(snapshot)
+	future[4] = snapshotVersion + 1
+	if _, err := loaded.ReadFrom(bytes.NewReader(future)); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("future version: %v", err)
+	}
+}
+
+func TestSnapshotStopsAtEnd(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 1; i <= 2; i++ {
+		tree := NewBPTree[int, int](4)
+		for k := 0; k < 10*i; k++ {
+			tree.Insert(k, k*i)
+		}
+		if _, err := tree.WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := bytes.NewReader(buf.Bytes())
+	for i := 1; i <= 2; i++ {
+		tree := NewBPTree[int, int](4)
+		if _, err := tree.ReadFrom(r); err != nil {
+			t.Fatal(err)
+		}
+		if v := tree.Get(9); tree.Len() != 10*i || len(v) != 1 || v[0] != 9*i {
+			t.Errorf("snapshot %d: Len %d get %v", i, tree.Len(), v)
+		}
+	}
+}
+
+type point struct{ X, Y int }
+
+type jsonCodec struct{}
+
+func (jsonCodec) EncodeValue(p point) ([]byte, error) { return json.Marshal(p) }
+
+func (jsonCodec) DecodeValue(b []byte) (point, error) {
+	var p point
+	return p, json.Unmarshal(b, &p)
+}
+
+func TestSnapshotCodecs(t *testing.T) {
+	tree := NewBPTree[string, point](4)
+	tree.Insert("a", point{1, 2})
+	var buf bytes.Buffer
+	if _, err := tree.WriteTo(&buf); !errors.Is(err, utils.ErrUnsupportedType) {
+		t.Fatalf("default codec of a struct: %v", err)
+	}
+
+	tree.SetCodecs(utils.StringCodec{}, jsonCodec{})
+	buf.Reset()
+	if _, err := tree.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewBPTree[string, point](4)
+	loaded.SetCodecs(utils.StringCodec{}, jsonCodec{})
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if v := loaded.Get("a"); len(v) != 1 || v[0] != (point{1, 2}) {
+		t.Errorf("loaded %v", v)
+	}
+}
+
+type failingIO struct{ err error }
+
+func (f failingIO) Write([]byte) (int, error) { return 0, f.err }
+
+func (f failingIO) Read([]byte) (int, error) { return 0, f.err }
+
+func TestSnapshotIOErrors(t *testing.T) {
+	failed := errors.New("disk failed")
+	tree := NewBPTree[int, int](4)
+	tree.Insert(1, 1)
+	if _, err := tree.WriteTo(failingIO{failed}); !errors.Is(err, failed) {
+		t.Errorf("write error not wrapped: %v", err)
+	}
+	if _, err := tree.ReadFrom(failingIO{failed}); !errors.Is(err, failed) {
+		t.Errorf("read error not wrapped: %v", err)
+	}
+}
@@ -1,5 +1,14 @@
 package config
 
+import (
+	"errors"
+	"fmt"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
 type Config struct {
 	Dir                 string
 	MaxLevel            int
@@ -8,16 +17,82 @@ type Config struct {
 	SstFooterSize       int
 	SstBlockTrailerSize int
 	SstRestartInterval  int
+	// PartitionIndex splits the index block into partitions of about
+	// IndexPartitionSize bytes loaded on demand through the block cache;
+	// PartitionFilter does the same for the filter block. Filter partitions
+	// follow the index partitions, so PartitionFilter requires PartitionIndex.
+	PartitionIndex     bool
+	PartitionFilter    bool
+	IndexPartitionSize int
+	BlockCacheSize     int64
+	// Values of at least BlobThreshold bytes are written to blob files and
+	// referenced from the SST; zero keeps every value inline. Blob GC rewrites
+	// a blob file once BlobGCRatio of its bytes are garbage.
+	BlobThreshold int
+	BlobGCRatio   float64
+	// MmapReads maps SST files into memory (linux only) so block reads need
+	// no lock.
+	MmapReads bool
+	// CompactionWorkers is the number of key range subcompactions a
+	// compaction job is split into and run concurrently.
+	CompactionWorkers int
+	// RateLimitBytesPerSec caps the SST writes of flushes and compactions,
+	// flushes first; zero disables it. RateLimitAutoTune raises the limit
+	// with the compaction debt, up to eight times the configured rate.
+	RateLimitBytesPerSec int64
+	RateLimitAutoTune    bool
+	// Comparator orders the keys of the SSTs; memtables must order their keys
+	// the same way. It is recorded in the MANIFEST and cannot change once the
+	// database exists.
+	Comparator utils.BytesComparator
+	// MemTableRep selects the structure of the memtables: MemTableRBTree, the
+	// default, or MemTableSkipList, whose reads take no lock.
+	MemTableRep string
+	// WriteBufferSize is the memory usage, in bytes, at which a memtable is
+	// frozen and handed to the flusher.
+	WriteBufferSize int
+	// MaxImmutableMemTables is the number of frozen memtables waiting for the
+	// background flusher at which writes stall; zero never stalls.
+	MaxImmutableMemTables int
 }
 
+const (
+	MemTableRBTree   = "rbtree"
+	MemTableSkipList = "skiplist"
+)
+
 func NewConfig(dir string) *Config {
 	return &Config{
-		Dir:                 dir,
-		MaxLevel:            10,
-		SstSize:             16 * 1024 * 1024,
-		SstDataBlockSize:    16 * 1024 * 1024,
-		SstFooterSize:       40,
-		SstBlockTrailerSize: 4,
-		SstRestartInterval:  16,
+		Dir:                   dir,
+		MaxLevel:              10,
+		SstSize:               16 * 1024 * 1024,
+		SstDataBlockSize:      16 * 1024 * 1024,
+		SstFooterSize:         40,
+		SstBlockTrailerSize:   4,
+		SstRestartInterval:    16,
+		IndexPartitionSize:    4 * 1024,
+		BlockCacheSize:        8 * 1024 * 1024,
+		BlobGCRatio:           0.5,
+		CompactionWorkers:     1,
+		WriteBufferSize:       4 * 1024 * 1024,
+		MaxImmutableMemTables: 2,
+		Comparator:            utils.BytewiseComparator,
+	}
+}
+
+// Validate reports options that cannot be used together, matching
+// ErrInvalidConfig.
+func (c *Config) Validate() error {
+	if c.PartitionFilter && !c.PartitionIndex {
+		return fmt.Errorf("%w: PartitionFilter requires PartitionIndex", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// KeyComparator returns the Comparator, utils.BytewiseComparator when unset.
+func (c *Config) KeyComparator() utils.BytesComparator {
+	if c.Comparator == nil {
+		return utils.BytewiseComparator
 	}
+	return c.Comparator
 }
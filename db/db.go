@@ -24,7 +24,7 @@ func NewTableInt(filename string, timeout time.Duration) *memtable.MemTable[int,
 	r := wal.NewReader(buf)
 	im := memtable.NewIMemTable[int, int]()
 	conf := config.NewConfig("./")
-	m := memtable.NewMemTable[int, int](compare, 1024, r, w, timeout, im, filename, conf)
+	m := memtable.NewMemTable[int, int](compare, conf.WriteBufferSize, r, w, timeout, im, filename, conf)
 	return m
 }
 
@@ -35,6 +35,46 @@ func NewTableString(filename string, timeout time.Duration) *memtable.MemTable[s
 	r := wal.NewReader(buf)
 	im := memtable.NewIMemTable[string, string]()
 	conf := config.NewConfig("./")
-	m := memtable.NewMemTable[string, string](compare, 1024, r, w, timeout, im, filename, conf)
+	m := memtable.NewMemTable[string, string](compare, conf.WriteBufferSize, r, w, timeout, im, filename, conf)
 	return m
 }
+
+// MultiGet returns the values of keys, nil for the missing ones. The keys are
+// looked up in the memtables of m, when set, and the others in t at once.
+func MultiGet[K any, V any](m *memtable.MemTable[K, V], t *sstable.LSMTree[K, V], keys []K) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	var rest []K
+	var restIdx []int
+	_, codec := t.Codecs()
+	if m != nil {
+		for i, r := range m.MultiGet(keys) {
+			if r.Found {
+				value, err := codec.EncodeValue(r.Value)
+				if err != nil {
+					return nil, err
+				}
+				values[i] = value
+			} else if !r.Deleted {
+				rest = append(rest, keys[i])
+				restIdx = append(restIdx, i)
+			}
+		}
+	} else {
+		rest = keys
+		for i := range keys {
+			restIdx = append(restIdx, i)
+		}
+	}
+	if len(rest) == 0 {
+		return values, nil
+	}
+
+	found, err := t.MultiGet(rest)
+	if err != nil {
+		return nil, err
+	}
+	for j, v := range found {
+		values[restIdx[j]] = v
+	}
+	return values, nil
+}
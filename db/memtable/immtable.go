@@ -1,19 +1,27 @@
 package memtable
 
 import (
-	"errors"
+	"fmt"
 	"sync"
+
+	"github.com/peterouob/gocloud/db/utils"
 )
 
+// IMemTable is the FIFO of the frozen memtables waiting to be flushed, oldest
+// first. Reads go from the newest table to the oldest.
 type IMemTable[K any, V any] struct {
 	readOnlyTable []*MemTable[K, V]
 	mu            sync.Mutex
+	// popped is signalled when a table leaves the queue or the flusher fails.
+	popped *sync.Cond
 }
 
 func NewIMemTable[K any, V any]() *IMemTable[K, V] {
-	return &IMemTable[K, V]{
+	i := &IMemTable[K, V]{
 		readOnlyTable: make([]*MemTable[K, V], 0),
 	}
+	i.popped = sync.NewCond(&i.mu)
+	return i
 }
 
 func (i *IMemTable[K, V]) Len() int {
@@ -22,26 +30,73 @@ func (i *IMemTable[K, V]) Len() int {
 	return len(i.readOnlyTable)
 }
 
-func (i *IMemTable[K, V]) GetTable(key K) *MemTable[K, V] {
+// Oldest returns the table frozen first, nil when the queue is empty.
+func (i *IMemTable[K, V]) Oldest() *MemTable[K, V] {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.readOnlyTable) == 0 {
+		return nil
+	}
+	return i.readOnlyTable[0]
+}
+
+// PopOldest drops the table frozen first, once its SST is installed, and
+// returns it.
+func (i *IMemTable[K, V]) PopOldest() *MemTable[K, V] {
 	i.mu.Lock()
 	defer i.mu.Unlock()
+	if len(i.readOnlyTable) == 0 {
+		return nil
+	}
 	table := i.readOnlyTable[0]
-	i.readOnlyTable = i.readOnlyTable[:]
+	i.readOnlyTable[0] = nil
+	i.readOnlyTable = i.readOnlyTable[1:]
+	i.popped.Broadcast()
 	return table
 }
 
-func (i *IMemTable[K, V]) Get(key K) (V, error) {
+// waitForRoom blocks while the queue holds max tables or more, until failed
+// returns an error; a max of zero never blocks. It reports whether the caller
+// had to wait.
+func (i *IMemTable[K, V]) waitForRoom(max int, failed func() error) bool {
 	i.mu.Lock()
 	defer i.mu.Unlock()
+	stalled := false
+	for max > 0 && len(i.readOnlyTable) >= max && failed() == nil {
+		stalled = true
+		i.popped.Wait()
+	}
+	return stalled
+}
 
-	var vnil V
+// wake wakes the writers waiting for room up.
+func (i *IMemTable[K, V]) wake() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.popped.Broadcast()
+}
 
-	for _, table := range i.readOnlyTable {
-		node := table.MemTree.FindKey(key)
-		if node != nil {
-			return node.Value, nil
-		}
+// tables returns the tables from the newest to the oldest.
+func (i *IMemTable[K, V]) tables() []*MemTable[K, V] {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	tables := make([]*MemTable[K, V], 0, len(i.readOnlyTable))
+	for n := len(i.readOnlyTable) - 1; n >= 0; n-- {
+		tables = append(tables, i.readOnlyTable[n])
 	}
+	return tables
+}
 
-	return vnil, errors.New("key not found in immutable table")
+// Get returns the value of key in the newest table knowing it.
+func (i *IMemTable[K, V]) Get(key K) (V, error) {
+	var vnil V
+	for _, table := range i.tables() {
+		if r, ok := table.lookup(key); ok {
+			if r.Deleted {
+				break
+			}
+			return r.Value, nil
+		}
+	}
+	return vnil, fmt.Errorf("%w in immutable table", utils.ErrNotFound)
 }
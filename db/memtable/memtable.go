@@ -11,46 +11,76 @@ import (
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/peterouob/gocloud/db/utils"
 	"github.com/peterouob/gocloud/db/wal"
 )
 
-type memState uint8
-
-const (
-	writeAble memState = iota
-	readOnly
-)
-
 type MemTableInterface[K any, V any] interface {
 	Put(k K, v V) error
+	DeleteRange(start, end K) error
 	Get(k K) (V, error)
 	DeepCopy() *MemTable[K, V]
 	Reset()
 }
 
 type MemTable[K any, V any] struct {
-	MemTree     *Tree[K, V]
-	WalReader   *wal.Reader
-	WalWriter   *wal.Writer
-	mu          sync.Mutex
-	curSize     int
-	maxSize     int
-	state       memState
-	stateChan   *sync.Cond
+	// rep and rangeDels are replaced under both mu and repMu, so writers
+	// holding mu read them directly and readers through Rep and lookup.
+	rep        MemTableRep[K, V]
+	repMu      sync.RWMutex
+	comparator utils.Comparator[K]
+	WalReader  *wal.Reader
+	WalWriter  *wal.Writer
+	mu         sync.Mutex
+	// maxSize is the memory usage, in bytes, at which the table is frozen.
+	maxSize int
+	// flushPeriod is the age of the WAL of the table at which it is frozen.
 	flushPeriod time.Duration
 	ticker      *time.Ticker
 	IMemTable   *IMemTable[K, V]
 	f           *os.File
 	conf        *config.Config
+	rangeDels   []RangeDeletion[K]
+	keyCodec    utils.KeyCodec[K]
+	valueCodec  utils.ValueCodec[V]
+
+	// flushC wakes the background flusher, when started, up.
+	flushC    chan struct{}
+	stalls    atomic.Int64
+	flushWg   sync.WaitGroup
+	errMu     sync.Mutex
+	flushErr  error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Flusher writes a frozen table out, as sstable.LSMTree does.
+type Flusher[K any, V any] interface {
+	FlushRecord(*MemTable[K, V], string) error
+}
+
+// RangeDeletion deletes the keys in [Start, End) written before it.
+type RangeDeletion[K any] struct {
+	Start K
+	End   K
+}
+
+// walRangeDeletion is the WAL record of a DeleteRange.
+type walRangeDeletion struct {
+	RangeStart []byte `json:"range_start"`
+	RangeEnd   []byte `json:"range_end"`
 }
 
 var _ MemTableInterface[any, any] = (*MemTable[any, any])(nil)
 
+// NewMemTable returns a table frozen once it holds maxSize bytes, or
+// conf.WriteBufferSize when maxSize is not positive, or once its WAL is t old.
 func NewMemTable[K any, V any](c utils.Comparator[K], maxSize int, r *wal.Reader,
 	w *wal.Writer, t time.Duration, iMemTable *IMemTable[K, V], fileName string, conf *config.Config) *MemTable[K, V] {
 	base := strings.TrimSuffix(fileName, path.Ext(fileName))
@@ -60,52 +90,149 @@ func NewMemTable[K any, V any](c utils.Comparator[K], maxSize int, r *wal.Reader
 	if err != nil {
 		return nil
 	}
+	if maxSize <= 0 {
+		maxSize = conf.WriteBufferSize
+	}
 	m := &MemTable[K, V]{
-		MemTree:     NewTree[K, V](c),
+		rep:         NewMemTableRep[K, V](conf, c),
+		comparator:  c,
 		WalReader:   r,
 		WalWriter:   w,
 		maxSize:     maxSize,
-		curSize:     0,
 		flushPeriod: t,
 		ticker:      time.NewTicker(t),
-		stateChan:   sync.NewCond(&sync.Mutex{}),
 		IMemTable:   iMemTable,
 		f:           txtFile,
 		conf:        conf,
+		keyCodec:    utils.DefaultKeyCodec[K](),
+		valueCodec:  utils.DefaultValueCodec[V](),
+		done:        make(chan struct{}),
 	}
 	go m.listenState()
 	return m
 }
 
+// SetCodecs replaces the default codecs turning the keys and values into the
+// bytes of the WAL. The key codec must order keys as the comparator of the
+// table.
+func (m *MemTable[K, V]) SetCodecs(keys utils.KeyCodec[K], values utils.ValueCodec[V]) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keyCodec, m.valueCodec = keys, values
+}
+
+// Rep returns the structure holding the entries of the table.
+func (m *MemTable[K, V]) Rep() MemTableRep[K, V] {
+	m.repMu.RLock()
+	defer m.repMu.RUnlock()
+	return m.rep
+}
+
+// ApproximateMemoryUsage returns the bytes held by the entries of the table.
+func (m *MemTable[K, V]) ApproximateMemoryUsage() int64 {
+	return m.Rep().MemoryUsage()
+}
+
+// StartFlusher starts a goroutine writing the immutable tables, oldest first,
+// with f, named after extra, and popping each one once written. A failed flush
+// keeps the table readable and fails the following writes.
+func (m *MemTable[K, V]) StartFlusher(f Flusher[K, V], extra string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.flushC != nil {
+		return
+	}
+	m.flushC = make(chan struct{}, 1)
+	m.flushWg.Add(1)
+	go func(flushC chan struct{}) {
+		defer m.flushWg.Done()
+		for range flushC {
+			for table := m.IMemTable.Oldest(); table != nil && m.backgroundError() == nil; table = m.IMemTable.Oldest() {
+				if err := f.FlushRecord(table, extra); err != nil {
+					log.Println("error in flush memtable :", err)
+					m.errMu.Lock()
+					m.flushErr = err
+					m.errMu.Unlock()
+					m.IMemTable.wake()
+					break
+				}
+				m.IMemTable.PopOldest()
+			}
+		}
+	}(m.flushC)
+	m.flushC <- struct{}{}
+}
+
+// WriteStalls returns how many times a write waited for the flusher because
+// conf.MaxImmutableMemTables tables were waiting to be flushed.
+func (m *MemTable[K, V]) WriteStalls() int64 {
+	return m.stalls.Load()
+}
+
+// listenState freezes the table once its WAL is flushPeriod old.
 func (m *MemTable[K, V]) listenState() {
 	defer m.ticker.Stop()
 	for {
 		select {
 		case <-m.ticker.C:
-			if m.state != readOnly {
-
-				m.state = readOnly
-				m.stateChan.Broadcast()
-				m.Reset()
-				m.ticker.Reset(m.flushPeriod)
-			} else {
-				m.mu.Unlock()
+			m.mu.Lock()
+			if m.rep.Len() > 0 || len(m.rangeDels) > 0 {
+				log.Println("WAL age exceeded, switching to immutable table")
+				m.freezeLocked()
 			}
+			m.mu.Unlock()
+		case <-m.done:
+			return
 		}
 	}
 }
 
+// Close stops the WAL age timer and, with a flusher started, freezes the
+// entries left and waits for every frozen table to be written. It returns the
+// first error of the flusher.
+func (m *MemTable[K, V]) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.mu.Lock()
+		if m.flushC != nil {
+			if m.rep.Len() > 0 || len(m.rangeDels) > 0 {
+				m.freezeLocked()
+			}
+			close(m.flushC)
+			m.flushC = nil
+		}
+		m.mu.Unlock()
+		m.flushWg.Wait()
+		if m.f != nil {
+			m.f.Close()
+		}
+	})
+	return m.backgroundError()
+}
+
+// backgroundError returns the first error of the flusher.
+func (m *MemTable[K, V]) backgroundError() error {
+	m.errMu.Lock()
+	defer m.errMu.Unlock()
+	return m.flushErr
+}
+
 func (m *MemTable[K, V]) Put(k K, v V) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.state == readOnly {
-		log.Println("This is read only table")
-		m.Reset()
-		return errors.New("memtable is read-only, flushed")
+	if err := m.backgroundError(); err != nil {
+		return errors.New("error in background flush : " + err.Error())
 	}
 
-	key, value := utils.FormatKeyValue(k, v)
+	key, err := m.keyCodec.EncodeKey(k)
+	if err != nil {
+		return err
+	}
+	value, err := m.valueCodec.EncodeValue(v)
+	if err != nil {
+		return err
+	}
 	data := kv.NewKV(key, value)
 	dataBytes, err := json.Marshal(data)
 	if err != nil {
@@ -113,28 +240,69 @@ func (m *MemTable[K, V]) Put(k K, v V) error {
 	}
 
 	w := m.WalWriter.Next()
-	size, err := w.Write(dataBytes)
-	if err != nil {
+	if _, err := w.Write(dataBytes); err != nil {
 		return fmt.Errorf("error in write data: %v", err)
 	}
+	m.WalWriter.Flush()
 
-	m.curSize += size
-	if m.curSize > m.maxSize {
-		log.Println("Max size exceeded, switching to read-only state")
-		m.state = readOnly
-		m.Reset()
-	} else {
-		m.WalWriter.Flush()
+	m.rep.Insert(k, v)
+	if err := m.write(key, value); err != nil {
+		return fmt.Errorf("error in write data: %v", err)
 	}
 
-	m.MemTree.Insert(k, v)
-	if err := m.write(key, value); err != nil {
+	if m.rep.MemoryUsage() >= int64(m.maxSize) {
+		log.Println("Max size exceeded, switching to immutable table")
+		m.freezeLocked()
+	}
+	return nil
+}
+
+// DeleteRange deletes the keys in [start, end): the keys held by the memtable
+// are marked deleted and the range is kept as a tombstone, flushed with the
+// table, for the keys already stored in SSTs.
+func (m *MemTable[K, V]) DeleteRange(start, end K) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.backgroundError(); err != nil {
+		return errors.New("error in background flush : " + err.Error())
+	}
+	if m.comparator.Compare(start, end) >= 0 {
+		return errors.New("delete range start must be less than end")
+	}
+
+	bstart, err := m.keyCodec.EncodeKey(start)
+	if err != nil {
+		return err
+	}
+	bend, err := m.keyCodec.EncodeKey(end)
+	if err != nil {
+		return err
+	}
+	dataBytes, err := json.Marshal(&walRangeDeletion{RangeStart: bstart, RangeEnd: bend})
+	if err != nil {
+		return errors.New("error in marshal data")
+	}
+	w := m.WalWriter.Next()
+	if _, err := w.Write(dataBytes); err != nil {
 		return fmt.Errorf("error in write data: %v", err)
 	}
+	m.WalWriter.Flush()
 
+	m.rep.DeleteRange(start, end)
+	m.repMu.Lock()
+	m.rangeDels = append(m.rangeDels, RangeDeletion[K]{Start: start, End: end})
+	m.repMu.Unlock()
 	return nil
 }
 
+// RangeDeletions returns the ranges deleted in this memtable, oldest first.
+func (m *MemTable[K, V]) RangeDeletions() []RangeDeletion[K] {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]RangeDeletion[K](nil), m.rangeDels...)
+}
+
 func (m *MemTable[K, V]) write(key []byte, value []byte) error {
 	buf := new(bytes.Buffer)
 
@@ -157,69 +325,146 @@ func (m *MemTable[K, V]) write(key []byte, value []byte) error {
 	return nil
 }
 
+// Get returns the value of key in the table, then in the immutable tables. It
+// takes no lock of the table, so reads do not wait for writes.
 func (m *MemTable[K, V]) Get(key K) (V, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	var v V
-
-	if m.MemTree == nil || m.MemTree.Size == 0 {
-		if m.IMemTable.Len() != 0 {
-			v, err := m.IMemTable.Get(key)
-			if err != nil {
-				return v, errors.New("error in get data from memtable and immtable")
-			} else {
-				return v, nil
-			}
+	if r, ok := m.lookup(key); ok {
+		if r.Deleted {
+			return v, fmt.Errorf("%w in memtable", utils.ErrNotFound)
 		}
-		return v, errors.New("memtable is empty")
+		return r.Value, nil
 	}
+	if m.IMemTable != nil && m.IMemTable.Len() != 0 {
+		v, err := m.IMemTable.Get(key)
+		if err != nil {
+			return v, fmt.Errorf("error in get data from memtable and immtable: %w", err)
+		}
+		return v, nil
+	}
+	return v, fmt.Errorf("%w in memtable", utils.ErrNotFound)
+}
 
-	keydata, err := json.Marshal(key)
-	if err != nil {
-		return v, errors.New("error in marshal key")
+// LookupResult is the state of a key in the memtables. A Deleted key hides
+// the versions stored in SSTs.
+type LookupResult[V any] struct {
+	Value   V
+	Found   bool
+	Deleted bool
+}
+
+// MultiGet looks keys up in the memtable, then in the immutable tables from
+// the newest. Keys are probed in sorted order, each distinct key once. Like
+// Get, the lookups run without m.mu, on the tables taken when MultiGet starts:
+// the rep of m is taken with them, so a freeze moving it to the immutable
+// tables meanwhile cannot hide it.
+func (m *MemTable[K, V]) MultiGet(keys []K) []LookupResult[V] {
+	m.mu.Lock()
+	tables := []*MemTable[K, V]{m.clone(m.rep)}
+	if m.IMemTable != nil {
+		tables = append(tables, m.IMemTable.tables()...)
 	}
-	reader, err := m.WalReader.Next()
-	if err != nil {
-		return v, errors.New("error in call wal reader next :" + err.Error())
+	m.mu.Unlock()
 
+	cmp := m.comparator
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return cmp.Compare(keys[order[i]], keys[order[j]]) < 0 })
+
+	results := make([]LookupResult[V], len(keys))
+	for n, i := range order {
+		if n > 0 && cmp.Compare(keys[order[n-1]], keys[i]) == 0 {
+			results[i] = results[order[n-1]]
+			continue
+		}
+		for _, table := range tables {
+			if r, ok := table.lookup(keys[i]); ok {
+				results[i] = r
+				break
+			}
+		}
 	}
-	if _, err := reader.Read(keydata); err != nil {
-		return v, errors.New("error in wal reader key data :" + err.Error())
+	return results
+}
 
+// lookup reports the state of key in this table alone, if the table knows it.
+// The rep and the range deletions are read under repMu, so lookup needs no
+// lock of the table.
+func (m *MemTable[K, V]) lookup(key K) (LookupResult[V], bool) {
+	m.repMu.RLock()
+	rep, rangeDels := m.rep, m.rangeDels
+	m.repMu.RUnlock()
+	if value, deleted, found := rep.Lookup(key); found {
+		if deleted {
+			return LookupResult[V]{Deleted: true}, true
+		}
+		return LookupResult[V]{Value: value, Found: true}, true
+	}
+	cmp := m.comparator
+	for _, d := range rangeDels {
+		if cmp.Compare(d.Start, key) <= 0 && cmp.Compare(key, d.End) < 0 {
+			return LookupResult[V]{Deleted: true}, true
+		}
 	}
-	node := m.MemTree.FindKey(key)
-	v = node.Value
-	return v, nil
+	return LookupResult[V]{}, false
 }
 
 func (m *MemTable[K, V]) DeepCopy() *MemTable[K, V] {
-	newMemTree := m.MemTree.DeepCopy()
+	return m.clone(m.Rep().Copy())
+}
+
+// clone returns a table with the state of m over rep.
+func (m *MemTable[K, V]) clone(rep MemTableRep[K, V]) *MemTable[K, V] {
 	return &MemTable[K, V]{
-		MemTree:     newMemTree,
+		rep:         rep,
+		comparator:  m.comparator,
 		WalReader:   m.WalReader,
 		WalWriter:   m.WalWriter,
 		maxSize:     m.maxSize,
 		flushPeriod: m.flushPeriod,
 		ticker:      m.ticker,
-		curSize:     m.curSize,
-		state:       m.state,
-		stateChan:   m.stateChan,
 		IMemTable:   m.IMemTable,
-		mu:          sync.Mutex{},
+		conf:        m.conf,
+		rangeDels:   append([]RangeDeletion[K](nil), m.rangeDels...),
+		keyCodec:    m.keyCodec,
+		valueCodec:  m.valueCodec,
 	}
 }
 
+// Reset hands the entries of the table over to the immutable tables and
+// starts a new rep.
 func (m *MemTable[K, V]) Reset() {
-	newCopy := m.DeepCopy()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.freezeLocked()
+}
 
-	m.IMemTable.mu.Lock()
-	if m.IMemTable != nil {
-		m.IMemTable.readOnlyTable = append(m.IMemTable.readOnlyTable, newCopy)
+// freezeLocked moves the rep to the immutable tables, waking the flusher up
+// when started, and starts a new rep and WAL age. The rep is not copied: nothing
+// writes to it once frozen. m.mu must be held.
+func (m *MemTable[K, V]) freezeLocked() {
+	frozen := m.clone(m.rep)
+
+	// with a flusher running, writes stall until the queue has room
+	if m.flushC != nil && m.IMemTable.waitForRoom(m.conf.MaxImmutableMemTables, m.backgroundError) {
+		log.Println("write stalled on the immutable tables")
+		m.stalls.Add(1)
 	}
-	m.MemTree = NewTree[K, V](m.MemTree.comparator)
-	m.curSize = 0
-	m.state = writeAble
+	m.IMemTable.mu.Lock()
+	m.IMemTable.readOnlyTable = append(m.IMemTable.readOnlyTable, frozen)
+	m.repMu.Lock()
+	m.rep = NewMemTableRep[K, V](m.conf, m.comparator)
+	m.rangeDels = nil
+	m.repMu.Unlock()
 	m.IMemTable.mu.Unlock()
+	m.ticker.Reset(m.flushPeriod)
 
+	if m.flushC != nil {
+		select {
+		case m.flushC <- struct{}{}:
+		default:
+		}
+	}
 }
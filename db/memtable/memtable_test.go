@@ -2,10 +2,13 @@ package memtable
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/peterouob/gocloud/db/config"
 	"github.com/peterouob/gocloud/db/utils"
 	"github.com/peterouob/gocloud/db/wal"
 	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -156,3 +159,189 @@ func TestMemTableRead(t *testing.T) {
 //	val, err = im.Get(9)
 //	assert.Error(t, err)
 //}
+
+func TestMemTableMultiGet(t *testing.T) {
+	buf := new(bytes.Buffer)
+	im := NewIMemTable[string, string]()
+	m := NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20, wal.NewReader(buf), wal.NewWriter(buf), 10*time.Minute, im, "multiget", config.NewConfig(t.TempDir()))
+	assert.NoError(t, m.Put("a", "old"))
+	assert.NoError(t, m.Put("b", "old"))
+	assert.NoError(t, m.Put("c", "old"))
+	m.Reset()
+	assert.NoError(t, m.Put("a", "new"))
+	assert.NoError(t, m.DeleteRange("b", "c"))
+
+	results := m.MultiGet([]string{"c", "a", "b", "z", "a"})
+	assert.Equal(t, []LookupResult[string]{
+		{Value: "old", Found: true},
+		{Value: "new", Found: true},
+		{Deleted: true},
+		{},
+		{Value: "new", Found: true},
+	}, results)
+}
+
+func TestMemTableMultiGetDuringReset(t *testing.T) {
+	buf := new(bytes.Buffer)
+	im := NewIMemTable[string, string]()
+	m := NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20, wal.NewReader(buf), wal.NewWriter(buf), 10*time.Minute, im, "multiget", config.NewConfig(t.TempDir()))
+	assert.NoError(t, m.Put("key", "v"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			m.Reset()
+			assert.NoError(t, m.Put(fmt.Sprintf("key%d", i), "v"))
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if !m.MultiGet([]string{"key"})[0].Found {
+			t.Fatal("key hidden by a concurrent Reset")
+		}
+	}
+}
+
+func TestMemTableGetRangeDeleted(t *testing.T) {
+	buf := new(bytes.Buffer)
+	im := NewIMemTable[string, string]()
+	m := NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20, wal.NewReader(buf), wal.NewWriter(buf), 10*time.Minute, im, "getrange", config.NewConfig(t.TempDir()))
+	assert.NoError(t, m.Put("a", "old"))
+	m.Reset()
+	assert.NoError(t, m.DeleteRange("a", "b"))
+
+	_, err := m.Get("a")
+	assert.ErrorIs(t, err, utils.ErrNotFound, "deleted by a range of the active table")
+}
+
+type recordFlusher struct {
+	mu     sync.Mutex
+	tables []*MemTable[string, string]
+}
+
+func (f *recordFlusher) FlushRecord(m *MemTable[string, string], extra string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tables = append(f.tables, m)
+	return nil
+}
+
+func (f *recordFlusher) flushed() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.tables)
+}
+
+func TestMemTableFlushOnSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	im := NewIMemTable[string, string]()
+	m := NewMemTable[string, string](&utils.OrderComparator[string]{}, 4096, wal.NewReader(buf), wal.NewWriter(buf), time.Hour, im, "size", config.NewConfig(t.TempDir()))
+	f := &recordFlusher{}
+	m.StartFlusher(f, "size")
+
+	value := strings.Repeat("v", 100)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, m.Put(fmt.Sprintf("key%03d", i), value))
+		assert.Less(t, m.ApproximateMemoryUsage(), int64(4096))
+	}
+	assert.NoError(t, m.Close())
+	assert.Equal(t, 0, im.Len(), "flushed tables are dropped")
+
+	n := 0
+	for _, table := range f.tables {
+		n += table.Rep().Len()
+		assert.LessOrEqual(t, table.ApproximateMemoryUsage(), int64(4096+200))
+	}
+	assert.Equal(t, 100, n)
+	assert.Greater(t, len(f.tables), 2)
+}
+
+func TestMemTableFlushOnAge(t *testing.T) {
+	buf := new(bytes.Buffer)
+	im := NewIMemTable[string, string]()
+	m := NewMemTable[string, string](&utils.OrderComparator[string]{}, 0, wal.NewReader(buf), wal.NewWriter(buf), 20*time.Millisecond, im, "age", config.NewConfig(t.TempDir()))
+	f := &recordFlusher{}
+	m.StartFlusher(f, "age")
+	defer m.Close()
+
+	assert.NoError(t, m.Put("a", "1"))
+	assert.Eventually(t, func() bool { return f.flushed() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return im.Len() == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, m.Rep().Len())
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, f.flushed(), "empty tables are not frozen")
+}
+
+func TestIMemTableNewestFirst(t *testing.T) {
+	buf := new(bytes.Buffer)
+	im := NewIMemTable[string, string]()
+	m := NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20, wal.NewReader(buf), wal.NewWriter(buf), time.Hour, im, "fifo", config.NewConfig(t.TempDir()))
+	assert.NoError(t, m.Put("a", "old"))
+	assert.NoError(t, m.Put("b", "old"))
+	m.Reset()
+	assert.NoError(t, m.Put("a", "new"))
+	assert.NoError(t, m.DeleteRange("b", "c"))
+	m.Reset()
+
+	v, err := im.Get("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", v)
+	_, err = im.Get("b")
+	assert.ErrorIs(t, err, utils.ErrNotFound, "deleted in the newest table")
+
+	oldest := im.Oldest()
+	assert.Same(t, oldest, im.PopOldest())
+	_, _, found := oldest.Rep().Lookup("b")
+	assert.True(t, found)
+	assert.Equal(t, 1, im.Len())
+	im.PopOldest()
+	assert.Nil(t, im.PopOldest())
+	assert.Nil(t, im.Oldest())
+}
+
+// blockingFlusher flushes a table each time release is sent to.
+type blockingFlusher struct {
+	release chan struct{}
+}
+
+func (f *blockingFlusher) FlushRecord(*MemTable[string, string], string) error {
+	<-f.release
+	return nil
+}
+
+func TestMemTableWriteStall(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	conf.MaxImmutableMemTables = 1
+	buf := new(bytes.Buffer)
+	im := NewIMemTable[string, string]()
+	m := NewMemTable[string, string](&utils.OrderComparator[string]{}, 1, wal.NewReader(buf), wal.NewWriter(buf), time.Hour, im, "stall", conf)
+	f := &blockingFlusher{release: make(chan struct{})}
+	m.StartFlusher(f, "stall")
+
+	assert.NoError(t, m.Put("a", "1"), "the first frozen table fits in the queue")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, m.Put("b", "2"))
+	}()
+	select {
+	case <-done:
+		t.Fatal("write did not stall")
+	case <-time.After(50 * time.Millisecond):
+	}
+	f.release <- struct{}{}
+	<-done
+	assert.Equal(t, int64(1), m.WriteStalls())
+	v, err := m.Get("b")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", v)
+
+	f.release <- struct{}{}
+	assert.NoError(t, m.Close())
+	assert.Equal(t, 0, im.Len())
+}
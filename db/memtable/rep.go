@@ -0,0 +1,152 @@
+package memtable
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// MemTableRep is the sorted structure holding the entries of a MemTable.
+// Writes are serialized by the MemTable; Lookup and iterators may run
+// concurrently with a write.
+type MemTableRep[K any, V any] interface {
+	Insert(K, V)
+	Delete(K)
+	DeleteRange(start, end K)
+	// Lookup returns the value of key and whether it is deleted; found is
+	// false when the rep does not hold key.
+	Lookup(K) (value V, deleted bool, found bool)
+	Len() int
+	// MemoryUsage returns the bytes held by the rep: its nodes and the
+	// contents of the keys and values.
+	MemoryUsage() int64
+	NewIterator() RepIterator[K, V]
+	Copy() MemTableRep[K, V]
+}
+
+// RepIterator walks the entries of a MemTableRep in key order, deleted ones
+// included.
+type RepIterator[K any, V any] interface {
+	Next() bool
+	Key() K
+	Value() V
+	Deleted() bool
+}
+
+// NewMemTableRep returns the rep selected by conf.MemTableRep.
+func NewMemTableRep[K any, V any](conf *config.Config, c utils.Comparator[K]) MemTableRep[K, V] {
+	if conf.MemTableRep == config.MemTableSkipList {
+		return NewSkipList[K, V](c)
+	}
+	return NewTreeRep[K, V](c)
+}
+
+// TreeRep is a MemTableRep over the red-black Tree; a read-write lock lets
+// readers share the tree while writes are exclusive.
+type TreeRep[K any, V any] struct {
+	mu   sync.RWMutex
+	tree *Tree[K, V]
+	size int64
+}
+
+var _ MemTableRep[int, int] = (*TreeRep[int, int])(nil)
+
+func NewTreeRep[K any, V any](c utils.Comparator[K]) *TreeRep[K, V] {
+	return &TreeRep[K, V]{tree: NewTree[K, V](c)}
+}
+
+func (r *TreeRep[K, V]) Insert(key K, value V) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if node := r.tree.FindKey(key); node != nil {
+		r.size += dataSize(value) - dataSize(node.Value)
+	} else {
+		r.size += int64(unsafe.Sizeof(Node[K, V]{})) + dataSize(key) + dataSize(value)
+	}
+	r.tree.Insert(key, value)
+}
+
+func (r *TreeRep[K, V]) Delete(key K) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tree.Delete(key)
+}
+
+func (r *TreeRep[K, V]) DeleteRange(start, end K) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tree.DeleteRange(start, end)
+}
+
+func (r *TreeRep[K, V]) Lookup(key K) (V, bool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if node := r.tree.FindKey(key); node != nil {
+		return node.Value, node.IsDeleted(), true
+	}
+	var v V
+	return v, false, false
+}
+
+func (r *TreeRep[K, V]) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.tree.Size
+}
+
+func (r *TreeRep[K, V]) MemoryUsage() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.size
+}
+
+// NewIterator returns an iterator over a snapshot of the entries.
+func (r *TreeRep[K, V]) NewIterator() RepIterator[K, V] {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	it := &treeIterator[K, V]{pos: -1}
+	r.tree.TraverseNodes(it.add, it.add)
+	return it
+}
+
+func (r *TreeRep[K, V]) Copy() MemTableRep[K, V] {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return &TreeRep[K, V]{tree: r.tree.DeepCopy(), size: r.size}
+}
+
+type treeIterator[K any, V any] struct {
+	entries []Node[K, V]
+	pos     int
+}
+
+func (it *treeIterator[K, V]) add(n *Node[K, V]) {
+	it.entries = append(it.entries, Node[K, V]{Key: n.Key, Value: n.Value, isDelete: n.isDelete})
+}
+
+func (it *treeIterator[K, V]) Next() bool {
+	if it.pos < len(it.entries) {
+		it.pos++
+	}
+	return it.pos < len(it.entries)
+}
+
+func (it *treeIterator[K, V]) Key() K { return it.entries[it.pos].Key }
+
+func (it *treeIterator[K, V]) Value() V { return it.entries[it.pos].Value }
+
+func (it *treeIterator[K, V]) Deleted() bool { return it.entries[it.pos].isDelete }
+
+// dataSize returns the bytes v refers to beyond its own size: the contents of
+// a string or a byte slice.
+func dataSize(v any) int64 {
+	switch v := v.(type) {
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(cap(v))
+	}
+	return 0
+}
@@ -0,0 +1,268 @@
+package memtable
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+const (
+	skipMaxHeight = 12
+	// skipBranching is the inverse probability of a node reaching the next
+	// level.
+	skipBranching = 4
+	// arena blocks start at arenaMinBlock elements and double up to
+	// arenaMaxBlock.
+	arenaMinBlock = 64
+	arenaMaxBlock = 4096
+)
+
+// SkipList is a sorted map for a single writer and any number of readers.
+// Readers take no lock: a node is fully built before the atomic store linking
+// it in, and the entry of a key is replaced as a whole, so a reader sees
+// either the old or the new state of a write. Nodes are never unlinked; a
+// deleted key keeps its node, marked deleted.
+type SkipList[K any, V any] struct {
+	head   *skipNode[K, V]
+	height atomic.Int32
+	cmp    utils.Comparator[K]
+	arena  *arena[K, V]
+	rnd    *rand.Rand
+	len    atomic.Int64
+	// data counts the bytes of the keys and values out of the arena.
+	data atomic.Int64
+}
+
+type skipNode[K any, V any] struct {
+	key   K
+	entry atomic.Pointer[skipEntry[V]]
+	next  [skipMaxHeight]atomic.Pointer[skipNode[K, V]]
+}
+
+type skipEntry[V any] struct {
+	value   V
+	deleted bool
+}
+
+var _ MemTableRep[int, int] = (*SkipList[int, int])(nil)
+
+func NewSkipList[K any, V any](cmp utils.Comparator[K]) *SkipList[K, V] {
+	s := &SkipList[K, V]{
+		cmp:   cmp,
+		arena: newArena[K, V](),
+		rnd:   rand.New(rand.NewSource(0xdecafbad)),
+	}
+	var zero K
+	s.head = s.arena.newNode(zero)
+	s.height.Store(1)
+	return s
+}
+
+func (s *SkipList[K, V]) randomHeight() int {
+	h := 1
+	for h < skipMaxHeight && s.rnd.Intn(skipBranching) == 0 {
+		h++
+	}
+	return h
+}
+
+// findGreaterOrEqual returns the first node whose key is >= key, and fills
+// prev, when set, with the last node before it at every level.
+func (s *SkipList[K, V]) findGreaterOrEqual(key K, prev []*skipNode[K, V]) *skipNode[K, V] {
+	x := s.head
+	level := int(s.height.Load()) - 1
+	for {
+		next := x.next[level].Load()
+		if next != nil && s.cmp.Compare(next.key, key) < 0 {
+			x = next
+			continue
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+		if level == 0 {
+			return next
+		}
+		level--
+	}
+}
+
+func (s *SkipList[K, V]) find(key K) *skipNode[K, V] {
+	x := s.findGreaterOrEqual(key, nil)
+	if x != nil && s.cmp.Compare(x.key, key) == 0 {
+		return x
+	}
+	return nil
+}
+
+// Insert sets the value of key. It must not run concurrently with another
+// write.
+func (s *SkipList[K, V]) Insert(key K, value V) {
+	var prev [skipMaxHeight]*skipNode[K, V]
+	x := s.findGreaterOrEqual(key, prev[:])
+	if x != nil && s.cmp.Compare(x.key, key) == 0 {
+		x.entry.Store(s.arena.newEntry(value, false))
+		s.data.Add(dataSize(value))
+		return
+	}
+
+	height := s.randomHeight()
+	if cur := int(s.height.Load()); height > cur {
+		for i := cur; i < height; i++ {
+			prev[i] = s.head
+		}
+		// readers seeing the new height before the node only find nil links
+		// from the head at the new levels
+		s.height.Store(int32(height))
+	}
+	x = s.arena.newNode(key)
+	x.entry.Store(s.arena.newEntry(value, false))
+	for i := 0; i < height; i++ {
+		x.next[i].Store(prev[i].next[i].Load())
+		prev[i].next[i].Store(x)
+	}
+	s.len.Add(1)
+	s.data.Add(dataSize(key) + dataSize(value))
+}
+
+// Delete marks key deleted when the list holds it.
+func (s *SkipList[K, V]) Delete(key K) {
+	if x := s.find(key); x != nil {
+		s.markDeleted(x)
+	}
+}
+
+// DeleteRange marks every key in [start, end) as deleted.
+func (s *SkipList[K, V]) DeleteRange(start, end K) {
+	for x := s.findGreaterOrEqual(start, nil); x != nil && s.cmp.Compare(x.key, end) < 0; x = x.next[0].Load() {
+		s.markDeleted(x)
+	}
+}
+
+func (s *SkipList[K, V]) markDeleted(x *skipNode[K, V]) {
+	if e := x.entry.Load(); !e.deleted {
+		x.entry.Store(s.arena.newEntry(e.value, true))
+	}
+}
+
+func (s *SkipList[K, V]) Lookup(key K) (V, bool, bool) {
+	if x := s.find(key); x != nil {
+		e := x.entry.Load()
+		return e.value, e.deleted, true
+	}
+	var v V
+	return v, false, false
+}
+
+// Len returns the number of keys in the list, deleted ones included.
+func (s *SkipList[K, V]) Len() int {
+	return int(s.len.Load())
+}
+
+// ArenaSize returns the bytes allocated by the arena of the list.
+func (s *SkipList[K, V]) ArenaSize() int64 {
+	return s.arena.size.Load()
+}
+
+// MemoryUsage returns the arena size plus the bytes of the keys and values.
+// Replaced entries stay in the arena and are still counted.
+func (s *SkipList[K, V]) MemoryUsage() int64 {
+	return s.arena.size.Load() + s.data.Load()
+}
+
+func (s *SkipList[K, V]) NewIterator() RepIterator[K, V] {
+	return &skipIterator[K, V]{list: s}
+}
+
+// Copy returns a list holding the entries of s.
+func (s *SkipList[K, V]) Copy() MemTableRep[K, V] {
+	c := NewSkipList[K, V](s.cmp)
+	for x := s.head.next[0].Load(); x != nil; x = x.next[0].Load() {
+		e := x.entry.Load()
+		c.Insert(x.key, e.value)
+		if e.deleted {
+			c.Delete(x.key)
+		}
+	}
+	return c
+}
+
+type skipIterator[K any, V any] struct {
+	list  *SkipList[K, V]
+	node  *skipNode[K, V]
+	entry *skipEntry[V]
+	done  bool
+}
+
+func (it *skipIterator[K, V]) Next() bool {
+	if it.done {
+		return false
+	}
+	if it.node == nil {
+		it.node = it.list.head.next[0].Load()
+	} else {
+		it.node = it.node.next[0].Load()
+	}
+	if it.node == nil {
+		it.done = true
+		return false
+	}
+	it.entry = it.node.entry.Load()
+	return true
+}
+
+func (it *skipIterator[K, V]) Key() K { return it.node.key }
+
+func (it *skipIterator[K, V]) Value() V { return it.entry.value }
+
+func (it *skipIterator[K, V]) Deleted() bool { return it.entry.deleted }
+
+// arena hands out the nodes and entries of a skiplist from blocks of
+// elements, so that writes cost a few allocations per block instead of two
+// per entry. Blocks are only released with the list. It is used by the single
+// writer only; size may be read concurrently.
+type arena[K any, V any] struct {
+	nodes   []skipNode[K, V]
+	entries []skipEntry[V]
+	block   int
+	size    atomic.Int64
+}
+
+func newArena[K any, V any]() *arena[K, V] {
+	return &arena[K, V]{block: arenaMinBlock}
+}
+
+// grow returns the element count of the next block.
+func (a *arena[K, V]) grow() int {
+	n := a.block
+	if a.block < arenaMaxBlock {
+		a.block *= 2
+	}
+	return n
+}
+
+func (a *arena[K, V]) newNode(key K) *skipNode[K, V] {
+	if len(a.nodes) == 0 {
+		n := a.grow()
+		a.nodes = make([]skipNode[K, V], n)
+		a.size.Add(int64(n) * int64(unsafe.Sizeof(skipNode[K, V]{})))
+	}
+	n := &a.nodes[0]
+	a.nodes = a.nodes[1:]
+	n.key = key
+	return n
+}
+
+func (a *arena[K, V]) newEntry(value V, deleted bool) *skipEntry[V] {
+	if len(a.entries) == 0 {
+		n := a.block
+		a.entries = make([]skipEntry[V], n)
+		a.size.Add(int64(n) * int64(unsafe.Sizeof(skipEntry[V]{})))
+	}
+	e := &a.entries[0]
+	a.entries = a.entries[1:]
+	e.value, e.deleted = value, deleted
+	return e
+}
@@ -0,0 +1,200 @@
+package memtable
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/peterouob/gocloud/db/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func repEntries(rep MemTableRep[int, int]) [][3]int {
+	var got [][3]int
+	for it := rep.NewIterator(); it.Next(); {
+		deleted := 0
+		if it.Deleted() {
+			deleted = 1
+		}
+		got = append(got, [3]int{it.Key(), it.Value(), deleted})
+	}
+	return got
+}
+
+func TestSkipListMatchesTree(t *testing.T) {
+	cmp := &utils.OrderComparator[int]{}
+	reps := []MemTableRep[int, int]{NewTreeRep[int, int](cmp), NewSkipList[int, int](cmp)}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		k := rnd.Intn(1000)
+		for _, rep := range reps {
+			switch op := i % 10; {
+			case op < 7:
+				rep.Insert(k, i)
+			case op < 9:
+				rep.Delete(k)
+			default:
+				rep.DeleteRange(k, k+20)
+			}
+		}
+	}
+
+	want := repEntries(reps[0])
+	assert.Equal(t, want, repEntries(reps[1]))
+	assert.Equal(t, len(want), reps[1].Len())
+	assert.True(t, sort.SliceIsSorted(want, func(i, j int) bool { return want[i][0] < want[j][0] }))
+	for k := -1; k <= 1000; k++ {
+		v, deleted, found := reps[0].Lookup(k)
+		sv, sdeleted, sfound := reps[1].Lookup(k)
+		assert.Equal(t, [3]any{v, deleted, found}, [3]any{sv, sdeleted, sfound}, k)
+	}
+	assert.Equal(t, want, repEntries(reps[1].Copy()))
+	assert.Positive(t, reps[1].(*SkipList[int, int]).ArenaSize())
+	assert.GreaterOrEqual(t, reps[1].MemoryUsage(), reps[1].(*SkipList[int, int]).ArenaSize())
+	assert.Positive(t, reps[0].MemoryUsage())
+}
+
+func TestSkipListConcurrentReads(t *testing.T) {
+	s := NewSkipList[int, int](&utils.OrderComparator[int]{})
+	const n = 20000
+	var written atomic.Int64
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(r)))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if w := written.Load(); w > 0 {
+					// keys are written in a random order, but every key below
+					// the count published by the writer is there
+					k := rnd.Intn(int(w))
+					v, deleted, found := s.Lookup(perm(k))
+					if !found || deleted || v != perm(k)*2 {
+						t.Errorf("key %d: %d %v %v", perm(k), v, deleted, found)
+						return
+					}
+				}
+				prev := -1
+				for it := s.NewIterator(); it.Next(); {
+					if it.Key() <= prev {
+						t.Errorf("iterator out of order: %d after %d", it.Key(), prev)
+						return
+					}
+					prev = it.Key()
+				}
+			}
+		}(r)
+	}
+	for i := 0; i < n; i++ {
+		s.Insert(perm(i), perm(i)*2)
+		written.Store(int64(i + 1))
+	}
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, n, s.Len())
+}
+
+// perm spreads 0..n over the key space in a fixed pseudo random order.
+func perm(i int) int {
+	return (i * 7919) % 100003
+}
+
+func TestMemTableSkipList(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	conf.MemTableRep = config.MemTableSkipList
+	buf := new(bytes.Buffer)
+	im := NewIMemTable[string, string]()
+	m := NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20, wal.NewReader(buf), wal.NewWriter(buf), time.Hour, im, "skiplist", conf)
+	_, ok := m.Rep().(*SkipList[string, string])
+	assert.True(t, ok)
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, m.Put(fmt.Sprintf("key%d", i), fmt.Sprintf("v%d", i)))
+	}
+	assert.NoError(t, m.DeleteRange("key3", "key5"))
+	v, err := m.Get("key2")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", v)
+	_, err = m.Get("key3")
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+
+	m.Reset()
+	assert.Equal(t, 0, m.Rep().Len())
+	assert.Equal(t, 1, im.Len())
+	v, err = m.Get("key9")
+	assert.NoError(t, err, "found in the immutable table")
+	assert.Equal(t, "v9", v)
+	_, err = m.Get("key4")
+	assert.ErrorIs(t, err, utils.ErrNotFound)
+}
+
+func benchmarkReps() map[string]func() MemTableRep[int, int] {
+	cmp := &utils.OrderComparator[int]{}
+	return map[string]func() MemTableRep[int, int]{
+		config.MemTableRBTree:   func() MemTableRep[int, int] { return NewTreeRep[int, int](cmp) },
+		config.MemTableSkipList: func() MemTableRep[int, int] { return NewSkipList[int, int](cmp) },
+	}
+}
+
+func BenchmarkRepInsert(b *testing.B) {
+	for name, newRep := range benchmarkReps() {
+		b.Run(name, func(b *testing.B) {
+			rep := newRep()
+			for i := 0; i < b.N; i++ {
+				rep.Insert(perm(i), i)
+			}
+		})
+	}
+}
+
+// BenchmarkRepParallelReadWrite measures lookups from parallel readers while
+// one writer keeps inserting.
+func BenchmarkRepParallelReadWrite(b *testing.B) {
+	const prefill = 50000
+	for name, newRep := range benchmarkReps() {
+		b.Run(name, func(b *testing.B) {
+			rep := newRep()
+			for i := 0; i < prefill; i++ {
+				rep.Insert(perm(i), i)
+			}
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := prefill; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+						rep.Insert(perm(i), i)
+					}
+				}
+			}()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					rep.Lookup(perm(rnd.Intn(prefill)))
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
@@ -41,6 +41,10 @@ type Node[K any, V any] struct {
 	isDelete bool
 }
 
+func (n *Node[K, V]) IsDeleted() bool {
+	return n.isDelete
+}
+
 func NewTree[K any, V any](comparator utils.Comparator[K]) *Tree[K, V] {
 	tree := new(Tree[K, V])
 	tree.Leaf = &Node[K, V]{color: black}
@@ -111,6 +115,7 @@ func (tree *Tree[K, V]) Insert(key K, value V) {
 			cur = cur.right
 		default:
 			cur.Value = value
+			cur.isDelete = false
 			return
 		}
 	}
@@ -204,6 +209,28 @@ func (tree *Tree[K, V]) Delete(key K) {
 	}
 }
 
+// DeleteRange marks every key in [start, end) as deleted.
+func (tree *Tree[K, V]) DeleteRange(start, end K) {
+	var mark func(node *Node[K, V])
+	mark = func(node *Node[K, V]) {
+		if node == nil || node == tree.Leaf {
+			return
+		}
+		lo := tree.comparator.Compare(node.Key, start)
+		hi := tree.comparator.Compare(node.Key, end)
+		if lo > 0 {
+			mark(node.left)
+		}
+		if lo >= 0 && hi < 0 {
+			node.isDelete = true
+		}
+		if hi < 0 {
+			mark(node.right)
+		}
+	}
+	mark(tree.root)
+}
+
 func (tree *Tree[K, V]) TraverseNodes(fn func(node *Node[K, V]), dfn func(node *Node[K, V])) {
 	if tree.root == tree.Leaf {
 		return
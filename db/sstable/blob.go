@@ -0,0 +1,390 @@
+package sstable
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Values of at least conf.BlobThreshold bytes are stored in blob files and the
+// SST keeps a KindBlobIndex entry holding a BlobRef. A blob record is
+//
+//	crc(4) | keyLen(uvarint) | valueLen(uvarint) | key | value
+//
+// where the crc covers everything after it. Blob files are immutable once the
+// flush or GC that wrote them finishes.
+
+const blobExt = ".blob"
+
+var ErrBlobCorrupted = errors.New("blob record corrupted")
+
+type BlobRef struct {
+	FileNum uint64
+	Offset  uint64
+	Size    uint64
+}
+
+func (r BlobRef) Encode() []byte {
+	buf := make([]byte, 3*binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, r.FileNum)
+	n += binary.PutUvarint(buf[n:], r.Offset)
+	n += binary.PutUvarint(buf[n:], r.Size)
+	return buf[:n]
+}
+
+func DecodeBlobRef(buf []byte) (BlobRef, error) {
+	var ref BlobRef
+	var n, m int
+	if ref.FileNum, n = binary.Uvarint(buf); n <= 0 {
+		return ref, ErrBlobCorrupted
+	}
+	if ref.Offset, m = binary.Uvarint(buf[n:]); m <= 0 {
+		return ref, ErrBlobCorrupted
+	}
+	n += m
+	if ref.Size, m = binary.Uvarint(buf[n:]); m <= 0 {
+		return ref, ErrBlobCorrupted
+	}
+	return ref, nil
+}
+
+func blobFileName(num uint64) string {
+	return fmt.Sprintf("%06d%s", num, blobExt)
+}
+
+func encodeBlobRecord(key, value []byte) []byte {
+	buf := make([]byte, 4+2*binary.MaxVarintLen64+len(key)+len(value))
+	n := 4
+	n += binary.PutUvarint(buf[n:], uint64(len(key)))
+	n += binary.PutUvarint(buf[n:], uint64(len(value)))
+	n += copy(buf[n:], key)
+	n += copy(buf[n:], value)
+	binary.LittleEndian.PutUint32(buf, utils.CompressedCheckSum(buf[4:n]))
+	return buf[:n]
+}
+
+func decodeBlobRecord(buf []byte) ([]byte, []byte, error) {
+	if len(buf) < 6 || binary.LittleEndian.Uint32(buf) != utils.CompressedCheckSum(buf[4:]) {
+		return nil, nil, ErrBlobCorrupted
+	}
+	keyLen, n := binary.Uvarint(buf[4:])
+	if n <= 0 {
+		return nil, nil, ErrBlobCorrupted
+	}
+	valueLen, m := binary.Uvarint(buf[4+n:])
+	if m <= 0 {
+		return nil, nil, ErrBlobCorrupted
+	}
+	data := buf[4+n+m:]
+	if uint64(len(data)) != keyLen+valueLen {
+		return nil, nil, ErrBlobCorrupted
+	}
+	return data[:keyLen], data[keyLen:], nil
+}
+
+// BlobStore owns the blob files of one LSMTree.
+type BlobStore struct {
+	mu      sync.Mutex
+	conf    *config.Config
+	files   map[uint64]*os.File
+	nextNum atomic.Uint64
+}
+
+func NewBlobStore(conf *config.Config) *BlobStore {
+	s := &BlobStore{
+		conf:  conf,
+		files: make(map[uint64]*os.File),
+	}
+	for _, num := range s.fileNums() {
+		if num > s.nextNum.Load() {
+			s.nextNum.Store(num)
+		}
+	}
+	return s
+}
+
+// fileNums lists the blob files present in conf.Dir in ascending order.
+func (s *BlobStore) fileNums() []uint64 {
+	matches, _ := filepath.Glob(path.Join(s.conf.Dir, "*"+blobExt))
+	nums := make([]uint64, 0, len(matches))
+	for _, m := range matches {
+		num, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(m), blobExt), 10, 64)
+		if err == nil {
+			nums = append(nums, num)
+		}
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums
+}
+
+func (s *BlobStore) file(num uint64) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fd, ok := s.files[num]; ok {
+		return fd, nil
+	}
+	fd, err := os.Open(path.Join(s.conf.Dir, blobFileName(num)))
+	if err != nil {
+		return nil, err
+	}
+	s.files[num] = fd
+	return fd, nil
+}
+
+// Read returns the value referenced by ref.
+func (s *BlobStore) Read(ref BlobRef) ([]byte, error) {
+	_, value, err := s.readRecord(ref)
+	return value, err
+}
+
+func (s *BlobStore) readRecord(ref BlobRef) ([]byte, []byte, error) {
+	fd, err := s.file(ref.FileNum)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open blob file %d: %v", ref.FileNum, err)
+	}
+	buf := make([]byte, ref.Size)
+	if _, err := fd.ReadAt(buf, int64(ref.Offset)); err != nil {
+		return nil, nil, fmt.Errorf("read blob file %d at %d: %v", ref.FileNum, ref.Offset, err)
+	}
+	key, value, err := decodeBlobRecord(buf)
+	if err != nil {
+		return nil, nil, utils.NewCorruptionError(blobFileName(ref.FileNum), ref.Offset, err)
+	}
+	return key, value, nil
+}
+
+// scan calls fn for every record of blob file num.
+func (s *BlobStore) scan(num uint64, fn func(key []byte, ref BlobRef) error) (int64, error) {
+	fd, err := os.Open(path.Join(s.conf.Dir, blobFileName(num)))
+	if err != nil {
+		return 0, err
+	}
+	defer fd.Close()
+
+	r := bufio.NewReader(fd)
+	var offset uint64
+	for {
+		header := make([]byte, 4+2*binary.MaxVarintLen64)
+		n, err := io.ReadFull(r, header[:4])
+		if err == io.EOF {
+			return int64(offset), nil
+		}
+		if err != nil {
+			return int64(offset), err
+		}
+		keyLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return int64(offset), err
+		}
+		n += binary.PutUvarint(header[n:], keyLen)
+		valueLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return int64(offset), err
+		}
+		n += binary.PutUvarint(header[n:], valueLen)
+
+		data := make([]byte, keyLen+valueLen)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return int64(offset), err
+		}
+		record := append(header[:n], data...)
+		key, _, err := decodeBlobRecord(record)
+		if err != nil {
+			return int64(offset), fmt.Errorf("blob file %d at %d: %v", num, offset, err)
+		}
+
+		ref := BlobRef{FileNum: num, Offset: offset, Size: uint64(len(record))}
+		if err := fn(key, ref); err != nil {
+			return int64(offset), err
+		}
+		offset += ref.Size
+	}
+}
+
+// Remove deletes blob file num.
+func (s *BlobStore) Remove(num uint64) error {
+	s.mu.Lock()
+	if fd, ok := s.files[num]; ok {
+		fd.Close()
+		delete(s.files, num)
+	}
+	s.mu.Unlock()
+	return os.Remove(path.Join(s.conf.Dir, blobFileName(num)))
+}
+
+// Close closes the blob files opened for reading.
+func (s *BlobStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for num, fd := range s.files {
+		if cerr := fd.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.files, num)
+	}
+	return err
+}
+
+// NewWriter creates the next blob file.
+func (s *BlobStore) NewWriter() (*BlobWriter, error) {
+	num := s.nextNum.Add(1)
+	fd, err := os.OpenFile(path.Join(s.conf.Dir, blobFileName(num)), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.New("error in create blob file : " + err.Error())
+	}
+	return &BlobWriter{num: num, fd: fd, w: bufio.NewWriter(fd)}, nil
+}
+
+type BlobWriter struct {
+	num    uint64
+	fd     *os.File
+	w      *bufio.Writer
+	offset uint64
+}
+
+func (w *BlobWriter) Add(key, value []byte) (BlobRef, error) {
+	record := encodeBlobRecord(key, value)
+	if _, err := w.w.Write(record); err != nil {
+		return BlobRef{}, err
+	}
+	ref := BlobRef{FileNum: w.num, Offset: w.offset, Size: uint64(len(record))}
+	w.offset += ref.Size
+	return ref, nil
+}
+
+// Close flushes and syncs the blob file; it must be called before the SST
+// referencing it is installed. An empty file is removed.
+func (w *BlobWriter) Close() error {
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	if err := w.fd.Sync(); err != nil {
+		return err
+	}
+	if err := w.fd.Close(); err != nil {
+		return err
+	}
+	if w.offset == 0 {
+		return os.Remove(w.fd.Name())
+	}
+	return nil
+}
+
+type blobEntry struct {
+	key []byte
+	ref BlobRef
+}
+
+// BlobGC rewrites the blob files whose garbage reached conf.BlobGCRatio of
+// their size. Live values are copied to a new blob file and their new
+// references installed with a level 0 table before the old files are removed.
+// It returns the numbers of the removed files.
+func (t *LSMTree[K, V]) BlobGC() ([]uint64, error) {
+	t.blobMu.Lock()
+	defer t.blobMu.Unlock()
+
+	var live []blobEntry
+	var collected []uint64
+	for _, num := range t.blobs.fileNums() {
+		var entries []blobEntry
+		var liveSize uint64
+		total, err := t.blobs.scan(num, func(key []byte, ref BlobRef) error {
+			record, err := t.lookup(key)
+			if err != nil || record == nil || record.Kind != KindBlobIndex {
+				return err
+			}
+			cur, err := DecodeBlobRef(record.Value)
+			if err != nil {
+				return err
+			}
+			if cur == ref {
+				entries = append(entries, blobEntry{key: key, ref: ref})
+				liveSize += ref.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan blob file %d: %v", num, err)
+		}
+		if total == 0 || float64(uint64(total)-liveSize) < t.conf.BlobGCRatio*float64(total) {
+			continue
+		}
+		live = append(live, entries...)
+		collected = append(collected, num)
+	}
+
+	if len(live) > 0 {
+		if err := t.relocateBlobs(live); err != nil {
+			return nil, err
+		}
+	}
+	for _, num := range collected {
+		if err := t.blobs.Remove(num); err != nil {
+			return nil, errors.New("error in remove blob file : " + err.Error())
+		}
+	}
+	return collected, nil
+}
+
+func (t *LSMTree[K, V]) relocateBlobs(live []blobEntry) error {
+	sort.Slice(live, func(i, j int) bool { return t.conf.KeyComparator().Compare(live[i].key, live[j].key) < 0 })
+
+	level := 0
+	seqNo := t.NextSeqNo(level)
+	extra := "blobgc"
+	file := utils.FormatName(level, seqNo, extra)
+	w, err := NewSStWriter(file, t.conf)
+	if err != nil {
+		return errors.New("error in new ssWriter : " + err.Error())
+	}
+	defer w.Close()
+	w.SetRateLimiter(t.limiter, IOLow)
+
+	blob, err := t.blobs.NewWriter()
+	if err != nil {
+		return err
+	}
+	for _, e := range live {
+		_, value, err := t.blobs.readRecord(e.ref)
+		if err != nil {
+			return err
+		}
+		ref, err := blob.Add(e.key, value)
+		if err != nil {
+			return errors.New("error in write blob : " + err.Error())
+		}
+		w.AppendEntry(e.key, ref.Encode(), KindBlobIndex, t.lastSeq.Add(1))
+	}
+	if err := blob.Close(); err != nil {
+		return errors.New("error in close blob : " + err.Error())
+	}
+
+	size, filter, index, err := w.Finish()
+	if err != nil {
+		return errors.New("error in finish : " + err.Error())
+	}
+	node, err := t.newNode(filter, index, level, seqNo, extra, size, file)
+	if err != nil {
+		return errors.New("error in new Node after blob gc: " + err.Error())
+	}
+	if err := t.apply([]*Node{node}, nil); err != nil {
+		return err
+	}
+	t.compactChan <- level
+	return nil
+}
@@ -0,0 +1,93 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/memtable"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/peterouob/gocloud/db/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func flushTestMemTable(t *testing.T, lsmt *LSMTree[string, string], conf *config.Config, kvs map[string]string) {
+	buf := new(bytes.Buffer)
+	im := memtable.NewIMemTable[string, string]()
+	m := memtable.NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20,
+		wal.NewReader(buf), wal.NewWriter(buf), time.Hour, im, "blob", conf)
+	for k, v := range kvs {
+		assert.NoError(t, m.Put(k, v))
+	}
+	assert.NoError(t, lsmt.FlushRecord(m, "test"))
+}
+
+func TestBlobRecord(t *testing.T) {
+	ref := BlobRef{FileNum: 3, Offset: 1 << 20, Size: 300}
+	got, err := DecodeBlobRef(ref.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, ref, got)
+
+	record := encodeBlobRecord([]byte("key"), []byte("value"))
+	key, value, err := decodeBlobRecord(record)
+	assert.NoError(t, err)
+	assert.Equal(t, "key", string(key))
+	assert.Equal(t, "value", string(value))
+
+	record[len(record)-1] ^= 0xff
+	_, _, err = decodeBlobRecord(record)
+	assert.ErrorIs(t, err, ErrBlobCorrupted)
+}
+
+func TestBlobSeparation(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	conf.BlobThreshold = 64
+	lsmt := NewLSMTree[string, string](conf)
+
+	big := func(i, version int) string {
+		return fmt.Sprintf("%d-%d-", i, version) + strings.Repeat("x", 200)
+	}
+	kvs := map[string]string{"small": "v"}
+	for i := 0; i < 10; i++ {
+		kvs[fmt.Sprintf("key%02d", i)] = big(i, 1)
+	}
+	flushTestMemTable(t, lsmt, conf, kvs)
+
+	for k, v := range kvs {
+		assert.Equal(t, v, string(getValue(t, lsmt, k)))
+	}
+	stats, err := lsmt.LevelStats()
+	assert.NoError(t, err)
+	assert.Less(t, stats[0].RawValueSize, uint64(10*200), "large values should not be stored in the sst")
+	assert.FileExists(t, path.Join(conf.Dir, blobFileName(1)))
+
+	overwrite := make(map[string]string)
+	for i := 0; i < 8; i++ {
+		overwrite[fmt.Sprintf("key%02d", i)] = big(i, 2)
+	}
+	overwrite["key01"] = "inline"
+	flushTestMemTable(t, lsmt, conf, overwrite)
+	for k, v := range overwrite {
+		kvs[k] = v
+	}
+
+	collected, err := lsmt.BlobGC()
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1}, collected)
+	_, err = os.Stat(path.Join(conf.Dir, blobFileName(1)))
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, path.Join(conf.Dir, blobFileName(2)))
+
+	for k, v := range kvs {
+		assert.Equal(t, v, string(getValue(t, lsmt, k)))
+	}
+
+	collected, err = lsmt.BlobGC()
+	assert.NoError(t, err)
+	assert.Empty(t, collected)
+}
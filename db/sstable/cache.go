@@ -0,0 +1,117 @@
+package sstable
+
+import (
+	"container/list"
+	"sync"
+)
+
+type cacheKey struct {
+	file   string
+	offset uint64
+}
+
+type cacheEntry struct {
+	key    cacheKey
+	value  any
+	charge int64
+}
+
+// BlockCache is an LRU cache of decoded blocks shared by the nodes of one
+// LSMTree. Entries are charged by their on-disk block size. A nil cache
+// caches nothing.
+type BlockCache struct {
+	mu       sync.Mutex
+	capacity int64
+	size     int64
+	ll       *list.List
+	items    map[cacheKey]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+func NewBlockCache(capacity int64) *BlockCache {
+	return &BlockCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[cacheKey]*list.Element),
+	}
+}
+
+func (c *BlockCache) Get(key cacheKey) (any, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).value, true
+}
+
+func (c *BlockCache) Put(key cacheKey, value any, charge int64) {
+	if c == nil || charge > c.capacity {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		c.size += charge - entry.charge
+		entry.value = value
+		entry.charge = charge
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&cacheEntry{key: key, value: value, charge: charge})
+		c.size += charge
+	}
+
+	for c.size > c.capacity {
+		e := c.ll.Back()
+		entry := e.Value.(*cacheEntry)
+		c.ll.Remove(e)
+		delete(c.items, entry.key)
+		c.size -= entry.charge
+	}
+}
+
+// Evict drops every block cached for file.
+func (c *BlockCache) Evict(file string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.items {
+		if key.file == file {
+			c.ll.Remove(e)
+			delete(c.items, key)
+			c.size -= e.Value.(*cacheEntry).charge
+		}
+	}
+}
+
+func (c *BlockCache) Size() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *BlockCache) Stats() (hits, misses uint64) {
+	if c == nil {
+		return 0, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
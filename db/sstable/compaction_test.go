@@ -0,0 +1,139 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// buildLevel0 writes five overlapping level 0 files, later files holding
+// newer versions of the keys they share with earlier ones.
+func buildLevel0(t *testing.T, conf *config.Config) (*LSMTree[string, string], map[string]Record) {
+	lsmt := NewLSMTree[string, string](conf)
+	want := make(map[string]Record)
+	for f := 1; f <= 5; f++ {
+		file := utils.FormatName(0, f, "test")
+		w, err := NewSStWriter(file, conf)
+		assert.NoError(t, err)
+		for i := f; i < 600; i += f {
+			key := fmt.Sprintf("key%04d", i)
+			rec := Record{Key: []byte(key), Value: []byte(fmt.Sprintf("%d-%d", f, i)), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)}
+			if i%7 == f {
+				rec.Kind, rec.Value = KindDeletion, []byte{}
+			}
+			w.AppendEntry(rec.Key, rec.Value, rec.Kind, rec.Seq)
+			want[key] = rec
+		}
+		size, filter, index, err := w.Finish()
+		assert.NoError(t, err)
+		w.Close()
+
+		node, err := lsmt.newNode(filter, index, 0, f, "test", size, file)
+		assert.NoError(t, err)
+		lsmt.insertNode(node)
+		lsmt.NextSeqNo(0)
+	}
+	return lsmt, want
+}
+
+func levelRecords(nodes []*Node) []Record {
+	nodes = append([]*Node(nil), nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return bytes.Compare(nodes[i].startKey, nodes[j].startKey) < 0 })
+
+	var records []Record
+	for _, n := range nodes {
+		it := n.newIterator(nil, nil)
+		for rec := it.nextRecord(); rec != nil; rec = it.nextRecord() {
+			records = append(records, Record{Key: rec.Key, Value: rec.Value, Kind: rec.Kind, Seq: rec.Seq})
+		}
+	}
+	return records
+}
+
+func TestSubcompactionMatchesSerial(t *testing.T) {
+	outputs := make(map[int][]Record)
+	for _, workers := range []int{1, 4} {
+		conf := config.NewConfig(t.TempDir())
+		conf.SstDataBlockSize = 256
+		conf.CompactionWorkers = workers
+		lsmt, want := buildLevel0(t, conf)
+
+		assert.NoError(t, lsmt.compaction(0))
+		assert.Empty(t, lsmt.tree[0])
+		if workers > 1 {
+			assert.Greater(t, len(lsmt.tree[1]), 1, "each subcompaction writes its own file")
+		}
+
+		records := levelRecords(lsmt.tree[1])
+		assert.Len(t, records, len(want))
+		for i, rec := range records {
+			assert.Equal(t, want[string(rec.Key)], rec)
+			if i > 0 {
+				assert.Equal(t, 1, bytes.Compare(rec.Key, records[i-1].Key))
+			}
+		}
+		outputs[workers] = records
+
+		m, err := ReadManifest(conf)
+		assert.NoError(t, err)
+		assert.Len(t, m.Files, len(lsmt.tree[1]))
+	}
+	assert.Equal(t, outputs[1], outputs[4])
+}
+
+func TestSubcompactionRanges(t *testing.T) {
+	node := &Node{index: []*Index{{Key: []byte("a")}, {Key: []byte("c")}, {Key: []byte("e")}, {Key: []byte("g")}}}
+	assert.Equal(t, []keyRange{{}}, subcompactionRanges(utils.BytewiseComparator, []*Node{node}, 1))
+
+	ranges := subcompactionRanges(utils.BytewiseComparator, []*Node{node, node}, 8)
+	assert.Equal(t, []keyRange{
+		{end: []byte("c")},
+		{start: []byte("c"), end: []byte("e")},
+		{start: []byte("e"), end: []byte("g")},
+		{start: []byte("g")},
+	}, ranges)
+}
+
+func TestTrivialMove(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+
+	record := func(key string) Record {
+		return Record{Key: []byte(key), Value: []byte("v-" + key), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)}
+	}
+	addTestNode(t, lsmt, 1, []Record{record("a"), record("b")})
+	addTestNode(t, lsmt, 0, []Record{record("m"), record("n")})
+	node := lsmt.tree[0][0]
+
+	assert.NoError(t, lsmt.compaction(0))
+	assert.Empty(t, lsmt.tree[0])
+	assert.Len(t, lsmt.tree[1], 2)
+	assert.Contains(t, lsmt.tree[1], node, "the file is moved, not rewritten")
+	assert.Equal(t, 1, node.Level)
+	assert.Equal(t, []byte("v-m"), getValue(t, lsmt, "m"))
+
+	stats := lsmt.CompactionStats()
+	assert.Equal(t, uint64(1), stats.TrivialMoves)
+	assert.Equal(t, node.FileSize, stats.TrivialMoveBytes)
+	assert.Zero(t, stats.Compactions)
+
+	m, err := ReadManifest(conf)
+	assert.NoError(t, err)
+	for _, f := range m.Files {
+		assert.Equal(t, 1, f.Level)
+	}
+
+	addTestNode(t, lsmt, 0, []Record{record("b"), record("c")})
+	assert.NoError(t, lsmt.compaction(0))
+	stats = lsmt.CompactionStats()
+	assert.Equal(t, uint64(1), stats.TrivialMoves)
+	assert.Equal(t, uint64(1), stats.Compactions, "an overlapping file is merged")
+	assert.Equal(t, uint64(2), stats.InputFiles)
+	assert.Equal(t, []byte("v-b"), getValue(t, lsmt, "b"))
+	assert.Equal(t, []byte("v-a"), getValue(t, lsmt, "a"))
+}
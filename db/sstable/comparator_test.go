@@ -0,0 +1,64 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/memtable"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/peterouob/gocloud/db/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseComparator(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	conf.Comparator = utils.ReverseBytewiseComparator
+	conf.SstDataBlockSize = 64
+	lsmt := NewLSMTree[string, string](conf)
+
+	flush := func(from, to int, value string) {
+		buf := new(bytes.Buffer)
+		m := memtable.NewMemTable[string, string](&utils.CodecComparator[string]{Codec: utils.StringCodec{}, Order: conf.Comparator}, 1<<20,
+			wal.NewReader(buf), wal.NewWriter(buf), time.Hour, memtable.NewIMemTable[string, string](), "reverse", conf)
+		for i := from; i < to; i++ {
+			assert.NoError(t, m.Put(fmt.Sprintf("key%03d", i), value))
+		}
+		assert.NoError(t, lsmt.FlushRecord(m, "test"))
+	}
+	flush(0, 100, "old")
+	flush(50, 150, "new")
+	assert.Greater(t, len(lsmt.tree[0][0].index), 2)
+
+	ref := make(map[string]string)
+	for i := 0; i < 150; i++ {
+		ref[fmt.Sprintf("key%03d", i)] = "old"
+		if i >= 50 {
+			ref[fmt.Sprintf("key%03d", i)] = "new"
+		}
+	}
+	check := func(lsmt *LSMTree[string, string]) {
+		for k, v := range ref {
+			assert.Equal(t, []byte(v), getValue(t, lsmt, k), k)
+		}
+		assert.Nil(t, getValue(t, lsmt, "key150"))
+		got := scanTree(t, lsmt, []byte("key120"), []byte("key040"))
+		assert.Len(t, got, 80)
+		assert.Equal(t, [2]string{"key120", "new"}, got[0])
+		assert.Equal(t, [2]string{"key041", "old"}, got[79])
+	}
+	check(lsmt)
+
+	assert.NoError(t, lsmt.compaction(0))
+	assert.Empty(t, lsmt.tree[0])
+	check(lsmt)
+
+	_, err := RestoreLSMTree[string, string](config.NewConfig(conf.Dir))
+	assert.ErrorIs(t, err, ErrComparatorMismatch)
+
+	restored, err := RestoreLSMTree[string, string](conf)
+	assert.NoError(t, err)
+	check(restored)
+}
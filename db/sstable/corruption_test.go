@@ -0,0 +1,54 @@
+package sstable
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCorruption(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	var records []Record
+	for i := 0; i < 10; i++ {
+		records = append(records, Record{Key: []byte(fmt.Sprintf("key%02d", i)), Value: []byte("v"), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)})
+	}
+	addTestNode(t, lsmt, 1, records)
+	node := lsmt.tree[1][0]
+
+	fd, err := os.OpenFile(path.Join(conf.Dir, node.file), os.O_RDWR, 0)
+	assert.NoError(t, err)
+	_, err = fd.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, 4)
+	assert.NoError(t, err)
+	assert.NoError(t, fd.Close())
+
+	_, found, err := lsmt.Get("key05")
+	assert.False(t, found)
+	assert.True(t, errors.Is(err, utils.ErrCorruption), err)
+	var corruption *utils.CorruptionError
+	assert.True(t, errors.As(err, &corruption))
+	assert.Equal(t, node.file, corruption.File)
+	assert.Equal(t, uint64(0), corruption.Offset)
+
+	it := lsmt.NewIterator(nil, nil)
+	assert.False(t, it.Next())
+	assert.True(t, errors.Is(it.Err(), utils.ErrCorruption), it.Err())
+
+	assert.NoError(t, lsmt.Close())
+	_, _, err = lsmt.Get("key05")
+	assert.True(t, errors.Is(err, utils.ErrClosed), err)
+	assert.True(t, errors.Is(lsmt.NewIterator(nil, nil).Err(), utils.ErrClosed))
+}
+
+func TestGetUnsupportedKey(t *testing.T) {
+	type key struct{ id int }
+	lsmt := NewLSMTree[key, string](config.NewConfig(t.TempDir()))
+	_, _, err := lsmt.Get(key{id: 1})
+	assert.True(t, errors.Is(err, utils.ErrUnsupportedType), err)
+}
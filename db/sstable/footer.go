@@ -0,0 +1,176 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// Footer layout (FooterSize bytes, little endian):
+//
+//	filter handle    offset(8) size(8)
+//	index handle     offset(8) size(8)
+//	meta handle      offset(8) size(8)
+//	checksum type    1
+//	compression type 1
+//	reserved         2
+//	format version   4
+//	footer crc       4  (crc32c of the preceding 56 bytes)
+//	magic number     8
+const (
+	FooterSize = 68
+
+	footerMagic uint64 = 0x7473732e64756f6c // "loud.sst"
+
+	footerCRCOffset   = 56
+	footerMagicOffset = 60
+)
+
+const (
+	// FormatLegacy is the uvarint footer written before the footer was versioned.
+	FormatLegacy uint32 = iota
+	FormatV1
+	// FormatV2 tags every value with its kind and sequence number and adds the
+	// properties meta block.
+	FormatV2
+)
+
+const CurrentFormatVersion = FormatV2
+
+type ChecksumType uint8
+
+const (
+	ChecksumNone ChecksumType = iota
+	ChecksumCRC32C
+)
+
+type CompressionType uint8
+
+const (
+	CompressionNone CompressionType = iota
+	CompressionSnappy
+)
+
+var (
+	ErrBadMagic       = errors.New("sst footer: bad magic number, not an sst file or truncated")
+	ErrFooterChecksum = errors.New("sst footer: checksum mismatch")
+)
+
+type ErrUnsupportedVersion struct {
+	Version uint32
+}
+
+func (e *ErrUnsupportedVersion) Error() string {
+	return fmt.Sprintf("sst footer: unsupported format version %d (max %d)", e.Version, CurrentFormatVersion)
+}
+
+type BlockHandle struct {
+	Offset uint64
+	Size   uint64
+}
+
+func (h BlockHandle) IsZero() bool {
+	return h.Offset == 0 && h.Size == 0
+}
+
+type Footer struct {
+	Filter      BlockHandle
+	Index       BlockHandle
+	Meta        BlockHandle // meta-index block, zero when the table has none
+	Checksum    ChecksumType
+	Compression CompressionType
+	Version     uint32
+}
+
+func NewFooter(filter, index BlockHandle) *Footer {
+	return &Footer{
+		Filter:      filter,
+		Index:       index,
+		Checksum:    ChecksumCRC32C,
+		Compression: CompressionSnappy,
+		Version:     CurrentFormatVersion,
+	}
+}
+
+func (f *Footer) Encode() []byte {
+	buf := make([]byte, FooterSize)
+	binary.LittleEndian.PutUint64(buf[0:], f.Filter.Offset)
+	binary.LittleEndian.PutUint64(buf[8:], f.Filter.Size)
+	binary.LittleEndian.PutUint64(buf[16:], f.Index.Offset)
+	binary.LittleEndian.PutUint64(buf[24:], f.Index.Size)
+	binary.LittleEndian.PutUint64(buf[32:], f.Meta.Offset)
+	binary.LittleEndian.PutUint64(buf[40:], f.Meta.Size)
+	buf[48] = byte(f.Checksum)
+	buf[49] = byte(f.Compression)
+	binary.LittleEndian.PutUint32(buf[52:], f.Version)
+	binary.LittleEndian.PutUint32(buf[footerCRCOffset:], utils.CompressedCheckSum(buf[:footerCRCOffset]))
+	binary.LittleEndian.PutUint64(buf[footerMagicOffset:], footerMagic)
+	return buf
+}
+
+func HasFooterMagic(buf []byte) bool {
+	return len(buf) >= FooterSize && binary.LittleEndian.Uint64(buf[len(buf)-8:]) == footerMagic
+}
+
+// DecodeFooter decodes the last FooterSize bytes of buf.
+func DecodeFooter(buf []byte) (*Footer, error) {
+	if !HasFooterMagic(buf) {
+		return nil, ErrBadMagic
+	}
+	buf = buf[len(buf)-FooterSize:]
+
+	version := binary.LittleEndian.Uint32(buf[52:])
+	if version == FormatLegacy || version > CurrentFormatVersion {
+		return nil, &ErrUnsupportedVersion{Version: version}
+	}
+	if binary.LittleEndian.Uint32(buf[footerCRCOffset:]) != utils.CompressedCheckSum(buf[:footerCRCOffset]) {
+		return nil, ErrFooterChecksum
+	}
+
+	f := &Footer{
+		Filter:      BlockHandle{binary.LittleEndian.Uint64(buf[0:]), binary.LittleEndian.Uint64(buf[8:])},
+		Index:       BlockHandle{binary.LittleEndian.Uint64(buf[16:]), binary.LittleEndian.Uint64(buf[24:])},
+		Meta:        BlockHandle{binary.LittleEndian.Uint64(buf[32:]), binary.LittleEndian.Uint64(buf[40:])},
+		Checksum:    ChecksumType(buf[48]),
+		Compression: CompressionType(buf[49]),
+		Version:     version,
+	}
+	if f.Checksum > ChecksumCRC32C {
+		return nil, fmt.Errorf("sst footer: unknown checksum type %d", f.Checksum)
+	}
+	if f.Compression > CompressionSnappy {
+		return nil, fmt.Errorf("sst footer: unknown compression type %d", f.Compression)
+	}
+	return f, nil
+}
+
+// decodeLegacyFooter reads the pre-versioning footer: four uvarints padded to
+// footerSize bytes, directly following the index block.
+func decodeLegacyFooter(buf []byte, fileSize int64) (*Footer, error) {
+	r := bytes.NewReader(buf)
+	var vals [4]uint64
+	for i := range vals {
+		v, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, ErrBadMagic
+		}
+		vals[i] = v
+	}
+
+	f := &Footer{
+		Filter:      BlockHandle{vals[0], vals[1]},
+		Index:       BlockHandle{vals[2], vals[3]},
+		Checksum:    ChecksumCRC32C,
+		Compression: CompressionSnappy,
+		Version:     FormatLegacy,
+	}
+
+	if f.Filter.Offset == 0 || f.Filter.Size == 0 || f.Index.Offset == 0 || f.Index.Size == 0 ||
+		f.Filter.Offset+f.Filter.Size != f.Index.Offset ||
+		int64(f.Index.Offset+f.Index.Size)+int64(len(buf)) != fileSize {
+		return nil, ErrBadMagic
+	}
+	return f, nil
+}
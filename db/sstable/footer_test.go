@@ -0,0 +1,102 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestTable(t *testing.T, conf *config.Config, file string, n int) {
+	w, err := NewSStWriter(file, conf)
+	assert.NoError(t, err)
+	for i := 0; i < n; i++ {
+		w.Append([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	_, _, _, err = w.Finish()
+	assert.NoError(t, err)
+	w.Close()
+}
+
+func TestFooterEncodeDecode(t *testing.T) {
+	f := NewFooter(BlockHandle{Offset: 100, Size: 20}, BlockHandle{Offset: 120, Size: 40})
+	buf := f.Encode()
+	assert.Len(t, buf, FooterSize)
+
+	got, err := DecodeFooter(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, f, got)
+
+	buf[3] ^= 0xff
+	_, err = DecodeFooter(buf)
+	assert.ErrorIs(t, err, ErrFooterChecksum)
+}
+
+func TestReadFooterUnsupportedVersion(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	writeTestTable(t, conf, "1.sst", 10)
+
+	file := path.Join(conf.Dir, "1.sst")
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	footer := data[len(data)-FooterSize:]
+	binary.LittleEndian.PutUint32(footer[52:], CurrentFormatVersion+1)
+	binary.LittleEndian.PutUint32(footer[footerCRCOffset:], utils.CompressedCheckSum(footer[:footerCRCOffset]))
+	assert.NoError(t, os.WriteFile(file, data, 0644))
+
+	r, err := NewSStReader("1.sst", conf)
+	assert.NoError(t, err)
+	err = r.ReadFooter()
+
+	var verErr *ErrUnsupportedVersion
+	assert.True(t, errors.As(err, &verErr))
+	assert.Equal(t, CurrentFormatVersion+1, verErr.Version)
+}
+
+func TestReadFooterTruncated(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	writeTestTable(t, conf, "1.sst", 10)
+
+	file := path.Join(conf.Dir, "1.sst")
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Truncate(file, info.Size()-10))
+
+	r, err := NewSStReader("1.sst", conf)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, r.ReadFooter(), ErrBadMagic)
+}
+
+func TestReadFooterLegacy(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	writeTestTable(t, conf, "1.sst", 50)
+
+	file := path.Join(conf.Dir, "1.sst")
+	data, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	f, err := DecodeFooter(data)
+	assert.NoError(t, err)
+
+	legacy := make([]byte, conf.SstFooterSize)
+	n := binary.PutUvarint(legacy[0:], f.Filter.Offset)
+	n += binary.PutUvarint(legacy[n:], f.Filter.Size)
+	n += binary.PutUvarint(legacy[n:], f.Index.Offset)
+	binary.PutUvarint(legacy[n:], f.Index.Size)
+	data = append(data[:f.Index.Offset+f.Index.Size], legacy...)
+	assert.NoError(t, os.WriteFile(file, data, 0644))
+
+	r, err := NewSStReader("1.sst", conf)
+	assert.NoError(t, err)
+	assert.NoError(t, r.ReadFooter())
+	assert.Equal(t, FormatLegacy, r.Footer.Version)
+
+	index, err := r.ReadIndex()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, index)
+}
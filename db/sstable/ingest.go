@@ -0,0 +1,279 @@
+package sstable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	ErrKeyOrder      = errors.New("keys must be added in strictly increasing order")
+	ErrEmptySstFile  = errors.New("sst file has no entries")
+	ErrIngestOverlap = errors.New("ingested files overlap")
+)
+
+// SstFileWriter builds an SST outside of the tree, to be added later with
+// IngestExternalFiles. Keys must be added in strictly increasing order.
+type SstFileWriter struct {
+	w       *SsWriter
+	prevKey []byte
+	count   int
+}
+
+func NewSstFileWriter(file string, conf *config.Config) (*SstFileWriter, error) {
+	c := *conf
+	c.Dir = filepath.Dir(file)
+	w, err := NewSStWriter(filepath.Base(file), &c)
+	if err != nil {
+		return nil, err
+	}
+	return &SstFileWriter{w: w}, nil
+}
+
+func (w *SstFileWriter) Put(key, value []byte) error {
+	return w.add(key, value, KindValue)
+}
+
+func (w *SstFileWriter) Delete(key []byte) error {
+	return w.add(key, nil, KindDeletion)
+}
+
+func (w *SstFileWriter) add(key, value []byte, kind ValueKind) error {
+	if w.count > 0 && w.w.conf.KeyComparator().Compare(key, w.prevKey) <= 0 {
+		return fmt.Errorf("%w: %q after %q", ErrKeyOrder, key, w.prevKey)
+	}
+	w.prevKey = append(w.prevKey[:0], key...)
+	w.w.AppendEntry(w.prevKey, append([]byte(nil), value...), kind, 0)
+	w.count++
+	return nil
+}
+
+// Finish writes the index, filter and footer and closes the file.
+func (w *SstFileWriter) Finish() (*Properties, error) {
+	defer w.w.Close()
+	if w.count == 0 {
+		return nil, ErrEmptySstFile
+	}
+	if _, _, _, err := w.w.Finish(); err != nil {
+		return nil, errors.New("error in finish : " + err.Error())
+	}
+	return w.w.Properties(), nil
+}
+
+type externalFile struct {
+	path     string
+	smallest []byte
+	largest  []byte
+}
+
+// inspectExternalFile checks that every key of the SST at file is in strictly
+// increasing order and matches its properties.
+func inspectExternalFile(file string, conf *config.Config) (*externalFile, error) {
+	c := *conf
+	c.Dir = filepath.Dir(file)
+	r, err := NewSStReader(filepath.Base(file), &c)
+	if err != nil {
+		return nil, err
+	}
+	props, err := r.ReadProperties()
+	if err != nil {
+		r.Close()
+		return nil, errors.New("error in read properties : " + err.Error())
+	}
+	filter, err := r.ReadFilter()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	index, err := r.ReadIndex()
+	r.Close()
+	if err != nil {
+		return nil, err
+	}
+	if props.NumEntries == 0 || len(index) == 0 {
+		return nil, ErrEmptySstFile
+	}
+
+	node, err := NewNode(filter, index, 0, 0, "", 0, &c, filepath.Base(file))
+	if err != nil {
+		return nil, err
+	}
+	defer node.sr.Close()
+
+	var prevKey []byte
+	var count uint64
+	for rec := node.nextRecord(); rec != nil; rec = node.nextRecord() {
+		if count > 0 && conf.KeyComparator().Compare(rec.Key, prevKey) <= 0 {
+			return nil, fmt.Errorf("%w: %q after %q", ErrKeyOrder, rec.Key, prevKey)
+		}
+		if rec.Kind == KindBlobIndex {
+			return nil, errors.New("external sst must not reference blob files")
+		}
+		prevKey = rec.Key
+		count++
+	}
+	if err := node.cursor.err(); err != nil {
+		return nil, err
+	}
+	if count != props.NumEntries || !bytes.Equal(props.SmallestKey, index[0].Key) || !bytes.Equal(props.LargestKey, prevKey) {
+		return nil, errors.New("external sst content does not match its properties")
+	}
+	return &externalFile{path: file, smallest: props.SmallestKey, largest: props.LargestKey}, nil
+}
+
+// IngestExternalFiles adds SSTs built by SstFileWriter to the tree. The files
+// must not overlap each other. They share one new global sequence number and
+// each one is placed at the deepest level such that neither that level nor
+// any level above it overlaps the file. Keys still in a memtable are not
+// checked, so callers flush overlapping memtables first. The files are linked
+// or copied into the tree before it is locked; on error none of them is left
+// behind.
+func (t *LSMTree[K, V]) IngestExternalFiles(paths []string) (err error) {
+	t.closeMu.RLock()
+	defer t.closeMu.RUnlock()
+	if t.closed {
+		return utils.ErrClosed
+	}
+
+	files := make([]*externalFile, 0, len(paths))
+	for _, p := range paths {
+		f, err := inspectExternalFile(p, t.conf)
+		if err != nil {
+			return fmt.Errorf("ingest %s: %w", p, err)
+		}
+		files = append(files, f)
+	}
+	cmp := t.conf.KeyComparator()
+	sort.Slice(files, func(i, j int) bool { return cmp.Compare(files[i].smallest, files[j].smallest) < 0 })
+	for i := 1; i < len(files); i++ {
+		if cmp.Compare(files[i-1].largest, files[i].smallest) >= 0 {
+			return fmt.Errorf("%w: %s and %s", ErrIngestOverlap, files[i-1].path, files[i].path)
+		}
+	}
+
+	// the files are named like flushes; like the nodes of a trivial move,
+	// their level and SeqNo are set apart from the name
+	var added []*Node
+	var linked []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, node := range added {
+			node.sr.Close()
+		}
+		for _, file := range linked {
+			os.Remove(file)
+		}
+	}()
+	for _, f := range files {
+		file := utils.FormatName(0, t.NextSeqNo(0), "ingest")
+		if err := linkOrCopy(f.path, path.Join(t.conf.Dir, file)); err != nil {
+			return fmt.Errorf("ingest %s: %v", f.path, err)
+		}
+		linked = append(linked, path.Join(t.conf.Dir, file))
+
+		node, err := t.openNode(FileMeta{File: file, Extra: "ingest", Size: fileSize(f.path)})
+		if err != nil {
+			return fmt.Errorf("ingest %s: %v", f.path, err)
+		}
+		added = append(added, node)
+	}
+
+	t.blobMu.Lock()
+	defer t.blobMu.Unlock()
+	t.manifestMu.Lock()
+	defer t.manifestMu.Unlock()
+	t.mu.Lock()
+
+	seqNos := append([]int(nil), t.seqNo...)
+	globalSeq := t.lastSeq.Add(1)
+	for i, node := range added {
+		node.Level = t.ingestLevelLocked(files[i].smallest, files[i].largest)
+		t.seqNo[node.Level]++
+		node.SeqNo = t.seqNo[node.Level]
+		node.setGlobalSeq(globalSeq)
+	}
+	tree := t.cloneTreeLocked()
+	for _, node := range added {
+		tree[node.Level] = insertLevel(t.conf.KeyComparator(), tree[node.Level], node)
+	}
+	m := t.manifestLocked(tree)
+	t.mu.Unlock()
+
+	if err := t.install(tree, m); err != nil {
+		t.mu.Lock()
+		copy(t.seqNo, seqNos)
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// ingestLevelLocked returns the deepest level at which [smallest, largest]
+// overlaps no file of that level or of a level above it. The span a running
+// compaction writes counts as a file of its output level, since its outputs
+// are not installed yet.
+func (t *LSMTree[K, V]) ingestLevelLocked(smallest, largest []byte) int {
+	cmp := t.conf.KeyComparator()
+	overlaps := func(start, end []byte) bool {
+		return cmp.Compare(smallest, end) <= 0 && cmp.Compare(largest, start) >= 0
+	}
+	level := 0
+	for l, nodes := range t.tree {
+		for _, n := range nodes {
+			if overlaps(n.startKey, n.endKey) {
+				return level
+			}
+		}
+		for _, s := range t.running {
+			if s.level == l && overlaps(s.startKey, s.endKey) {
+				return level
+			}
+		}
+		level = l
+	}
+	return level
+}
+
+func fileSize(file string) int64 {
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,191 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeExternalFile(t *testing.T, conf *config.Config, file string, kvs [][2]string) string {
+	p := path.Join(t.TempDir(), file)
+	w, err := NewSstFileWriter(p, conf)
+	assert.NoError(t, err)
+	for _, kv := range kvs {
+		assert.NoError(t, w.Put([]byte(kv[0]), []byte(kv[1])))
+	}
+	_, err = w.Finish()
+	assert.NoError(t, err)
+	return p
+}
+
+func TestSstFileWriterKeyOrder(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	w, err := NewSstFileWriter(path.Join(conf.Dir, "ext.sst"), conf)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Put([]byte("b"), []byte("1")))
+	assert.ErrorIs(t, w.Put([]byte("b"), []byte("2")), ErrKeyOrder)
+	assert.ErrorIs(t, w.Put([]byte("a"), []byte("2")), ErrKeyOrder)
+	props, err := w.Finish()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), props.NumEntries)
+}
+
+func TestIngestExternalFiles(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+
+	kvs := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		kvs[fmt.Sprintf("key%02d", i)] = "old"
+	}
+	flushTestMemTable(t, lsmt, conf, kvs)
+
+	var a [][2]string
+	for i := 0; i < 10; i++ {
+		a = append(a, [2]string{fmt.Sprintf("a%02d", i), fmt.Sprintf("a%d", i)})
+	}
+	fileA := writeExternalFile(t, conf, "a.sst", a)
+	fileB := writeExternalFile(t, conf, "b.sst", [][2]string{{"key05", "new"}, {"key50", "new"}})
+	fileC := writeExternalFile(t, conf, "c.sst", [][2]string{{"a05", "x"}})
+
+	assert.ErrorIs(t, lsmt.IngestExternalFiles([]string{fileA, fileC}), ErrIngestOverlap)
+	assert.NoError(t, lsmt.IngestExternalFiles([]string{fileB, fileA}))
+
+	assert.Len(t, lsmt.tree[0], 2, "overlapping file goes to level 0")
+	assert.Len(t, lsmt.tree[conf.MaxLevel-1], 1, "disjoint file goes to the last level")
+	assert.Equal(t, []byte("a5"), getValue(t, lsmt, "a05"))
+	assert.Equal(t, []byte("new"), getValue(t, lsmt, "key05"))
+	assert.Equal(t, []byte("new"), getValue(t, lsmt, "key50"))
+	assert.Equal(t, []byte("old"), getValue(t, lsmt, "key06"))
+
+	seq := lsmt.LastSequence()
+	record, err := lsmt.lookup([]byte("a03"))
+	assert.NoError(t, err)
+	assert.Equal(t, seq, record.Seq)
+
+	restored, err := RestoreLSMTree[string, string](conf)
+	assert.NoError(t, err)
+	assert.Equal(t, seq, restored.LastSequence())
+	assert.Len(t, restored.tree[0], 2)
+	assert.Equal(t, []byte("a5"), getValue(t, restored, "a05"))
+	assert.Equal(t, []byte("new"), getValue(t, restored, "key05"))
+	assert.Equal(t, []byte("old"), getValue(t, restored, "key06"))
+	record, err = restored.lookup([]byte("key50"))
+	assert.NoError(t, err)
+	assert.Equal(t, seq, record.Seq)
+}
+
+func TestIngestDuringCompaction(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+
+	// a compaction writing [a, z] into level 3 has taken its inputs, which
+	// leaves no installed file for the ingested one to overlap
+	lsmt.mu.Lock()
+	span := &compactionSpan{level: 3, startKey: []byte("a"), endKey: []byte("z")}
+	lsmt.running = append(lsmt.running, span)
+	lsmt.mu.Unlock()
+
+	file := writeExternalFile(t, conf, "m.sst", [][2]string{{"m", "2"}})
+	assert.NoError(t, lsmt.IngestExternalFiles([]string{file}))
+	assert.Len(t, lsmt.tree[2], 1, "ingested above the compaction output")
+
+	lsmt.mu.Lock()
+	lsmt.finishCompactionLocked(span)
+	lsmt.mu.Unlock()
+	assert.Empty(t, lsmt.running)
+}
+
+func TestIngestExternalFilesFailure(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	fileA := writeExternalFile(t, conf, "a.sst", [][2]string{{"a", "1"}})
+	fileB := writeExternalFile(t, conf, "b.sst", [][2]string{{"b", "2"}})
+
+	// the name of the second file is taken, so it cannot be linked
+	seqNo := lsmt.seqNo[0]
+	first := path.Join(conf.Dir, utils.FormatName(0, seqNo+1, "ingest"))
+	taken := path.Join(conf.Dir, utils.FormatName(0, seqNo+2, "ingest"))
+	assert.NoError(t, os.WriteFile(taken, nil, 0644))
+
+	assert.Error(t, lsmt.IngestExternalFiles([]string{fileA, fileB}))
+	assert.NoFileExists(t, first, "linked file removed")
+	for _, nodes := range lsmt.tree {
+		assert.Empty(t, nodes)
+	}
+
+	assert.NoError(t, lsmt.Close())
+	assert.ErrorIs(t, lsmt.IngestExternalFiles([]string{fileA}), utils.ErrClosed)
+}
+
+func TestManifestCorrupted(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	m := &Manifest{LastSeq: 7, SeqNos: []int{1, 2}, Files: []FileMeta{{File: "0_1_x.sst", SeqNo: 1, Extra: "x", Size: 10}}}
+	data, err := m.encode(conf)
+	assert.NoError(t, err)
+
+	got, err := decodeManifest(data)
+	assert.NoError(t, err)
+	assert.Equal(t, m, got)
+
+	data[0] ^= 0xff
+	_, err = decodeManifest(data)
+	assert.ErrorIs(t, err, ErrManifestCorrupted)
+}
+
+func TestManifestKeyEncoding(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	flushTestMemTable(t, lsmt, conf, map[string]string{"key": "v"})
+	assert.NoError(t, lsmt.Close())
+
+	m, err := ReadManifest(conf)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(keyEncoding), m.KeyEncoding)
+
+	// a MANIFEST from before the codecs
+	m.KeyEncoding = 0
+	assert.NoError(t, writeManifest(conf, m))
+	_, err = RestoreLSMTree[int, string](conf)
+	assert.ErrorIs(t, err, ErrKeyEncoding)
+	_, err = RestoreLSMTree[string, int](conf)
+	assert.ErrorIs(t, err, ErrKeyEncoding)
+	restored, err := RestoreLSMTree[string, string](conf)
+	assert.NoError(t, err, "strings were stored the same way")
+	assert.Equal(t, []byte("v"), getValue(t, restored, "key"))
+	assert.NoError(t, restored.Close())
+
+	m.KeyEncoding = keyEncoding + 1
+	assert.NoError(t, writeManifest(conf, m))
+	_, err = RestoreLSMTree[string, string](conf)
+	assert.ErrorIs(t, err, ErrKeyEncoding)
+}
+
+func TestRestoreFailureClosesTree(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	for i := 0; i < 3; i++ {
+		flushTestMemTable(t, lsmt, conf, map[string]string{fmt.Sprintf("key%d", i): "v"})
+	}
+	assert.NoError(t, lsmt.Close())
+
+	m, err := ReadManifest(conf)
+	assert.NoError(t, err)
+	assert.Len(t, m.Files, 3)
+	assert.NoError(t, os.Remove(path.Join(conf.Dir, m.Files[2].File)))
+
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	_, err = RestoreLSMTree[string, string](conf)
+	assert.Error(t, err)
+	after, err := os.ReadDir("/proc/self/fd")
+	assert.NoError(t, err)
+	assert.Len(t, after, len(fds), "files opened before the failure are closed")
+}
@@ -0,0 +1,298 @@
+package sstable
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// recordSource is a sorted stream of records. nextRecord returns nil at the
+// end of the stream or on an error, reported by err.
+type recordSource interface {
+	nextRecord() *Record
+	err() error
+}
+
+// tableIterator walks the records of a node in key order, restricted to
+// [lo, hi) when the bounds are set. Every iterator has its own cursor, so
+// several of them can scan one node concurrently.
+type tableIterator struct {
+	node    *Node
+	lo      []byte
+	hi      []byte
+	block   int
+	entries []*Index
+	buf     *bytes.Buffer
+	offset  uint64
+	prevKey []byte
+	done    bool
+	failed  error
+}
+
+func (n *Node) newIterator(lo, hi []byte) *tableIterator {
+	it := &tableIterator{node: n, lo: lo, hi: hi, block: 1}
+	if lo != nil {
+		// entries before the first separator >= lo only hold smaller keys
+		it.block = searchIndex(n.cmp, n.index[1:], lo) + 1
+	}
+	return it
+}
+
+func (it *tableIterator) nextRecord() *Record {
+	for !it.done {
+		if it.buf == nil && !it.loadBlock() {
+			it.done = true
+			return nil
+		}
+
+		key, value, err := ReadRecord(it.prevKey, it.buf)
+		if err == io.EOF {
+			it.buf = nil
+			continue
+		}
+		if err != nil {
+			return it.fail(utils.NewCorruptionError(it.node.file, it.offset, errors.New("read records error : "+err.Error())))
+		}
+		it.prevKey = key
+
+		if it.lo != nil && it.node.cmp.Compare(key, it.lo) < 0 {
+			continue
+		}
+		if it.hi != nil && it.node.cmp.Compare(key, it.hi) >= 0 {
+			it.done = true
+			return nil
+		}
+		rec, err := it.node.decodeRecord(key, value)
+		if err != nil {
+			return it.fail(utils.NewCorruptionError(it.node.file, it.offset, errors.New("decode record error : "+err.Error())))
+		}
+		return rec
+	}
+	return nil
+}
+
+func (it *tableIterator) err() error { return it.failed }
+
+func (it *tableIterator) fail(err error) *Record {
+	it.failed = err
+	it.done = true
+	return nil
+}
+
+func (it *tableIterator) loadBlock() bool {
+	for len(it.entries) == 0 {
+		if it.block > len(it.node.index)-1 {
+			return false
+		}
+		entries, err := it.node.blockIndex(it.block)
+		if err != nil {
+			it.fail(errors.New("error in read index partition : " + err.Error()))
+			return false
+		}
+		it.block++
+		for it.lo != nil && len(entries) > 0 && it.node.cmp.Compare(entries[0].Key, it.lo) < 0 {
+			entries = entries[1:]
+		}
+		it.entries = entries
+	}
+
+	index := it.entries[0]
+	it.entries = it.entries[1:]
+	data, err := it.node.sr.ReadBlock(index.PrevOffset, index.PrevSize)
+	if err != nil {
+		it.fail(fmt.Errorf("error in readBlock : %w", err))
+		return false
+	}
+
+	record, _, err := DecodeBlock(data)
+	if err != nil {
+		it.fail(utils.NewCorruptionError(it.node.file, index.PrevOffset, err))
+		return false
+	}
+	it.buf = bytes.NewBuffer(record)
+	it.offset = index.PrevOffset
+	it.prevKey = make([]byte, 0)
+	return true
+}
+
+type mergeItem struct {
+	rec *Record
+	src int
+}
+
+// mergeHeap orders records by key and, for equal keys, newest first: the
+// higher sequence number wins, then the source with the higher index.
+type mergeHeap struct {
+	items []mergeItem
+	cmp   utils.BytesComparator
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if c := h.cmp.Compare(a.rec.Key, b.rec.Key); c != 0 {
+		return c < 0
+	}
+	if a.rec.Seq != b.rec.Seq {
+		return a.rec.Seq > b.rec.Seq
+	}
+	return a.src > b.src
+}
+
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Push(x any) { h.items = append(h.items, x.(mergeItem)) }
+
+func (h *mergeHeap) Pop() any {
+	item := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return item
+}
+
+// mergeIterator merges sorted sources into one sorted stream holding only the
+// newest record of every key. Sources are ordered oldest first, so a source
+// from an upper level, or a newer level 0 file, comes after the ones it
+// shadows.
+type mergeIterator struct {
+	sources []recordSource
+	h       mergeHeap
+	failed  error
+}
+
+func newMergeIterator(cmp utils.BytesComparator, sources []recordSource) *mergeIterator {
+	m := &mergeIterator{sources: sources, h: mergeHeap{cmp: cmp}}
+	for i, s := range sources {
+		if rec := s.nextRecord(); rec != nil {
+			m.h.items = append(m.h.items, mergeItem{rec: rec, src: i})
+		} else if err := s.err(); err != nil {
+			m.failed = err
+		}
+	}
+	heap.Init(&m.h)
+	return m
+}
+
+func (m *mergeIterator) err() error { return m.failed }
+
+func (m *mergeIterator) nextRecord() *Record {
+	if len(m.h.items) == 0 || m.failed != nil {
+		return nil
+	}
+	top := m.h.items[0].rec
+	m.advance()
+	for len(m.h.items) > 0 && bytes.Equal(m.h.items[0].rec.Key, top.Key) {
+		m.advance()
+	}
+	if m.failed != nil {
+		return nil
+	}
+	return top
+}
+
+// advance replaces the head of the heap with the next record of its source.
+func (m *mergeIterator) advance() {
+	src := m.sources[m.h.items[0].src]
+	if rec := src.nextRecord(); rec != nil {
+		m.h.items[0].rec = rec
+		heap.Fix(&m.h, 0)
+	} else {
+		if err := src.err(); err != nil && m.failed == nil {
+			m.failed = err
+		}
+		heap.Pop(&m.h)
+	}
+}
+
+// Iterator scans the live keys of an LSMTree in order. Deleted keys, and keys
+// covered by a range tombstone, are skipped and values stored in blob files
+// are resolved.
+type Iterator struct {
+	resolve   func(*Record) ([]byte, error)
+	merge     *mergeIterator
+	rangeDels rangeDelSet
+	cmp       utils.BytesComparator
+	// nodes are referenced until the iterator is exhausted or closed.
+	nodes  []*Node
+	closed bool
+	key    []byte
+	value  []byte
+	err    error
+}
+
+// NewIterator returns an iterator over the keys in [start, end); nil bounds
+// are open. The iterator sees the nodes present when it is created and must
+// be done before the tree is closed; one left before its end must be closed.
+func (t *LSMTree[K, V]) NewIterator(start, end []byte) *Iterator {
+	if t.isClosed() {
+		return &Iterator{err: utils.ErrClosed}
+	}
+	tree := t.acquireTree()
+	defer releaseTree(tree)
+
+	cmp := t.conf.KeyComparator()
+	var sources []recordSource
+	var dels []RangeTombstone
+	var nodes []*Node
+	for level := len(tree) - 1; level >= 0; level-- {
+		for _, n := range tree[level] {
+			if (end != nil && cmp.Compare(n.startKey, end) >= 0) || (start != nil && cmp.Compare(n.endKey, start) < 0) {
+				continue
+			}
+			n.ref()
+			nodes = append(nodes, n)
+			sources = append(sources, n.newIterator(start, end))
+			dels = append(dels, n.rangeDels.clip(cmp, start, end)...)
+		}
+	}
+	return &Iterator{resolve: t.resolve, merge: newMergeIterator(cmp, sources), rangeDels: newRangeDelSet(cmp, dels), cmp: cmp, nodes: nodes}
+}
+
+// Next advances to the next live key and reports whether there is one.
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.closed {
+		return false
+	}
+	for rec := it.merge.nextRecord(); rec != nil; rec = it.merge.nextRecord() {
+		if rec.Kind == KindDeletion || it.rangeDels.covers(it.cmp, rec) {
+			continue
+		}
+		value, err := it.resolve(rec)
+		if err != nil {
+			it.err = fmt.Errorf("resolve value of %q: %v", rec.Key, err)
+			it.release()
+			return false
+		}
+		it.key, it.value = rec.Key, value
+		return true
+	}
+	it.key, it.value = nil, nil
+	it.err = it.merge.err()
+	it.release()
+	return false
+}
+
+// Close releases the files the iterator reads; Next returns false after it.
+func (it *Iterator) Close() error {
+	it.release()
+	it.closed = true
+	it.key, it.value = nil, nil
+	return nil
+}
+
+func (it *Iterator) release() {
+	for _, n := range it.nodes {
+		n.unref()
+	}
+	it.nodes = nil
+}
+
+func (it *Iterator) Key() []byte { return it.key }
+
+func (it *Iterator) Value() []byte { return it.value }
+
+func (it *Iterator) Err() error { return it.err }
@@ -0,0 +1,174 @@
+package sstable
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/peterouob/gocloud/bptree"
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func addTestNode(t *testing.T, lsmt *LSMTree[string, string], level int, records []Record) {
+	seqNo := lsmt.NextSeqNo(level)
+	file := utils.FormatName(level, seqNo, "test")
+	w, err := NewSStWriter(file, lsmt.conf)
+	assert.NoError(t, err)
+	for _, rec := range records {
+		w.AppendEntry(rec.Key, rec.Value, rec.Kind, rec.Seq)
+	}
+	size, filter, index, err := w.Finish()
+	assert.NoError(t, err)
+	w.Close()
+
+	node, err := lsmt.newNode(filter, index, level, seqNo, "test", size, file)
+	assert.NoError(t, err)
+	lsmt.insertNode(node)
+}
+
+func scanTree(t *testing.T, lsmt *LSMTree[string, string], start, end []byte) [][2]string {
+	var got [][2]string
+	it := lsmt.NewIterator(start, end)
+	for it.Next() {
+		got = append(got, [2]string{string(it.Key()), string(it.Value())})
+	}
+	assert.NoError(t, it.Err())
+	return got
+}
+
+func expectScan(ref map[string]string, start, end string) [][2]string {
+	var want [][2]string
+	for k, v := range ref {
+		if (start == "" || k >= start) && (end == "" || k < end) {
+			want = append(want, [2]string{k, v})
+		}
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i][0] < want[j][0] })
+	return want
+}
+
+func TestMergeIteratorMatchesReference(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(seed))
+			conf := config.NewConfig(t.TempDir())
+			conf.SstDataBlockSize = 128
+			lsmt := NewLSMTree[string, string](conf)
+
+			// deeper levels are written first so upper levels hold newer data
+			ref := make(map[string]string)
+			levels := []int{3, 2, 1, 0, 0, 0}
+			for _, level := range levels {
+				keys := make(map[string]bool)
+				for i := 0; i < 50+rnd.Intn(100); i++ {
+					keys[fmt.Sprintf("key%03d", rnd.Intn(300))] = true
+				}
+				sorted := make([]string, 0, len(keys))
+				for k := range keys {
+					sorted = append(sorted, k)
+				}
+				sort.Strings(sorted)
+
+				records := make([]Record, 0, len(sorted))
+				for _, k := range sorted {
+					rec := Record{Key: []byte(k), Value: []byte(fmt.Sprintf("%s@%d", k, level)), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)}
+					if rnd.Intn(5) == 0 {
+						rec.Kind, rec.Value = KindDeletion, []byte{}
+						delete(ref, k)
+					} else {
+						ref[k] = string(rec.Value)
+					}
+					records = append(records, rec)
+				}
+				addTestNode(t, lsmt, level, records)
+			}
+
+			assert.Equal(t, expectScan(ref, "", ""), scanTree(t, lsmt, nil, nil))
+			for i := 0; i < 20; i++ {
+				start := fmt.Sprintf("key%03d", rnd.Intn(300))
+				end := fmt.Sprintf("key%03d", rnd.Intn(300))
+				assert.Equal(t, expectScan(ref, start, end), scanTree(t, lsmt, []byte(start), []byte(end)))
+			}
+			for i := 0; i < 300; i++ {
+				k := fmt.Sprintf("key%03d", i)
+				if v, ok := ref[k]; ok {
+					assert.Equal(t, v, string(getValue(t, lsmt, k)))
+				} else {
+					assert.Nil(t, getValue(t, lsmt, k))
+				}
+			}
+
+			assert.NoError(t, lsmt.compaction(0))
+			assert.Equal(t, expectScan(ref, "", ""), scanTree(t, lsmt, nil, nil))
+		})
+	}
+}
+
+func TestMergeIteratorNewestWins(t *testing.T) {
+	older := &sliceSource{records: []*Record{{Key: []byte("a"), Seq: 1}, {Key: []byte("b"), Seq: 5}}}
+	newer := &sliceSource{records: []*Record{{Key: []byte("a"), Seq: 1, Value: []byte("newer source")}, {Key: []byte("b"), Seq: 2}}}
+
+	m := newMergeIterator(utils.BytewiseComparator, []recordSource{older, newer})
+	rec := m.nextRecord()
+	assert.Equal(t, "newer source", string(rec.Value), "equal sequence numbers prefer the later source")
+	rec = m.nextRecord()
+	assert.Equal(t, uint64(5), rec.Seq, "higher sequence number wins")
+	assert.Nil(t, m.nextRecord())
+}
+
+type sliceSource struct {
+	records []*Record
+}
+
+func (s *sliceSource) nextRecord() *Record {
+	if len(s.records) == 0 {
+		return nil
+	}
+	rec := s.records[0]
+	s.records = s.records[1:]
+	return rec
+}
+
+func (s *sliceSource) err() error { return nil }
+
+func TestIteratorKeepsRemovedNode(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	addTestNode(t, lsmt, 1, []Record{{Key: []byte("a"), Value: []byte("1"), Kind: KindValue, Seq: 1}, {Key: []byte("b"), Value: []byte("2"), Kind: KindValue, Seq: 2}})
+	node := lsmt.tree[1][0]
+	file := node.sr.fd.Name()
+
+	it := lsmt.NewIterator(nil, nil)
+	assert.NoError(t, lsmt.apply(nil, []*Node{node}))
+	assert.FileExists(t, file, "read by the iterator")
+	assert.True(t, it.Next())
+	assert.Equal(t, "a", string(it.Key()))
+	assert.NoError(t, it.Close())
+	assert.False(t, it.Next())
+	assert.NoFileExists(t, file, "destroyed by the last release")
+	assert.Empty(t, scanTree(t, lsmt, nil, nil))
+}
+
+func TestBulkLoadFromScan(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	ref := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		ref[fmt.Sprintf("key%03d", i)] = fmt.Sprintf("value%d", i)
+	}
+	flushTestMemTable(t, lsmt, conf, ref)
+
+	index, err := bptree.BulkLoad[string, []byte](16, 0.75, bptree.BytesLoader(lsmt.NewIterator(nil, nil)))
+	assert.NoError(t, err)
+	assert.NoError(t, index.CheckInvariants())
+	assert.Equal(t, len(ref), index.Len())
+	var got [][2]string
+	index.Ascend(func(k string, v []byte) bool {
+		got = append(got, [2]string{k, string(v)})
+		return true
+	})
+	assert.Equal(t, expectScan(ref, "", ""), got)
+}
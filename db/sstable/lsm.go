@@ -7,12 +7,16 @@ import (
 	"github.com/peterouob/gocloud/db/config"
 	"github.com/peterouob/gocloud/db/memtable"
 	"github.com/peterouob/gocloud/db/utils"
+	"log"
 	"math"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 type LSMTreeInterface[K any, V any] interface {
-	Get(K) []byte
+	Get(K) ([]byte, bool, error)
 	FlushRecord(*memtable.MemTable[K, V], string) error
 	PickCompactionNode(int) []*Node
 	NextSeqNo(int) int
@@ -24,11 +28,51 @@ type LSMTree[K any, V any] struct {
 	seqNo       []int
 	compactChan chan int
 	stopChan    chan struct{}
+	lastSeq     atomic.Uint64
+	cache       *BlockCache
+	blobs       *BlobStore
+	blobMu      sync.Mutex // serializes flushes with blob GC
+	stats       CompactionStats
+	limiter     *RateLimiter
+	// closeMu is held shared by reads and compactions and exclusively by
+	// Close, which waits for them before releasing the files.
+	closeMu    sync.RWMutex
+	closed     bool
+	keyCodec   utils.KeyCodec[K]
+	valueCodec utils.ValueCodec[V]
+	// running holds the key spans of the compactions in flight, guarded by mu.
+	running []*compactionSpan
+	// manifestMu serializes the changes to the tree, and the picks of
+	// compactions, with the MANIFEST writes, which are done without mu.
+	manifestMu sync.Mutex
+}
+
+// compactionSpan is the key range a running compaction writes to its output
+// level.
+type compactionSpan struct {
+	level    int
+	startKey []byte
+	endKey   []byte
+}
+
+// CompactionStats counts the work done by compactions. Trivial moves, which
+// only change the level of a file, are not counted as compactions.
+type CompactionStats struct {
+	Compactions      uint64
+	InputFiles       uint64
+	OutputFiles      uint64
+	BytesRead        int64
+	BytesWritten     int64
+	TrivialMoves     uint64
+	TrivialMoveBytes int64
 }
 
 var _ LSMTreeInterface[any, any] = (*LSMTree[any, any])(nil)
 
 func NewLSMTree[K any, V any](conf *config.Config) *LSMTree[K, V] {
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		panic(errors.New("error in call os.MkdirAll: " + err.Error()))
+	}
 	compactionChan := make(chan int, 100)
 	levelTree := make([][]*Node, conf.MaxLevel)
 
@@ -43,29 +87,165 @@ func NewLSMTree[K any, V any](conf *config.Config) *LSMTree[K, V] {
 		seqNo:       seqNos,
 		compactChan: compactionChan,
 		stopChan:    make(chan struct{}),
+		cache:       NewBlockCache(conf.BlockCacheSize),
+		blobs:       NewBlobStore(conf),
+		keyCodec:    utils.DefaultKeyCodec[K](),
+		valueCodec:  utils.DefaultValueCodec[V](),
+	}
+	if conf.RateLimitBytesPerSec > 0 {
+		lsmt.limiter = NewRateLimiter(conf.RateLimitBytesPerSec)
+		if conf.RateLimitAutoTune {
+			lsmt.limiter.AutoTune(8*conf.RateLimitBytesPerSec, int64(conf.SstSize))
+		}
 	}
 
 	lsmt.CheckCompaction()
 	return lsmt
 }
 
-func (t *LSMTree[K, V]) Get(key K) []byte {
+// SetCodecs replaces the default codecs turning the keys and values into the
+// bytes of the SSTs. It must be called before the tree is used, and the key
+// codec must order keys as the comparator of the flushed memtables.
+func (t *LSMTree[K, V]) SetCodecs(keys utils.KeyCodec[K], values utils.ValueCodec[V]) {
+	t.keyCodec, t.valueCodec = keys, values
+}
+
+// Codecs returns the codecs of the keys and values of the tree.
+func (t *LSMTree[K, V]) Codecs() (utils.KeyCodec[K], utils.ValueCodec[V]) {
+	return t.keyCodec, t.valueCodec
+}
 
-	for _, nodes := range t.tree {
+// Get returns the value of key and whether it was found. Corrupted data is
+// reported with an error matching utils.ErrCorruption, and reads after Close
+// fail with utils.ErrClosed.
+func (t *LSMTree[K, V]) Get(key K) ([]byte, bool, error) {
+	bkey, err := t.keyCodec.EncodeKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	t.closeMu.RLock()
+	defer t.closeMu.RUnlock()
+	if t.closed {
+		return nil, false, utils.ErrClosed
+	}
+
+	record, err := t.lookup(bkey)
+	if err != nil {
+		return nil, false, fmt.Errorf("get value of %q: %w", bkey, err)
+	}
+	if record == nil || record.Kind == KindDeletion {
+		return nil, false, nil
+	}
+	value, err := t.resolve(record)
+	if err != nil {
+		return nil, false, fmt.Errorf("get value of %q: %w", bkey, err)
+	}
+	return value, true, nil
+}
+
+// Close stops the background compactions and closes the files of the tree.
+// Reads after Close fail with utils.ErrClosed.
+func (t *LSMTree[K, V]) Close() error {
+	t.closeMu.Lock()
+	defer t.closeMu.Unlock()
+	if t.closed {
+		return utils.ErrClosed
+	}
+	t.closed = true
+	close(t.stopChan)
+
+	t.mu.Lock()
+	tree := t.tree
+	t.mu.Unlock()
+
+	err := t.blobs.Close()
+	for _, nodes := range tree {
+		for _, n := range nodes {
+			if cerr := n.sr.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+func (t *LSMTree[K, V]) isClosed() bool {
+	t.closeMu.RLock()
+	defer t.closeMu.RUnlock()
+	return t.closed
+}
+
+// lookup returns the newest record stored for key, or nil. A record covered
+// by a newer range tombstone is returned as a deletion.
+func (t *LSMTree[K, V]) lookup(key []byte) (*Record, error) {
+	tree := t.acquireTree()
+	defer releaseTree(tree)
+
+	var deletedAt uint64
+	for _, nodes := range tree {
 		for i := len(nodes) - 1; i >= 0; i-- {
-			value, err := nodes[i].Get(utils.FormatKeyV(key))
-			if value != nil && err == nil {
-				return value
-			} else if err != nil {
-				panic(fmt.Errorf("get value:%s from key error:%v", string(value), err))
-				return nil
+			if seq := nodes[i].rangeDels.maxSeq(t.conf.KeyComparator(), key); seq > deletedAt {
+				deletedAt = seq
+			}
+			record, err := nodes[i].lookup(key)
+			if err != nil {
+				return nil, err
+			}
+			if record != nil {
+				if record.Seq < deletedAt {
+					return &Record{Key: key, Kind: KindDeletion, Seq: deletedAt}, nil
+				}
+				return record, nil
 			}
 		}
 	}
-	return nil
+	return nil, nil
+}
+
+// acquireTree returns the current tree with a reference taken on each of its
+// nodes, so that none is destroyed before releaseTree.
+func (t *LSMTree[K, V]) acquireTree() [][]*Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, nodes := range t.tree {
+		for _, n := range nodes {
+			n.ref()
+		}
+	}
+	return t.tree
+}
+
+func releaseTree(tree [][]*Node) {
+	for _, nodes := range tree {
+		for _, n := range nodes {
+			n.unref()
+		}
+	}
+}
+
+// resolve returns the user value of record, reading it from its blob file
+// when the SST only holds a reference.
+func (t *LSMTree[K, V]) resolve(record *Record) ([]byte, error) {
+	if record.Kind != KindBlobIndex {
+		return record.Value, nil
+	}
+	ref, err := DecodeBlobRef(record.Value)
+	if err != nil {
+		return nil, err
+	}
+	return t.blobs.Read(ref)
 }
 
 func (t *LSMTree[K, V]) FlushRecord(memtable *memtable.MemTable[K, V], extra string) error {
+	t.closeMu.RLock()
+	defer t.closeMu.RUnlock()
+	if t.closed {
+		return utils.ErrClosed
+	}
+	t.blobMu.Lock()
+	defer t.blobMu.Unlock()
+
 	level := 0
 	seqNo := t.NextSeqNo(level)
 
@@ -75,95 +255,157 @@ func (t *LSMTree[K, V]) FlushRecord(memtable *memtable.MemTable[K, V], extra str
 		return errors.New("error in new ssWriter : " + err.Error())
 	}
 	defer w.Close()
-	tree := memtable.MemTree
+	w.SetRateLimiter(t.limiter, IOHigh)
+
+	// the tombstones are older than every key of the memtable: the keys they
+	// deleted are already marked deleted in the rep
+	if dels := memtable.RangeDeletions(); len(dels) > 0 {
+		seq := t.lastSeq.Add(1)
+		for _, d := range dels {
+			start, err := t.keyCodec.EncodeKey(d.Start)
+			if err != nil {
+				return err
+			}
+			end, err := t.keyCodec.EncodeKey(d.End)
+			if err != nil {
+				return err
+			}
+			w.AddRangeTombstone(start, end, seq)
+		}
+	}
 
-	var keys []K
-	count := 0
-	for {
-		node, found := tree.Next(keys)
-		if !found {
-			break
+	var blob *BlobWriter
+	for it := memtable.Rep().NewIterator(); it.Next(); {
+		bkey, err := t.keyCodec.EncodeKey(it.Key())
+		if err != nil {
+			return err
+		}
+		bvalue, err := t.valueCodec.EncodeValue(it.Value())
+		if err != nil {
+			return err
+		}
+		kind := KindValue
+		if it.Deleted() {
+			kind = KindDeletion
+		} else if t.conf.BlobThreshold > 0 && len(bvalue) >= t.conf.BlobThreshold {
+			if blob == nil {
+				if blob, err = t.blobs.NewWriter(); err != nil {
+					return err
+				}
+			}
+			ref, err := blob.Add(bkey, bvalue)
+			if err != nil {
+				return errors.New("error in write blob : " + err.Error())
+			}
+			kind, bvalue = KindBlobIndex, ref.Encode()
+		}
+		w.AppendEntry(bkey, bvalue, kind, t.lastSeq.Add(1))
+	}
+	if blob != nil {
+		if err := blob.Close(); err != nil {
+			return errors.New("error in close blob : " + err.Error())
 		}
-		bkey, bvalue := utils.FormatKeyValue(node.Key, node.Value)
-		w.Append(bkey, bvalue)
-		keys = append(keys, node.Key)
-		count++
 	}
 	size, filter, index, err := w.Finish()
 	if err != nil {
 		return errors.New("error in finish : " + err.Error())
 	}
-	node, err := NewNode(filter, index, level, seqNo, extra, size, t.conf, file)
+	node, err := t.newNode(filter, index, level, seqNo, extra, size, file)
 	if err != nil {
 		return errors.New("error in new Node after append ssWriter: " + err.Error())
 	}
-	t.insertNode(node)
+	if err := t.apply([]*Node{node}, nil); err != nil {
+		return err
+	}
+	t.limiter.Tune(t.CompactionDebt())
 	t.compactChan <- level
 	return nil
 }
 
+func (t *LSMTree[K, V]) newNode(filter map[uint64][]byte, index []*Index, level, seqNo int, extra string, size int64, file string) (*Node, error) {
+	node, err := NewNode(filter, index, level, seqNo, extra, size, t.conf, file)
+	if err != nil {
+		return nil, err
+	}
+	node.cache = t.cache
+	return node, nil
+}
+
 func (t *LSMTree[K, V]) insertNode(node *Node) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	level := node.Level
-	length := len(t.tree[level])
-
-	if length == 0 {
-		t.tree[level] = []*Node{node}
-		return
-	}
+	tree := t.cloneTreeLocked()
+	tree[node.Level] = insertLevel(t.conf.KeyComparator(), tree[node.Level], node)
+	t.tree = tree
+}
 
-	if level == 0 {
-		idx := length - 1
+// insertLevel adds node to the nodes of its level: level 0 stays ordered by
+// SeqNo, replacing a node with the same SeqNo.
+func insertLevel(cmp utils.BytesComparator, nodes []*Node, node *Node) []*Node {
+	if node.Level == 0 {
+		idx := len(nodes) - 1
 		for ; idx >= 0; idx-- {
-			if node.SeqNo > t.tree[level][idx].SeqNo {
+			if node.SeqNo > nodes[idx].SeqNo {
 				break
-			} else if node.SeqNo == t.tree[level][idx].SeqNo {
-				t.tree[level][idx] = node
-				return
+			} else if node.SeqNo == nodes[idx].SeqNo {
+				nodes[idx] = node
+				return nodes
 			}
 		}
-		if idx == -1 {
-			t.tree[level] = append([]*Node{node}, t.tree[level]...)
-		} else {
-			t.tree[level] = append(t.tree[level][:idx+1], append([]*Node{node}, t.tree[level][idx:]...)...)
-			t.tree[level][idx+1] = node
-		}
-	} else {
-		for i, n := range t.tree[level] {
-			cmp := bytes.Compare(n.startKey, node.startKey)
-			if cmp < 0 {
-				t.tree[level] = append(t.tree[level][:i+1], t.tree[level][i:]...)
-				t.tree[level][i] = node
-				return
-			}
+		return append(nodes[:idx+1], append([]*Node{node}, nodes[idx+1:]...)...)
+	}
+
+	for i, n := range nodes {
+		if cmp.Compare(n.startKey, node.startKey) < 0 {
+			return append(nodes[:i], append([]*Node{node}, nodes[i:]...)...)
 		}
-		t.tree[level] = append(t.tree[level], node)
 	}
+	return append(nodes, node)
 }
 
 func (t *LSMTree[K, V]) PickCompactionNode(level int) []*Node {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	return t.pickCompactionNodeLocked(level)
+}
 
+func (t *LSMTree[K, V]) pickCompactionNodeLocked(level int) []*Node {
 	compactionNode := make([]*Node, 0)
 	if len(t.tree[level]) == 0 {
 		return compactionNode
 	}
 
+	cmp := t.conf.KeyComparator()
 	// for level 0
 	startKey := t.tree[level][0].startKey
 	endKey := t.tree[level][0].endKey
 
 	if level != 0 {
 		node := t.tree[level][(len(t.tree[level])-1)/2] // find middle point
-		if bytes.Compare(node.startKey, startKey) < 0 {
+		if cmp.Compare(node.startKey, startKey) < 0 {
 			startKey = node.startKey
 		}
-		if bytes.Compare(node.endKey, endKey) > 0 {
+		if cmp.Compare(node.endKey, endKey) > 0 {
 			endKey = node.endKey
 		}
+	} else {
+		// a level 0 file must not move below an older one it overlaps, so
+		// the range grows over every level 0 file it reaches
+		for grown := true; grown; {
+			grown = false
+			for _, node := range t.tree[0] {
+				if node.compacting || cmp.Compare(startKey, node.endKey) > 0 || cmp.Compare(endKey, node.startKey) < 0 {
+					continue
+				}
+				if cmp.Compare(node.startKey, startKey) < 0 {
+					startKey, grown = node.startKey, true
+				}
+				if cmp.Compare(node.endKey, endKey) > 0 {
+					endKey, grown = node.endKey, true
+				}
+			}
+		}
 	}
 
 	for i := level + 1; i >= level; i-- {
@@ -174,17 +416,20 @@ func (t *LSMTree[K, V]) PickCompactionNode(level int) []*Node {
 
 			nodeStartKey := node.index[0].Key
 			nodeEndKey := node.index[len(node.index)-1].Key
+			if len(node.rangeDels) > 0 {
+				nodeStartKey, nodeEndKey = node.startKey, node.endKey
+			}
 
-			if bytes.Compare(startKey, nodeEndKey) <= 0 &&
-				bytes.Compare(endKey, nodeStartKey) >= 0 &&
+			if cmp.Compare(startKey, nodeEndKey) <= 0 &&
+				cmp.Compare(endKey, nodeStartKey) >= 0 &&
 				!node.compacting {
 				compactionNode = append(compactionNode, node)
 				node.compacting = true
 				if i == level+1 {
-					if bytes.Compare(nodeStartKey, startKey) < 0 {
+					if cmp.Compare(nodeStartKey, startKey) < 0 {
 						startKey = node.startKey
 					}
-					if bytes.Compare(nodeEndKey, endKey) > 0 {
+					if cmp.Compare(nodeEndKey, endKey) > 0 {
 						endKey = node.endKey
 					}
 				}
@@ -195,6 +440,90 @@ func (t *LSMTree[K, V]) PickCompactionNode(level int) []*Node {
 	return compactionNode
 }
 
+// startCompactionLocked records the key span the compaction of nodes into
+// level covers until finishCompactionLocked is called.
+func (t *LSMTree[K, V]) startCompactionLocked(nodes []*Node, level int) *compactionSpan {
+	cmp := t.conf.KeyComparator()
+	span := &compactionSpan{level: level, startKey: nodes[0].startKey, endKey: nodes[0].endKey}
+	for _, n := range nodes[1:] {
+		if cmp.Compare(n.startKey, span.startKey) < 0 {
+			span.startKey = n.startKey
+		}
+		if cmp.Compare(n.endKey, span.endKey) > 0 {
+			span.endKey = n.endKey
+		}
+	}
+	t.running = append(t.running, span)
+	return span
+}
+
+func (t *LSMTree[K, V]) finishCompactionLocked(span *compactionSpan) {
+	for i, s := range t.running {
+		if s == span {
+			t.running = append(t.running[:i], t.running[i+1:]...)
+			return
+		}
+	}
+}
+
+// LastSequence returns the sequence number of the last record flushed.
+func (t *LSMTree[K, V]) LastSequence() uint64 {
+	return t.lastSeq.Load()
+}
+
+type LevelStats struct {
+	Level        int
+	NumFiles     int
+	FileSize     int64
+	NumEntries   uint64
+	NumDeletions uint64
+	RawKeySize   uint64
+	RawValueSize uint64
+	DataSize     uint64
+	SmallestKey  []byte
+	LargestKey   []byte
+	MinSeq       uint64
+	MaxSeq       uint64
+}
+
+// LevelStats aggregates the table properties of every file per level.
+func (t *LSMTree[K, V]) LevelStats() ([]LevelStats, error) {
+	tree := t.acquireTree()
+	defer releaseTree(tree)
+
+	stats := make([]LevelStats, len(tree))
+	for level, nodes := range tree {
+		s := &stats[level]
+		s.Level = level
+		for _, node := range nodes {
+			props, err := node.Properties()
+			if err != nil {
+				return nil, fmt.Errorf("level %d node %d properties: %v", node.Level, node.SeqNo, err)
+			}
+			if s.NumFiles == 0 || props.MinSeq < s.MinSeq {
+				s.MinSeq = props.MinSeq
+			}
+			if props.MaxSeq > s.MaxSeq {
+				s.MaxSeq = props.MaxSeq
+			}
+			if s.NumFiles == 0 || t.conf.KeyComparator().Compare(props.SmallestKey, s.SmallestKey) < 0 {
+				s.SmallestKey = props.SmallestKey
+			}
+			if t.conf.KeyComparator().Compare(props.LargestKey, s.LargestKey) > 0 {
+				s.LargestKey = props.LargestKey
+			}
+			s.NumFiles++
+			s.FileSize += node.FileSize
+			s.NumEntries += props.NumEntries
+			s.NumDeletions += props.NumDeletions
+			s.RawKeySize += props.RawKeySize
+			s.RawValueSize += props.RawValueSize
+			s.DataSize += props.DataSize
+		}
+	}
+	return stats, nil
+}
+
 func (t *LSMTree[K, V]) NextSeqNo(level int) int {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -203,94 +532,379 @@ func (t *LSMTree[K, V]) NextSeqNo(level int) int {
 	return t.seqNo[level]
 }
 
-func (t *LSMTree[K, V]) compaction(level int) error {
-	nodes := t.PickCompactionNode(level)
-	lenNodes := len(nodes)
-	if lenNodes == 0 {
+// compaction merges the nodes picked at level into level+1. The job is split
+// into conf.CompactionWorkers key ranges compacted concurrently, and all of
+// their outputs replace the inputs in a single MANIFEST update.
+func (t *LSMTree[K, V]) compaction(level int) (err error) {
+	if level >= t.conf.MaxLevel-1 {
+		return nil
+	}
+	nextLevel := level + 1
+	// an ingest deciding its level against the tree holds manifestMu until
+	// its file is installed, so the span of the pick cannot miss the file
+	t.manifestMu.Lock()
+	t.mu.Lock()
+	nodes := t.pickCompactionNodeLocked(level)
+	var span *compactionSpan
+	if len(nodes) > 0 {
+		span = t.startCompactionLocked(nodes, nextLevel)
+	}
+	t.mu.Unlock()
+	t.manifestMu.Unlock()
+	if len(nodes) == 0 {
 		return nil
 	}
+	defer func() {
+		t.mu.Lock()
+		t.finishCompactionLocked(span)
+		if err != nil {
+			for _, n := range nodes {
+				n.compacting = false
+			}
+		}
+		t.mu.Unlock()
+	}()
 
-	nextLevel := lenNodes + 1
-	seqNo := t.NextSeqNo(nextLevel)
-	extra := nodes[len(nodes)-1].Extra
-	file := utils.FormatName(level, seqNo, extra)
-	writer, err := NewSStWriter(file, t.conf)
+	moved, err := t.trivialMove(nodes, nextLevel)
 	if err != nil {
-		return errors.New("error in new ssWriter : " + err.Error())
+		return err
+	}
+	if moved {
+		t.compactChan <- nextLevel
+		return nil
 	}
 
-	var record *Record
-	var files string
-	maxNodeSize := t.conf.SstSize * int(math.Pow10(nextLevel))
+	extra := nodes[len(nodes)-1].Extra
+	ranges := subcompactionRanges(t.conf.KeyComparator(), nodes, t.conf.CompactionWorkers)
+	outputs := make([][]*Node, len(ranges))
+	errs := make([]error, len(ranges))
+
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, max(t.conf.CompactionWorkers, 1))
+	for i, r := range ranges {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(i int, r keyRange) {
+			defer wg.Done()
+			outputs[i], errs[i] = t.subcompaction(nodes, r, nextLevel, extra)
+			<-workers
+		}(i, r)
+	}
+	wg.Wait()
 
-	for i, node := range nodes {
-		files += utils.FormatName(node.Level, node.SeqNo, node.Extra)
-		record = record.Fill(nodes, i)
+	var added []*Node
+	for i := range ranges {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		added = append(added, outputs[i]...)
+	}
+	var bytesRead, bytesWritten int64
+	for _, n := range nodes {
+		bytesRead += n.FileSize
 	}
+	for _, n := range added {
+		bytesWritten += n.FileSize
+	}
+	if err := t.apply(added, nodes); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	t.stats.Compactions++
+	t.stats.InputFiles += uint64(len(nodes))
+	t.stats.OutputFiles += uint64(len(added))
+	t.stats.BytesRead += bytesRead
+	t.stats.BytesWritten += bytesWritten
+	t.mu.Unlock()
+
+	t.limiter.Tune(t.CompactionDebt())
+	t.compactChan <- nextLevel
+	return nil
+}
 
-	writeCount := 0
+// trivialMove moves the picked nodes to level without rewriting them when
+// none of them overlaps a file of level or, for a level 0 file, an older
+// level 0 file. Only the tree and the MANIFEST change.
+func (t *LSMTree[K, V]) trivialMove(nodes []*Node, level int) (bool, error) {
+	for _, n := range nodes {
+		if n.Level != level-1 {
+			return false, nil
+		}
+	}
+	if level == 1 && len(nodes) > 1 {
+		return false, nil
+	}
 
-	for record != nil {
-		writeCount++
-		i := record.Idx
-		writer.Append(record.Key, record.Value)
-		record = record.next.Fill(nodes, i)
+	t.manifestMu.Lock()
+	defer t.manifestMu.Unlock()
+	t.mu.Lock()
 
-		if writer.Size() > maxNodeSize {
-			size, filter, index, err := writer.Finish()
-			if err != nil {
-				return errors.New("error in finish : " + err.Error())
+	for _, n := range nodes {
+		for _, other := range t.tree[level] {
+			if overlaps(t.conf.KeyComparator(), n, other) {
+				t.mu.Unlock()
+				return false, nil
 			}
-			writer.Close()
-
-			node, err := NewNode(filter, index, level, seqNo, extra, size, t.conf, file)
-			if err != nil {
-				return errors.New("error in create new node : " + err.Error())
+		}
+		if n.Level == 0 {
+			for _, other := range t.tree[0] {
+				if other.SeqNo < n.SeqNo && overlaps(t.conf.KeyComparator(), n, other) {
+					t.mu.Unlock()
+					return false, nil
+				}
 			}
-			t.insertNode(node)
+		}
+	}
 
-			seqNo = t.NextSeqNo(nextLevel)
-			file = utils.FormatName(nextLevel, seqNo, extra)
-			writer, err = NewSStWriter(file, t.conf)
-			if err != nil {
-				return fmt.Errorf("%s error in create writer,cannot compaction lsm log error: %v", file, err)
+	tree := t.cloneTreeLocked()
+	seqNos := append([]int(nil), t.seqNo...)
+	prev := make([][2]int, len(nodes))
+	for i, n := range nodes {
+		prev[i] = [2]int{n.Level, n.SeqNo}
+		tree[n.Level] = removeLevel(tree[n.Level], n)
+		t.seqNo[level]++
+		n.Level, n.SeqNo = level, t.seqNo[level]
+		tree[level] = insertLevel(t.conf.KeyComparator(), tree[level], n)
+	}
+	m := t.manifestLocked(tree)
+	t.mu.Unlock()
+
+	err := t.install(tree, m)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		for i, n := range nodes {
+			n.Level, n.SeqNo = prev[i][0], prev[i][1]
+		}
+		t.seqNo = seqNos
+		return false, err
+	}
+
+	for _, n := range nodes {
+		n.compacting = false
+		t.stats.TrivialMoves++
+		t.stats.TrivialMoveBytes += n.FileSize
+	}
+	return true, nil
+}
+
+func overlaps(cmp utils.BytesComparator, a, b *Node) bool {
+	return cmp.Compare(a.startKey, b.endKey) <= 0 && cmp.Compare(b.startKey, a.endKey) <= 0
+}
+
+// CompactionDebt estimates the bytes compaction still has to rewrite: the
+// whole of level 0 once it holds more than four files and, for the other
+// levels, the bytes above their target size.
+func (t *LSMTree[K, V]) CompactionDebt() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var debt int64
+	for level, nodes := range t.tree {
+		var size int64
+		for _, n := range nodes {
+			size += n.FileSize
+		}
+		if level == 0 {
+			if len(nodes) > 4 {
+				debt += size
 			}
+		} else if target := int64(t.conf.SstSize * int(math.Pow10(level+1))); size > target {
+			debt += size - target
 		}
 	}
+	return debt
+}
 
-	size, filter, index, err := writer.Finish()
-	if err != nil {
-		return errors.New("error in compaction lsm log error: " + err.Error())
+func (t *LSMTree[K, V]) CompactionStats() CompactionStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+// keyRange is the key range [start, end) of a subcompaction; nil bounds are
+// open.
+type keyRange struct {
+	start []byte
+	end   []byte
+}
+
+// subcompactionRanges splits the key space of nodes into at most n ranges
+// whose bounds are index separators of the nodes.
+func subcompactionRanges(cmp utils.BytesComparator, nodes []*Node, n int) []keyRange {
+	if n <= 1 {
+		return []keyRange{{}}
 	}
-	node, err := NewNode(filter, index, level, seqNo, extra, size, t.conf, file)
-	if err != nil {
-		return errors.New("error in create new node : " + err.Error())
+
+	var bounds [][]byte
+	for _, node := range nodes {
+		for _, idx := range node.index[1:] {
+			bounds = append(bounds, idx.Key)
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return cmp.Compare(bounds[i], bounds[j]) < 0 })
+	uniq := bounds[:0]
+	for _, b := range bounds {
+		if len(uniq) == 0 || !bytes.Equal(uniq[len(uniq)-1], b) {
+			uniq = append(uniq, b)
+		}
+	}
+	if n > len(uniq)+1 {
+		n = len(uniq) + 1
 	}
-	t.insertNode(node)
-	t.removeNode(nodes)
 
-	t.compactChan <- nextLevel
-	return nil
+	ranges := make([]keyRange, 0, n)
+	var start []byte
+	for i := 1; i < n; i++ {
+		end := uniq[i*len(uniq)/n]
+		if start != nil && cmp.Compare(end, start) <= 0 {
+			continue
+		}
+		ranges = append(ranges, keyRange{start: start, end: end})
+		start = end
+	}
+	return append(ranges, keyRange{start: start})
 }
 
-func (t *LSMTree[K, V]) removeNode(nodes []*Node) {
+// subcompaction merges the records of nodes within r into new nodes at level.
+// nodes are ordered oldest first, as returned by PickCompactionNode. Records
+// covered by a range tombstone of the inputs are dropped, and so are the
+// tombstones once no deeper level holds keys they may cover.
+func (t *LSMTree[K, V]) subcompaction(nodes []*Node, r keyRange, level int, extra string) ([]*Node, error) {
+	sources := make([]recordSource, len(nodes))
+	var dels []RangeTombstone
+	cmp := t.conf.KeyComparator()
+	for i, node := range nodes {
+		sources[i] = node.newIterator(r.start, r.end)
+		dels = append(dels, node.rangeDels.clip(cmp, r.start, r.end)...)
+	}
+	merged := newMergeIterator(cmp, sources)
+	rangeDels := newRangeDelSet(cmp, dels)
+	keep := t.liveTombstones(rangeDels, nodes)
+
+	maxNodeSize := t.conf.SstSize * int(math.Pow10(level))
+	var outputs []*Node
+	var writer *SsWriter
+	var file string
+	var seqNo int
+	lower := r.start
+
+	open := func() error {
+		seqNo = t.NextSeqNo(level)
+		file = utils.FormatName(level, seqNo, extra)
+		w, err := NewSStWriter(file, t.conf)
+		if err != nil {
+			return fmt.Errorf("%s error in create writer,cannot compaction lsm log error: %v", file, err)
+		}
+		writer = w
+		writer.SetRateLimiter(t.limiter, IOLow)
+		return nil
+	}
+
+	// finish closes the current output, which holds the tombstones of
+	// [lower, upper) so that outputs do not overlap.
+	finish := func(upper []byte) error {
+		for _, d := range keep.clip(cmp, lower, upper) {
+			writer.AddRangeTombstone(d.Start, d.End, d.Seq)
+		}
+		lower = upper
+		size, filter, index, err := writer.Finish()
+		writer.Close()
+		writer = nil
+		if err != nil {
+			return errors.New("error in compaction lsm log error: " + err.Error())
+		}
+		node, err := t.newNode(filter, index, level, seqNo, extra, size, file)
+		if err != nil {
+			return errors.New("error in create new node : " + err.Error())
+		}
+		outputs = append(outputs, node)
+		return nil
+	}
+
+	for record := merged.nextRecord(); record != nil; record = merged.nextRecord() {
+		if rangeDels.covers(cmp, record) {
+			continue
+		}
+		if writer != nil && writer.Size() > maxNodeSize {
+			if err := finish(record.Key); err != nil {
+				return nil, err
+			}
+		}
+		if writer == nil {
+			if err := open(); err != nil {
+				return nil, err
+			}
+		}
+		writer.AppendEntry(record.Key, record.Value, record.Kind, record.Seq)
+	}
+	if err := merged.err(); err != nil {
+		if writer != nil {
+			writer.Close()
+		}
+		return nil, errors.New("error in read compaction input : " + err.Error())
+	}
+	if writer == nil && len(keep.clip(cmp, lower, r.end)) > 0 {
+		if err := open(); err != nil {
+			return nil, err
+		}
+	}
+	if writer != nil {
+		if err := finish(r.end); err != nil {
+			return nil, err
+		}
+	}
+	return outputs, nil
+}
+
+// liveTombstones returns the tombstones of dels that still have to be kept
+// by a compaction of inputs: those overlapping a file that is not an input,
+// at any level, since older level 0 files and the files of the source level
+// left out of the compaction may hold keys they cover as well as the deeper
+// levels.
+func (t *LSMTree[K, V]) liveTombstones(dels rangeDelSet, inputs []*Node) rangeDelSet {
+	isInput := make(map[*Node]bool, len(inputs))
+	for _, n := range inputs {
+		isInput[n] = true
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	cmp := t.conf.KeyComparator()
+	var live rangeDelSet
+	for _, d := range dels {
+	levels:
+		for _, nodes := range t.tree {
+			for _, n := range nodes {
+				if !isInput[n] && cmp.Compare(d.Start, n.endKey) <= 0 && cmp.Compare(n.startKey, d.End) < 0 {
+					live = append(live, d)
+					break levels
+				}
+			}
+		}
+	}
+	return live
+}
+
+func (t *LSMTree[K, V]) removeNode(nodes []*Node) {
+	t.mu.Lock()
+	tree := t.cloneTreeLocked()
 	for _, node := range nodes {
-		for i, tn := range t.tree[node.Level] {
+		for i, tn := range tree[node.Level] {
 			if tn.SeqNo == node.SeqNo {
-				t.tree[node.Level] = append(t.tree[node.Level][:i], t.tree[node.Level][i+1:]...)
+				tree[node.Level] = append(tree[node.Level][:i], tree[node.Level][i+1:]...)
 				break
 			}
 		}
 	}
+	t.tree = tree
+	t.mu.Unlock()
 
-	go func() {
-		for _, n := range nodes {
-			n.destroy()
-		}
-	}()
+	for _, n := range nodes {
+		n.unref()
+	}
 }
 
 func (t *LSMTree[K, V]) CheckCompaction() {
@@ -301,13 +915,13 @@ func (t *LSMTree[K, V]) CheckCompaction() {
 		for {
 			select {
 			case <-level0:
-				if len(t.tree[0]) > 4 {
-					if err := t.compaction(0); err != nil {
-						panic(errors.New(err.Error()))
-					}
+				t.mu.Lock()
+				n := len(t.tree[0])
+				t.mu.Unlock()
+				if n > 4 {
+					t.runCompaction(0)
 				}
 			case <-t.stopChan:
-				close(level0)
 				return
 			}
 		}
@@ -317,23 +931,17 @@ func (t *LSMTree[K, V]) CheckCompaction() {
 		for {
 			select {
 			case lvn := <-levelN:
-				var prevSize int64
 				maxNodeSize := int64(t.conf.SstSize * int(math.Pow10(lvn+1)))
-				for {
-					var totalSize int64
-					for _, node := range t.tree[lvn] {
-						totalSize += node.FileSize
-					}
-					if totalSize > maxNodeSize && (prevSize == 0 || totalSize < prevSize) {
-						if err := t.compaction(lvn); err != nil {
-							panic(errors.New(err.Error()))
-						} else {
-							break
-						}
-					}
+				var totalSize int64
+				t.mu.Lock()
+				for _, node := range t.tree[lvn] {
+					totalSize += node.FileSize
+				}
+				t.mu.Unlock()
+				if totalSize > maxNodeSize {
+					t.runCompaction(lvn)
 				}
 			case <-t.stopChan:
-				close(levelN)
 				return
 			}
 		}
@@ -355,11 +963,15 @@ func (t *LSMTree[K, V]) CheckCompaction() {
 	}()
 }
 
-//func RestoreLSM[K any,V any](conf *config.Config){
-//	lt := NewLSMTree[K,V](conf)
-//	callback := []func(string, fs.FileInfo) {
-//		func(path string, info fs.FileInfo) {
-//
-//		}
-//	}
-//}
+// runCompaction compacts level unless the tree is closed. A failed compaction
+// is logged and its inputs are picked again by a later one.
+func (t *LSMTree[K, V]) runCompaction(level int) {
+	t.closeMu.RLock()
+	defer t.closeMu.RUnlock()
+	if t.closed {
+		return
+	}
+	if err := t.compaction(level); err != nil {
+		log.Printf("error in compaction of level %d : %v", level, err)
+	}
+}
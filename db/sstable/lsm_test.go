@@ -280,7 +280,7 @@ func TestCompareWithBPTree(t *testing.T) {
 
 	startMemBP := getMemoryUsage()
 	startBP := time.Now()
-	bptree := bptree2.NewBPTree[string](10)
+	bptree := bptree2.NewBPTree[string, string](10)
 	for i := 0; i < recordCount; i++ {
 		bptree.Insert(fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
 	}
@@ -299,3 +299,70 @@ func TestCompareWithBPTree(t *testing.T) {
 	t.Logf("BPTree Tree Read Memory Usage: %d KB", endReadMemBP-readMemBP)
 
 }
+
+func getValue[K any, V any](t *testing.T, lsmt *LSMTree[K, V], key K) []byte {
+	t.Helper()
+	value, found, err := lsmt.Get(key)
+	assert.NoError(t, err)
+	if !found {
+		return nil
+	}
+	return value
+}
+
+func TestIntKeyOrder(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[int, int](conf)
+	buf := new(bytes.Buffer)
+	m := memtable.NewMemTable[int, int](&utils.OrderComparator[int]{}, 1<<20,
+		wal.NewReader(buf), wal.NewWriter(buf), time.Hour, memtable.NewIMemTable[int, int](), "intkey", conf)
+	for i := -20; i <= 20; i += 3 {
+		assert.NoError(t, m.Put(i, i*10))
+	}
+	assert.NoError(t, lsmt.FlushRecord(m, "test"))
+
+	keys, values := lsmt.Codecs()
+	v, found, err := lsmt.Get(-11)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	value, err := values.DecodeValue(v)
+	assert.NoError(t, err)
+	assert.Equal(t, -110, value)
+
+	var got []int
+	it := lsmt.NewIterator(nil, nil)
+	for it.Next() {
+		k, err := keys.DecodeKey(it.Key())
+		assert.NoError(t, err)
+		got = append(got, k)
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, []int{-20, -17, -14, -11, -8, -5, -2, 1, 4, 7, 10, 13, 16, 19}, got)
+}
+
+func TestTupleKeys(t *testing.T) {
+	type key = utils.Tuple2[string, int]
+	codec := utils.Tuple2Codec[string, int]{First: utils.StringCodec{}, Second: utils.IntCodec[int]{}}
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[key, string](conf)
+	lsmt.SetCodecs(codec, utils.StringCodec{})
+	buf := new(bytes.Buffer)
+	m := memtable.NewMemTable[key, string](&utils.CodecComparator[key]{Codec: codec}, 1<<20,
+		wal.NewReader(buf), wal.NewWriter(buf), time.Hour, memtable.NewIMemTable[key, string](), "tuple", conf)
+	m.SetCodecs(codec, utils.StringCodec{})
+	for _, user := range []string{"bob", "al", "alice"} {
+		for _, n := range []int{10, 9, -1} {
+			assert.NoError(t, m.Put(key{First: user, Second: n}, fmt.Sprintf("%s/%d", user, n)))
+		}
+	}
+	assert.NoError(t, lsmt.FlushRecord(m, "test"))
+
+	assert.Equal(t, []byte("alice/9"), getValue(t, lsmt, key{First: "alice", Second: 9}))
+	var got []string
+	it := lsmt.NewIterator(nil, nil)
+	for it.Next() {
+		got = append(got, string(it.Value()))
+	}
+	assert.NoError(t, it.Err())
+	assert.Equal(t, []string{"al/-1", "al/9", "al/10", "alice/-1", "alice/9", "alice/10", "bob/-1", "bob/9", "bob/10"}, got)
+}
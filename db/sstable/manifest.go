@@ -0,0 +1,357 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/utils"
+	"os"
+	"path"
+	"strings"
+)
+
+// The MANIFEST is a single block, written like the table meta blocks, that
+// snapshots the tree: the name of the comparator, the key encoding, the last
+// sequence number, the per level file sequence numbers and one entry per live
+// SST. Every change is written to a temporary
+// file and renamed over the previous MANIFEST.
+
+const (
+	manifestName       = "MANIFEST"
+	manifestComparator = "comparator"
+	manifestEncoding   = "key.encoding"
+	manifestLastSeq    = "last.seq"
+	manifestSeqNos     = "level.seqnos"
+	manifestFilePrefix = "file."
+)
+
+var (
+	ErrManifestCorrupted  = errors.New("manifest corrupted")
+	ErrComparatorMismatch = errors.New("comparator mismatch")
+	ErrKeyEncoding        = errors.New("unsupported key encoding")
+)
+
+// keyEncoding versions the bytes keys and values are stored as. Version 1
+// stores them through utils.KeyCodec and utils.ValueCodec, integers as 8
+// big-endian bytes; MANIFESTs without it stored int keys and values as
+// decimal text.
+const keyEncoding = 1
+
+type FileMeta struct {
+	File      string
+	Level     int
+	SeqNo     int
+	Extra     string
+	Size      int64
+	GlobalSeq uint64
+}
+
+type Manifest struct {
+	// Comparator is the name of the comparator ordering the keys; MANIFESTs
+	// written before it was recorded used utils.BytewiseComparator.
+	Comparator string
+	// KeyEncoding is the keyEncoding of the files, 0 for MANIFESTs written
+	// before it was recorded.
+	KeyEncoding uint64
+	LastSeq     uint64
+	SeqNos      []int
+	Files       []FileMeta
+}
+
+func (m *Manifest) encode(conf *config.Config) ([]byte, error) {
+	entries := map[string][]byte{
+		manifestComparator: []byte(m.Comparator),
+		manifestEncoding:   uvarintBytes(m.KeyEncoding),
+		manifestLastSeq:    uvarintBytes(m.LastSeq),
+	}
+	var seqNos []byte
+	for _, s := range m.SeqNos {
+		seqNos = binary.AppendUvarint(seqNos, uint64(s))
+	}
+	entries[manifestSeqNos] = seqNos
+	for _, f := range m.Files {
+		v := binary.AppendUvarint(nil, uint64(f.Level))
+		v = binary.AppendUvarint(v, uint64(f.SeqNo))
+		v = binary.AppendUvarint(v, uint64(f.Size))
+		v = binary.AppendUvarint(v, f.GlobalSeq)
+		entries[manifestFilePrefix+f.File] = append(v, f.Extra...)
+	}
+
+	b := NewBlock(conf)
+	appendSorted(b, entries)
+	buf := bytes.NewBuffer(make([]byte, 0))
+	if _, err := b.FlushBlockTo(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeManifest(data []byte) (*Manifest, error) {
+	n := len(data) - 4
+	if n < 0 || binary.LittleEndian.Uint32(data[n:]) != utils.CompressedCheckSum(data[:n]) {
+		return nil, ErrManifestCorrupted
+	}
+	block, err := snappy.Decode(nil, data[:n])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrManifestCorrupted, err)
+	}
+	entries, err := readNamedBlock(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrManifestCorrupted, err)
+	}
+
+	m := &Manifest{Comparator: utils.BytewiseComparator.Name()}
+	if name, ok := entries[manifestComparator]; ok {
+		m.Comparator = string(name)
+	}
+	m.KeyEncoding, _ = binary.Uvarint(entries[manifestEncoding])
+	m.LastSeq, _ = binary.Uvarint(entries[manifestLastSeq])
+	for buf := entries[manifestSeqNos]; len(buf) > 0; {
+		s, k := binary.Uvarint(buf)
+		if k <= 0 {
+			return nil, ErrManifestCorrupted
+		}
+		m.SeqNos = append(m.SeqNos, int(s))
+		buf = buf[k:]
+	}
+	for name, v := range entries {
+		if !strings.HasPrefix(name, manifestFilePrefix) {
+			continue
+		}
+		f := FileMeta{File: strings.TrimPrefix(name, manifestFilePrefix)}
+		fields := make([]uint64, 4)
+		for i := range fields {
+			u, k := binary.Uvarint(v)
+			if k <= 0 {
+				return nil, fmt.Errorf("%w: bad entry for %s", ErrManifestCorrupted, f.File)
+			}
+			fields[i] = u
+			v = v[k:]
+		}
+		f.Level, f.SeqNo, f.Size, f.GlobalSeq = int(fields[0]), int(fields[1]), int64(fields[2]), fields[3]
+		f.Extra = string(v)
+		m.Files = append(m.Files, f)
+	}
+	return m, nil
+}
+
+// ReadManifest loads the MANIFEST of conf.Dir. A missing MANIFEST is reported
+// with an error matching os.ErrNotExist.
+func ReadManifest(conf *config.Config) (*Manifest, error) {
+	data, err := os.ReadFile(path.Join(conf.Dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	return decodeManifest(data)
+}
+
+func writeManifest(conf *config.Config, m *Manifest) error {
+	data, err := m.encode(conf)
+	if err != nil {
+		return err
+	}
+
+	tmp := path.Join(conf.Dir, manifestName+".tmp")
+	fd, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path.Join(conf.Dir, manifestName)); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(conf.Dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (t *LSMTree[K, V]) manifestLocked(tree [][]*Node) *Manifest {
+	m := &Manifest{
+		Comparator:  t.conf.KeyComparator().Name(),
+		KeyEncoding: keyEncoding,
+		LastSeq:     t.lastSeq.Load(),
+		SeqNos:      append([]int(nil), t.seqNo...),
+	}
+	for _, nodes := range tree {
+		for _, n := range nodes {
+			m.Files = append(m.Files, FileMeta{
+				File:      n.file,
+				Level:     n.Level,
+				SeqNo:     n.SeqNo,
+				Extra:     n.Extra,
+				Size:      n.FileSize,
+				GlobalSeq: n.globalSeq,
+			})
+		}
+	}
+	return m
+}
+
+// apply installs added and drops removed nodes as one change and records the
+// resulting tree in the MANIFEST. The tree is left untouched when the
+// MANIFEST cannot be written.
+func (t *LSMTree[K, V]) apply(added, removed []*Node) error {
+	t.manifestMu.Lock()
+	defer t.manifestMu.Unlock()
+
+	t.mu.Lock()
+	tree := t.cloneTreeLocked()
+	for _, node := range removed {
+		tree[node.Level] = removeLevel(tree[node.Level], node)
+	}
+	for _, node := range added {
+		tree[node.Level] = insertLevel(t.conf.KeyComparator(), tree[node.Level], node)
+	}
+	m := t.manifestLocked(tree)
+	t.mu.Unlock()
+
+	if err := t.install(tree, m); err != nil {
+		return err
+	}
+
+	for _, n := range removed {
+		n.unref()
+	}
+	return nil
+}
+
+func (t *LSMTree[K, V]) cloneTreeLocked() [][]*Node {
+	tree := make([][]*Node, len(t.tree))
+	for i := range t.tree {
+		tree[i] = append([]*Node(nil), t.tree[i]...)
+	}
+	return tree
+}
+
+// install records m in the MANIFEST and makes tree current. The caller holds
+// manifestMu but not mu, so that readers are not held up by the fsync while
+// no other change can land between building tree and installing it.
+func (t *LSMTree[K, V]) install(tree [][]*Node, m *Manifest) error {
+	if err := writeManifest(t.conf, m); err != nil {
+		return errors.New("error in write manifest : " + err.Error())
+	}
+	t.mu.Lock()
+	t.tree = tree
+	t.mu.Unlock()
+	return nil
+}
+
+func removeLevel(nodes []*Node, node *Node) []*Node {
+	for i, n := range nodes {
+		if n == node {
+			return append(nodes[:i], nodes[i+1:]...)
+		}
+	}
+	return nodes
+}
+
+// openNode reopens the SST described by f.
+func (t *LSMTree[K, V]) openNode(f FileMeta) (*Node, error) {
+	r, err := NewSStReader(f.File, t.conf)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	filter, err := r.ReadFilter()
+	if err != nil {
+		return nil, errors.New("error in read filter : " + err.Error())
+	}
+	index, err := r.ReadIndex()
+	if err != nil {
+		return nil, errors.New("error in read index : " + err.Error())
+	}
+	node, err := t.newNode(filter, index, f.Level, f.SeqNo, f.Extra, f.Size, f.File)
+	if err != nil {
+		return nil, err
+	}
+	node.setGlobalSeq(f.GlobalSeq)
+	return node, nil
+}
+
+// RestoreLSMTree reopens the tree recorded in the MANIFEST of conf.Dir. Without
+// a MANIFEST it returns an empty tree. A tree written with another comparator
+// than conf.KeyComparator() is refused with ErrComparatorMismatch, and one
+// whose files store K or V in another encoding with ErrKeyEncoding.
+func RestoreLSMTree[K any, V any](conf *config.Config) (*LSMTree[K, V], error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	m, err := ReadManifest(conf)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewLSMTree[K, V](conf), nil
+	}
+	if err != nil {
+		return nil, errors.New("error in read manifest : " + err.Error())
+	}
+	if m.Comparator != conf.KeyComparator().Name() {
+		return nil, fmt.Errorf("%w: database uses %s, opened with %s", ErrComparatorMismatch, m.Comparator, conf.KeyComparator().Name())
+	}
+	if err := checkKeyEncoding[K, V](m); err != nil {
+		return nil, err
+	}
+
+	t := NewLSMTree[K, V](conf)
+	if err := t.restore(m); err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// checkKeyEncoding refuses files whose keys or values of types K and V are not
+// stored in keyEncoding. Before it, only int keys and values were stored
+// another way.
+func checkKeyEncoding[K any, V any](m *Manifest) error {
+	if m.KeyEncoding == keyEncoding || len(m.Files) == 0 {
+		return nil
+	}
+	if m.KeyEncoding > keyEncoding {
+		return fmt.Errorf("%w: database uses %d, this version reads %d", ErrKeyEncoding, m.KeyEncoding, keyEncoding)
+	}
+	var k K
+	var v V
+	_, intKey := any(k).(int)
+	_, intValue := any(v).(int)
+	if intKey || intValue {
+		return fmt.Errorf("%w: database stores int keys and values as decimal text", ErrKeyEncoding)
+	}
+	return nil
+}
+
+// restore opens the files recorded in m into the empty tree t.
+func (t *LSMTree[K, V]) restore(m *Manifest) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastSeq.Store(m.LastSeq)
+	copy(t.seqNo, m.SeqNos)
+	for _, f := range m.Files {
+		if f.Level >= len(t.tree) {
+			return fmt.Errorf("manifest file %s at level %d beyond max level %d", f.File, f.Level, len(t.tree)-1)
+		}
+		node, err := t.openNode(f)
+		if err != nil {
+			return fmt.Errorf("error in open %s : %v", f.File, err)
+		}
+		t.tree[f.Level] = insertLevel(t.conf.KeyComparator(), t.tree[f.Level], node)
+	}
+	return nil
+}
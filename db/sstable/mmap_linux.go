@@ -0,0 +1,23 @@
+//go:build linux
+
+package sstable
+
+import (
+	"os"
+	"syscall"
+)
+
+func mmapFile(fd *os.File) ([]byte, error) {
+	info, err := fd.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	return syscall.Mmap(int(fd.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package sstable
+
+import (
+	"errors"
+	"os"
+)
+
+func mmapFile(fd *os.File) ([]byte, error) {
+	return nil, errors.New("mmap sst reads are only supported on linux")
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMmapReader(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	conf.SstDataBlockSize = 256
+	conf.MmapReads = true
+	w, err := NewSStWriter("1.sst", conf)
+	assert.NoError(t, err)
+	for i := 0; i < 500; i++ {
+		w.Append([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d", i)))
+	}
+	size, filter, index, err := w.Finish()
+	assert.NoError(t, err)
+	w.Close()
+
+	node, err := NewNode(filter, index, 0, 1, "test", size, conf, "1.sst")
+	assert.NoError(t, err)
+	assert.NotNil(t, node.sr.data)
+	readIndex, err := node.sr.ReadIndex()
+	assert.NoError(t, err)
+	assert.Len(t, readIndex, len(index))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				value, err := node.Get([]byte(fmt.Sprintf("key%03d", i)))
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value%d", i), string(value))
+			}
+		}()
+	}
+	wg.Wait()
+
+	h := index[1]
+	data, err := os.ReadFile(path.Join(conf.Dir, "1.sst"))
+	assert.NoError(t, err)
+	data[h.PrevOffset] ^= 0xff
+	assert.NoError(t, os.WriteFile(path.Join(conf.Dir, "2.sst"), data, 0644))
+
+	r2, err := NewSStReader("2.sst", conf)
+	assert.NoError(t, err)
+	assert.NoError(t, r2.ReadFooter())
+	_, err = r2.ReadBlock(h.PrevOffset, h.PrevSize)
+	assert.ErrorContains(t, err, "CRC mismatch")
+	_, err = r2.ReadBlock(uint64(len(r2.data)), 8)
+	assert.Error(t, err)
+}
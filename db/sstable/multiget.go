@@ -0,0 +1,111 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// MultiGet returns the values of keys, nil for the keys not found. The keys
+// are sorted and every node is probed once for all the keys in its range, so
+// that a data block shared by several keys is read once.
+func (t *LSMTree[K, V]) MultiGet(keys []K) ([][]byte, error) {
+	bkeys := make([][]byte, len(keys))
+	for i, k := range keys {
+		bkey, err := t.keyCodec.EncodeKey(k)
+		if err != nil {
+			return nil, err
+		}
+		bkeys[i] = bkey
+	}
+
+	t.closeMu.RLock()
+	defer t.closeMu.RUnlock()
+	if t.closed {
+		return nil, utils.ErrClosed
+	}
+	records, err := t.multiLookup(bkeys)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, len(keys))
+	for i, rec := range records {
+		if rec == nil || rec.Kind == KindDeletion {
+			continue
+		}
+		if values[i], err = t.resolve(rec); err != nil {
+			return nil, fmt.Errorf("resolve value of %q: %v", rec.Key, err)
+		}
+	}
+	return values, nil
+}
+
+// multiLookup is lookup for many keys: the newest record of keys[i], or nil,
+// is returned at position i.
+func (t *LSMTree[K, V]) multiLookup(keys [][]byte) ([]*Record, error) {
+	cmp := t.conf.KeyComparator()
+	sorted := append([][]byte(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return cmp.Compare(sorted[i], sorted[j]) < 0 })
+	uniq := sorted[:0]
+	for _, k := range sorted {
+		if len(uniq) == 0 || !bytes.Equal(uniq[len(uniq)-1], k) {
+			uniq = append(uniq, k)
+		}
+	}
+
+	tree := t.acquireTree()
+	defer releaseTree(tree)
+
+	found := make([]*Record, len(uniq))
+	deletedAt := make([]uint64, len(uniq))
+	pending := make([]int, len(uniq))
+	for i := range pending {
+		pending[i] = i
+	}
+	for _, nodes := range tree {
+		for i := len(nodes) - 1; i >= 0 && len(pending) > 0; i-- {
+			node := nodes[i]
+			lo := sort.Search(len(pending), func(j int) bool { return cmp.Compare(uniq[pending[j]], node.startKey) >= 0 })
+			hi := sort.Search(len(pending), func(j int) bool { return cmp.Compare(uniq[pending[j]], node.endKey) > 0 })
+			if lo >= hi {
+				continue
+			}
+
+			probe := make([][]byte, hi-lo)
+			for j, p := range pending[lo:hi] {
+				probe[j] = uniq[p]
+				if seq := node.rangeDels.maxSeq(cmp, uniq[p]); seq > deletedAt[p] {
+					deletedAt[p] = seq
+				}
+			}
+			records, err := node.multiLookup(probe)
+			if err != nil {
+				return nil, err
+			}
+
+			left := pending[:lo]
+			for j, p := range pending[lo:hi] {
+				rec := records[j]
+				if rec == nil {
+					left = append(left, p)
+					continue
+				}
+				if rec.Seq < deletedAt[p] {
+					rec = &Record{Key: uniq[p], Kind: KindDeletion, Seq: deletedAt[p]}
+				}
+				found[p] = rec
+			}
+			pending = append(left, pending[hi:]...)
+		}
+	}
+
+	records := make([]*Record, len(keys))
+	for i, k := range keys {
+		j := sort.Search(len(uniq), func(j int) bool { return cmp.Compare(uniq[j], k) >= 0 })
+		records[i] = found[j]
+	}
+	return records, nil
+}
@@ -0,0 +1,66 @@
+package sstable
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiGetMatchesGet(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	conf := config.NewConfig(t.TempDir())
+	conf.SstDataBlockSize = 128
+	lsmt := NewLSMTree[string, string](conf)
+
+	for _, level := range []int{2, 1, 0, 0} {
+		var records []Record
+		for i := rnd.Intn(5); i < 300; i += 1 + rnd.Intn(5) {
+			rec := Record{Key: []byte(fmt.Sprintf("key%03d", i)), Value: []byte(fmt.Sprintf("%d@%d", i, level)), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)}
+			if rnd.Intn(6) == 0 {
+				rec.Kind, rec.Value = KindDeletion, []byte{}
+			}
+			records = append(records, rec)
+		}
+		addTestNode(t, lsmt, level, records)
+	}
+	w, err := NewSStWriter("0_9_range.sst", conf)
+	assert.NoError(t, err)
+	w.AddRangeTombstone([]byte("key100"), []byte("key150"), lsmt.lastSeq.Add(1))
+	size, filter, index, err := w.Finish()
+	assert.NoError(t, err)
+	w.Close()
+	node, err := lsmt.newNode(filter, index, 0, 9, "range", size, "0_9_range.sst")
+	assert.NoError(t, err)
+	lsmt.insertNode(node)
+
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%03d", rnd.Intn(320))
+	}
+	values, err := lsmt.MultiGet(keys)
+	assert.NoError(t, err)
+	assert.Len(t, values, len(keys))
+	for i, k := range keys {
+		assert.Equal(t, getValue(t, lsmt, k), values[i], k)
+	}
+}
+
+func TestMultiGetReadsBlockOnce(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	var records []Record
+	for i := 0; i < 100; i++ {
+		records = append(records, Record{Key: []byte(fmt.Sprintf("key%03d", i)), Value: []byte("v"), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)})
+	}
+	addTestNode(t, lsmt, 1, records)
+	sr := lsmt.tree[1][0].sr
+	before := sr.BlockReads()
+
+	values, err := lsmt.MultiGet([]string{"key090", "key001", "key050", "key001", "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("v"), []byte("v"), []byte("v"), []byte("v"), nil}, values)
+	assert.Equal(t, uint64(1), sr.BlockReads()-before, "all keys share one data block")
+}
@@ -7,7 +7,9 @@ import (
 	"github.com/peterouob/gocloud/db/config"
 	"github.com/peterouob/gocloud/db/utils"
 	"io"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 type NodeInterface interface {
@@ -15,8 +17,12 @@ type NodeInterface interface {
 }
 
 type Node struct {
-	wg         sync.WaitGroup
+	// refs counts the tree holding the node and the reads using it; the
+	// node is destroyed when the last of them releases it.
+	refs       atomic.Int32
 	sr         *SStReader
+	cache      *BlockCache
+	file       string
 	filter     map[uint64][]byte
 	startKey   []byte
 	endKey     []byte
@@ -26,10 +32,17 @@ type Node struct {
 	Extra      string
 	FileSize   int64
 	compacting bool
+	version    uint32
+	// propsMu guards props, read lazily by Properties.
+	propsMu sync.Mutex
+	props   *Properties
+	// globalSeq, when set, replaces the sequence number of every record,
+	// see IngestExternalFiles.
+	globalSeq uint64
+	rangeDels rangeDelSet
+	cmp       utils.BytesComparator
 
-	curBlock int
-	curBuf   *bytes.Buffer
-	prevKey  []byte
+	cursor *tableIterator
 }
 
 var _ NodeInterface = (*Node)(nil)
@@ -40,136 +53,276 @@ func NewNode(filter map[uint64][]byte, index []*Index, level, seqNo int, extra s
 		return nil, errors.New("error in new ssReader : " + err.Error())
 	}
 
-	return &Node{
-		sr:       r,
-		filter:   filter,
-		index:    index,
-		startKey: index[0].Key,
-		endKey:   index[len(index)-1].Key,
-		Level:    level,
-		SeqNo:    seqNo,
-		Extra:    extra,
-		FileSize: fileSize,
-		curBlock: 1,
-	}, nil
+	if err := r.ReadFooter(); err != nil {
+		return nil, errors.New("error in read footer : " + err.Error())
+	}
+	props, err := r.ReadProperties()
+	if err != nil && !errors.Is(err, ErrNoProperties) {
+		return nil, errors.New("error in read properties : " + err.Error())
+	}
+
+	dels, err := r.ReadRangeDels()
+	if err != nil {
+		return nil, errors.New("error in read range deletions : " + err.Error())
+	}
+
+	node := &Node{
+		sr:        r,
+		file:      file,
+		version:   r.Footer.Version,
+		props:     props,
+		filter:    filter,
+		index:     index,
+		startKey:  index[0].Key,
+		endKey:    index[len(index)-1].Key,
+		Level:     level,
+		SeqNo:     seqNo,
+		Extra:     extra,
+		FileSize:  fileSize,
+		rangeDels: newRangeDelSet(conf.KeyComparator(), dels),
+		cmp:       conf.KeyComparator(),
+	}
+	node.refs.Store(1)
+	node.extendBounds()
+	return node, nil
 }
 
-func (n *Node) nextRecord() ([]byte, []byte) {
-	if n.curBuf == nil {
-		if n.curBlock > len(n.index)-1 {
-			return nil, nil
+// extendBounds widens the key range of the node to its range tombstones. A
+// table without records only has the sentinel index entry.
+func (n *Node) extendBounds() {
+	for i, d := range n.rangeDels {
+		if (i == 0 && len(n.index) == 1) || n.cmp.Compare(d.Start, n.startKey) < 0 {
+			n.startKey = d.Start
 		}
-
-		data, err := n.sr.ReadBlock(n.index[n.curBlock].PrevOffset, n.index[n.curBlock].PrevSize)
-		if err != nil {
-			if err != io.EOF {
-				panic(errors.New("error in readBlock EOF : " + err.Error()))
-			}
-			return nil, nil
+		if (i == 0 && len(n.index) == 1) || n.cmp.Compare(d.End, n.endKey) > 0 {
+			n.endKey = d.End
 		}
+	}
+}
 
-		record, _, _ := DecodeBlock(data)
-		n.curBuf = bytes.NewBuffer(record)
-		n.prevKey = make([]byte, 0)
-		n.curBlock++
+// setGlobalSeq makes every record and tombstone of the node use seq.
+func (n *Node) setGlobalSeq(seq uint64) {
+	n.globalSeq = seq
+	if seq == 0 {
+		return
+	}
+	for i := range n.rangeDels {
+		n.rangeDels[i].Seq = seq
 	}
+}
 
-	key, value, err := ReadRecord(n.prevKey, n.curBuf)
-	if err == nil {
-		n.prevKey = key
-		return key, value
+func (n *Node) nextRecord() *Record {
+	if n.cursor == nil {
+		n.cursor = n.newIterator(nil, nil)
 	}
+	return n.cursor.nextRecord()
+}
 
-	if err != io.EOF {
-		panic(errors.New("read records error : " + err.Error()))
-		return nil, nil
+func (n *Node) decodeRecord(key, value []byte) (*Record, error) {
+	if n.version < FormatV2 {
+		return &Record{Key: key, Value: value, Kind: KindValue, Seq: n.globalSeq}, nil
 	}
-	n.curBuf = nil
-	return n.nextRecord()
+	kind, seq, v, err := DecodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if n.globalSeq != 0 {
+		seq = n.globalSeq
+	}
+	return &Record{Key: key, Value: v, Kind: kind, Seq: seq}, nil
 }
 
 func (n *Node) Get(key []byte) ([]byte, error) {
-	if bytes.Compare(key, n.startKey) < 0 || bytes.Compare(key, n.endKey) > 0 {
-		//log.Printf("Key %v out of range: startKey %v, endKey %v", key, n.startKey, n.endKey)
+	rec, err := n.lookup(key)
+	if err != nil || rec == nil || rec.Kind == KindDeletion {
+		return nil, err
+	}
+	return rec.Value, nil
+}
+
+// lookup returns the record stored for key, including deletion markers, or nil
+// when the table does not contain key.
+func (n *Node) lookup(key []byte) (*Record, error) {
+	index, err := n.blockFor(key)
+	if err != nil || index == nil {
+		return nil, err
+	}
+	data, err := n.sr.ReadBlock(index.PrevOffset, index.PrevSize)
+	if err != nil {
+		return nil, fmt.Errorf("%d stage %d node, read block error %w", n.Level, n.SeqNo, err)
+	}
+	return n.searchRecord(data, index.PrevOffset, key)
+}
+
+// searchRecord returns the record of key from the data block read at offset,
+// or nil.
+func (n *Node) searchRecord(data []byte, offset uint64, key []byte) (*Record, error) {
+	value, err := searchBlock(n.cmp, data, key)
+	if err != nil {
+		return nil, utils.NewCorruptionError(n.file, offset, fmt.Errorf("%d stage %d node, read records error %v", n.Level, n.SeqNo, err))
+	}
+	if value == nil {
+		return nil, nil
+	}
+	rec, err := n.decodeRecord(key, value)
+	if err != nil {
+		return nil, utils.NewCorruptionError(n.file, offset, fmt.Errorf("decode value of %q: %v", key, err))
+	}
+	return rec, nil
+}
+
+func (n *Node) Properties() (*Properties, error) {
+	n.propsMu.Lock()
+	defer n.propsMu.Unlock()
+	if n.props == nil {
+		props, err := n.sr.ReadProperties()
+		if err != nil {
+			return nil, err
+		}
+		n.props = props
+	}
+	return n.props, nil
+}
+
+// searchIndex returns the position of the first entry whose key is >= key.
+func searchIndex(cmp utils.BytesComparator, index []*Index, key []byte) int {
+	return sort.Search(len(index), func(i int) bool {
+		return cmp.Compare(key, index[i].Key) <= 0
+	})
+}
+
+// blockFor returns the index entry of the data block that may hold key, or nil
+// when the bounds or the bloom filter rule key out.
+func (n *Node) blockFor(key []byte) (*Index, error) {
+	if n.cmp.Compare(key, n.startKey) < 0 || n.cmp.Compare(key, n.endKey) > 0 {
+		return nil, nil
+	}
+
+	i := searchIndex(n.cmp, n.index[1:], key) + 1
+	if i >= len(n.index) {
+		return nil, nil
+	}
+	entries, err := n.blockIndex(i)
+	if err != nil {
+		return nil, fmt.Errorf("%d stage %d node, read index error %w", n.Level, n.SeqNo, err)
+	}
+	j := searchIndex(n.cmp, entries, key)
+	if j >= len(entries) {
 		return nil, nil
 	}
+	index := entries[j]
+
+	filter, err := n.blockFilter(i, index.PrevOffset)
+	if err != nil {
+		return nil, fmt.Errorf("%d stage %d node, read filter error %w", n.Level, n.SeqNo, err)
+	}
+	if !utils.Contains(filter, key) {
+		return nil, nil
+	}
+	return index, nil
+}
 
-	for _, index := range n.index[1:] {
-		f := n.filter[index.PrevOffset]
-		if !utils.Contains(f, key) {
+// multiLookup looks up sorted keys, reading every data block at most once.
+// The record of keys[i], or nil, is returned at position i.
+func (n *Node) multiLookup(keys [][]byte) ([]*Record, error) {
+	type blockGet struct {
+		index *Index
+		keys  []int
+	}
+	var blocks []*blockGet
+	for i, key := range keys {
+		index, err := n.blockFor(key)
+		if err != nil {
+			return nil, err
+		}
+		if index == nil {
 			continue
 		}
-		if bytes.Compare(key, index.Key) <= 0 {
-			data, err := n.sr.readBlock(int64(index.PrevOffset), int64(index.PrevSize))
-			if err != nil {
-				if err != io.EOF {
-					return nil, fmt.Errorf("%d stage %d node, read records error %v", n.Level, n.SeqNo, err)
-				}
-				return nil, errors.New("error in readBlock EOF : " + err.Error())
-			}
-			record, restartPoint, err := DecodeBlock(data)
-			if err != nil {
-				return nil, fmt.Errorf("%d stage %d node, read records error %v", n.Level, n.SeqNo, err)
-			}
-			prevOffset := restartPoint[len(restartPoint)-1]
-			if len(restartPoint) == 1 {
-				recordBuf := bytes.NewBuffer(record)
-				rKey, value, err := ReadRecord(nil, recordBuf)
-				if err != nil {
-					return nil, fmt.Errorf("%d stage %d node, read records error %v", n.Level, n.SeqNo, err)
-				}
-				cmp := bytes.Compare(key, rKey)
-				if cmp != 0 {
-					return nil, fmt.Errorf("%d stage %d node, read records error %v", n.Level, n.SeqNo, err)
-				} else {
-					return value, nil
-				}
-			} else {
-				for i := len(restartPoint) - 2; i >= 0; i-- {
-					recordBuf := bytes.NewBuffer(record[restartPoint[i]:prevOffset])
-					rKey, value, err := ReadRecord(nil, recordBuf)
-					if err != nil {
-						if err != io.EOF {
-							return nil, fmt.Errorf("%d stage %d node, read records error %v", n.Level, n.SeqNo, err)
-						}
-						return nil, errors.New("error in readBlock EOF : " + err.Error())
-					}
-					cmp := bytes.Compare(key, rKey)
-					if cmp < 0 {
-						prevOffset = restartPoint[i]
-						continue
-					} else if cmp == 0 {
-						return value, nil
-					} else {
-						prevKey := rKey
-						for {
-							rKey, value, err = ReadRecord(prevKey, recordBuf)
-							if err != nil {
-								if err != io.EOF {
-									return nil, fmt.Errorf("%d stage %d node, read records error %v", n.Level, n.SeqNo, err)
-								}
-								return nil, errors.New("error in readBlock EOF : " + err.Error())
-							}
-							if bytes.Equal(key, rKey) {
-								return value, nil
-							}
-							prevKey = rKey
-						}
-					}
-				}
+		if last := len(blocks) - 1; last >= 0 && blocks[last].index.PrevOffset == index.PrevOffset {
+			blocks[last].keys = append(blocks[last].keys, i)
+		} else {
+			blocks = append(blocks, &blockGet{index: index, keys: []int{i}})
+		}
+	}
+
+	records := make([]*Record, len(keys))
+	for _, b := range blocks {
+		data, err := n.sr.ReadBlock(b.index.PrevOffset, b.index.PrevSize)
+		if err != nil {
+			return nil, fmt.Errorf("%d stage %d node, read block error %w", n.Level, n.SeqNo, err)
+		}
+		for _, i := range b.keys {
+			if records[i], err = n.searchRecord(data, b.index.PrevOffset, keys[i]); err != nil {
+				return nil, err
 			}
 		}
 	}
-	return nil, nil
+	return records, nil
+}
+
+// searchBlock binary searches the restart points of a data block and scans
+// forward from the closest one for key.
+func searchBlock(cmp utils.BytesComparator, block, key []byte) ([]byte, error) {
+	record, restartPoint, err := DecodeBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	var searchErr error
+	i := sort.Search(len(restartPoint), func(i int) bool {
+		rKey, _, err := ReadRecord(nil, bytes.NewBuffer(record[restartPoint[i]:]))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return cmp.Compare(rKey, key) > 0
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	if i == 0 {
+		return nil, nil
+	}
+
+	recordBuf := bytes.NewBuffer(record[restartPoint[i-1]:])
+	var prevKey []byte
+	for {
+		rKey, value, err := ReadRecord(prevKey, recordBuf)
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c := cmp.Compare(key, rKey)
+		if c == 0 {
+			return value, nil
+		} else if c < 0 {
+			return nil, nil
+		}
+		prevKey = rKey
+	}
+}
+
+// ref takes a reference on n for a read of a tree holding it.
+func (n *Node) ref() {
+	n.refs.Add(1)
+}
+
+// unref releases a reference on n and destroys n with the last one.
+func (n *Node) unref() {
+	if n.refs.Add(-1) == 0 {
+		n.destroy()
+	}
 }
 
+// destroy removes the file of n once nothing references it any more.
 func (n *Node) destroy() {
-	n.wg.Wait()
-	//n.sr.Destroy()
+	n.sr.Destroy()
+	n.cache.Evict(n.file)
 	n.Level = -1
 	n.filter = nil
 	n.index = nil
-	n.curBuf = nil
-	n.prevKey = nil
+	n.cursor = nil
 	n.FileSize = 0
 }
@@ -0,0 +1,166 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// A partitioned table keeps only a top-level index in memory. Entry 0 is the
+// usual start key sentinel and entry i (i >= 1) points to index partition i-1,
+// a block holding the data block index entries of that partition. With
+// partitioned filters the top-level filter block maps the partition number i
+// to the handle of a filter partition holding the blooms of its data blocks.
+
+// partitionIndex splits the data block index entries into groups of roughly
+// conf.IndexPartitionSize encoded bytes.
+func (w *SsWriter) partitionIndex() [][]*Index {
+	groups := make([][]*Index, 0)
+	var group []*Index
+	size := 0
+	for _, idx := range w.index[1:] {
+		group = append(group, idx)
+		size += len(idx.Key) + 2*binary.MaxVarintLen32
+		if size >= w.conf.IndexPartitionSize {
+			groups = append(groups, group)
+			group, size = nil, 0
+		}
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// writePartitioned writes the filter and index partitions followed by their
+// top-level blocks starting at offset, and replaces w.index and w.filter with
+// the top-level entries.
+func (w *SsWriter) writePartitioned(offset int64) (BlockHandle, BlockHandle, error) {
+	var filterHandle, indexHandle BlockHandle
+	buf := bytes.NewBuffer(make([]byte, 0))
+	write := func(b *Block) (BlockHandle, error) {
+		h := BlockHandle{Offset: uint64(offset) + uint64(buf.Len())}
+		size, err := b.FlushBlockTo(buf)
+		h.Size = size
+		return h, err
+	}
+
+	groups := w.partitionIndex()
+
+	var err error
+	if w.conf.PartitionFilter {
+		topFilter := make(map[uint64][]byte, len(groups))
+		topBlock := NewBlock(w.conf)
+		for i, group := range groups {
+			b := NewBlock(w.conf)
+			for _, idx := range group {
+				b.Append(uvarintBytes(idx.PrevOffset), w.filter[idx.PrevOffset])
+			}
+			h, err := write(b)
+			if err != nil {
+				return filterHandle, indexHandle, err
+			}
+			topFilter[uint64(i+1)] = encodeHandle(h)
+			topBlock.Append(uvarintBytes(uint64(i+1)), encodeHandle(h))
+		}
+		if filterHandle, err = write(topBlock); err != nil {
+			return filterHandle, indexHandle, err
+		}
+		w.filter = topFilter
+		w.props.FilterPartitions = uint64(len(groups))
+	} else if filterHandle, err = write(w.filterBlock); err != nil {
+		return filterHandle, indexHandle, err
+	}
+
+	top := []*Index{w.index[0]}
+	topBlock := NewBlock(w.conf)
+	topBlock.Append(w.index[0].Key, encodeHandle(BlockHandle{}))
+	for _, group := range groups {
+		b := NewBlock(w.conf)
+		for _, idx := range group {
+			b.Append(idx.Key, encodeHandle(BlockHandle{Offset: idx.PrevOffset, Size: idx.PrevSize}))
+		}
+		h, err := write(b)
+		if err != nil {
+			return filterHandle, indexHandle, err
+		}
+		last := group[len(group)-1].Key
+		top = append(top, &Index{Key: last, PrevOffset: h.Offset, PrevSize: h.Size})
+		topBlock.Append(last, encodeHandle(h))
+	}
+	if indexHandle, err = write(topBlock); err != nil {
+		return filterHandle, indexHandle, err
+	}
+	w.index = top
+	w.props.IndexPartitions = uint64(len(groups))
+
+	if _, err := w.write(buf.Bytes()); err != nil {
+		return filterHandle, indexHandle, err
+	}
+	return filterHandle, indexHandle, nil
+}
+
+func uvarintBytes(v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, v)]
+}
+
+// blockIndex returns the data block index entries covered by the top-level
+// index entry i (i >= 1), loading the partition through the block cache.
+func (n *Node) blockIndex(i int) ([]*Index, error) {
+	if n.props == nil || n.props.IndexPartitions == 0 {
+		return n.index[i : i+1], nil
+	}
+
+	h := n.index[i]
+	key := cacheKey{file: n.file, offset: h.PrevOffset}
+	if v, ok := n.cache.Get(key); ok {
+		return v.([]*Index), nil
+	}
+
+	data, err := n.sr.ReadBlock(h.PrevOffset, h.PrevSize)
+	if err != nil {
+		return nil, fmt.Errorf("read index partition at %d: %w", h.PrevOffset, err)
+	}
+	entries, err := ReadIndex(data)
+	if err != nil {
+		return nil, utils.NewCorruptionError(n.file, h.PrevOffset, err)
+	}
+	n.cache.Put(key, entries, int64(h.PrevSize))
+	return entries, nil
+}
+
+// blockFilter returns the bloom filter of the data block at blockOffset, which
+// belongs to the top-level index entry i.
+func (n *Node) blockFilter(i int, blockOffset uint64) ([]byte, error) {
+	if n.props == nil || n.props.FilterPartitions == 0 {
+		return n.filter[blockOffset], nil
+	}
+
+	h, err := decodeHandle(n.filter[uint64(i)])
+	if err != nil {
+		return nil, utils.NewCorruptionError(n.file, 0, fmt.Errorf("filter partition %d: %v", i, err))
+	}
+	key := cacheKey{file: n.file, offset: h.Offset}
+	v, ok := n.cache.Get(key)
+	if !ok {
+		data, err := n.sr.ReadBlock(h.Offset, h.Size)
+		if err != nil {
+			return nil, fmt.Errorf("read filter partition at %d: %w", h.Offset, err)
+		}
+		entries, err := readNamedBlock(data)
+		if err != nil {
+			return nil, utils.NewCorruptionError(n.file, h.Offset, err)
+		}
+		filters := make(map[uint64][]byte, len(entries))
+		for k, f := range entries {
+			offset, _ := binary.Uvarint([]byte(k))
+			filters[offset] = f
+		}
+		n.cache.Put(key, filters, int64(h.Size))
+		v = filters
+	}
+	return v.(map[uint64][]byte)[blockOffset], nil
+}
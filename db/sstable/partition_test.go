@@ -0,0 +1,111 @@
+package sstable
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionedIndex(t *testing.T) {
+	testCases := []struct {
+		name            string
+		partitionIndex  bool
+		partitionFilter bool
+	}{
+		{"single level", false, false},
+		{"partitioned index", true, false},
+		{"partitioned index and filter", true, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf := config.NewConfig(t.TempDir())
+			conf.SstDataBlockSize = 256
+			conf.IndexPartitionSize = 128
+			conf.PartitionIndex = tc.partitionIndex
+			conf.PartitionFilter = tc.partitionFilter
+
+			const n = 2000
+			w, err := NewSStWriter("1.sst", conf)
+			assert.NoError(t, err)
+			for i := 0; i < n; i++ {
+				w.Append([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("value%d", i)))
+			}
+			size, filter, index, err := w.Finish()
+			assert.NoError(t, err)
+			w.Close()
+
+			props := w.Properties()
+			if tc.partitionIndex {
+				assert.Greater(t, props.IndexPartitions, uint64(1))
+				assert.Len(t, index, int(props.IndexPartitions)+1)
+			} else {
+				assert.Zero(t, props.IndexPartitions)
+			}
+			if tc.partitionFilter {
+				assert.Equal(t, props.IndexPartitions, props.FilterPartitions)
+				assert.Len(t, filter, int(props.FilterPartitions))
+			}
+
+			node, err := NewNode(filter, index, 0, 1, "test", size, conf, "1.sst")
+			assert.NoError(t, err)
+			node.cache = NewBlockCache(conf.BlockCacheSize)
+
+			for i := 0; i < n; i++ {
+				value, err := node.Get([]byte(fmt.Sprintf("key%05d", i)))
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value%d", i), string(value))
+			}
+			value, err := node.Get([]byte("key00010x"))
+			assert.NoError(t, err)
+			assert.Nil(t, value)
+
+			if tc.partitionIndex {
+				assert.Greater(t, node.cache.Size(), int64(0))
+			}
+
+			count := 0
+			for rec := node.nextRecord(); rec != nil; rec = node.nextRecord() {
+				assert.Equal(t, fmt.Sprintf("key%05d", count), string(rec.Key))
+				count++
+			}
+			assert.Equal(t, n, count)
+
+			r, err := NewSStReader("1.sst", conf)
+			assert.NoError(t, err)
+			readIndex, err := r.ReadIndex()
+			assert.NoError(t, err)
+			assert.Len(t, readIndex, len(index))
+		})
+	}
+}
+
+func TestBlockCacheEviction(t *testing.T) {
+	c := NewBlockCache(100)
+	c.Put(cacheKey{file: "a", offset: 0}, "a0", 60)
+	c.Put(cacheKey{file: "a", offset: 1}, "a1", 30)
+	_, ok := c.Get(cacheKey{file: "a", offset: 0})
+	assert.True(t, ok)
+
+	c.Put(cacheKey{file: "b", offset: 0}, "b0", 30)
+	_, ok = c.Get(cacheKey{file: "a", offset: 1})
+	assert.False(t, ok, "least recently used block should be evicted")
+	assert.Equal(t, int64(90), c.Size())
+
+	c.Evict("a")
+	assert.Equal(t, int64(30), c.Size())
+}
+
+func TestPartitionFilterRequiresIndex(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	conf.PartitionFilter = true
+	_, err := NewSStWriter("1.sst", conf)
+	assert.ErrorIs(t, err, config.ErrInvalidConfig)
+	_, err = RestoreLSMTree[string, string](conf)
+	assert.ErrorIs(t, err, config.ErrInvalidConfig)
+
+	conf.PartitionIndex = true
+	assert.NoError(t, conf.Validate())
+}
@@ -0,0 +1,245 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	metaPropertiesName = "gocloud.properties"
+
+	propCompression  = "gocloud.compression"
+	propCreationTime = "gocloud.creation.time"
+	propDataSize     = "gocloud.data.size"
+	propFilterParts  = "gocloud.filter.partitions"
+	propFilterPolicy = "gocloud.filter.policy"
+	propFilterSize   = "gocloud.filter.size"
+	propIndexParts   = "gocloud.index.partitions"
+	propIndexSize    = "gocloud.index.size"
+	propLargestKey   = "gocloud.largest.key"
+	propMaxSeq       = "gocloud.max.seq"
+	propMinSeq       = "gocloud.min.seq"
+	propNumDeletions = "gocloud.num.deletions"
+	propNumEntries   = "gocloud.num.entries"
+	propNumRangeDels = "gocloud.num.range.deletions"
+	propRawKeySize   = "gocloud.raw.key.size"
+	propRawValueSize = "gocloud.raw.value.size"
+	propSmallestKey  = "gocloud.smallest.key"
+)
+
+const filterBitsPerKey = 10
+
+var ErrNoProperties = errors.New("sst has no properties block")
+
+// Properties describes the content of a table without reading its data blocks.
+type Properties struct {
+	NumEntries   uint64
+	NumDeletions uint64
+	// NumRangeDeletions counts the range tombstones, not included in
+	// NumEntries.
+	NumRangeDeletions uint64
+	RawKeySize        uint64
+	RawValueSize      uint64
+	DataSize          uint64 // compressed size of all data blocks
+	IndexSize         uint64
+	FilterSize        uint64
+	SmallestKey       []byte
+	LargestKey        []byte
+	MinSeq            uint64
+	MaxSeq            uint64
+	CreationTime      int64 // unix seconds
+	Compression       string
+	FilterPolicy      string
+	// IndexPartitions and FilterPartitions are zero for single-level blocks.
+	IndexPartitions  uint64
+	FilterPartitions uint64
+}
+
+func (p *Properties) add(key, value []byte, kind ValueKind, seq uint64) {
+	if p.NumEntries == 0 {
+		p.SmallestKey = append([]byte(nil), key...)
+		p.MinSeq = seq
+		p.MaxSeq = seq
+	}
+	p.NumEntries++
+	if kind == KindDeletion {
+		p.NumDeletions++
+	}
+	p.RawKeySize += uint64(len(key))
+	p.RawValueSize += uint64(len(value))
+	p.LargestKey = append(p.LargestKey[:0], key...)
+	if seq < p.MinSeq {
+		p.MinSeq = seq
+	}
+	if seq > p.MaxSeq {
+		p.MaxSeq = seq
+	}
+}
+
+func (p *Properties) encodeTo(b *Block) {
+	props := map[string][]byte{
+		propCompression:  []byte(p.Compression),
+		propCreationTime: uvarintBytes(uint64(p.CreationTime)),
+		propDataSize:     uvarintBytes(p.DataSize),
+		propFilterParts:  uvarintBytes(p.FilterPartitions),
+		propFilterPolicy: []byte(p.FilterPolicy),
+		propFilterSize:   uvarintBytes(p.FilterSize),
+		propIndexParts:   uvarintBytes(p.IndexPartitions),
+		propIndexSize:    uvarintBytes(p.IndexSize),
+		propLargestKey:   p.LargestKey,
+		propMaxSeq:       uvarintBytes(p.MaxSeq),
+		propMinSeq:       uvarintBytes(p.MinSeq),
+		propNumDeletions: uvarintBytes(p.NumDeletions),
+		propNumEntries:   uvarintBytes(p.NumEntries),
+		propNumRangeDels: uvarintBytes(p.NumRangeDeletions),
+		propRawKeySize:   uvarintBytes(p.RawKeySize),
+		propRawValueSize: uvarintBytes(p.RawValueSize),
+		propSmallestKey:  p.SmallestKey,
+	}
+	appendSorted(b, props)
+}
+
+func decodeProperties(block []byte) (*Properties, error) {
+	props, err := readNamedBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Properties{}
+	uvarints := map[string]*uint64{
+		propDataSize:     &p.DataSize,
+		propFilterParts:  &p.FilterPartitions,
+		propIndexParts:   &p.IndexPartitions,
+		propFilterSize:   &p.FilterSize,
+		propIndexSize:    &p.IndexSize,
+		propMaxSeq:       &p.MaxSeq,
+		propMinSeq:       &p.MinSeq,
+		propNumDeletions: &p.NumDeletions,
+		propNumEntries:   &p.NumEntries,
+		propNumRangeDels: &p.NumRangeDeletions,
+		propRawKeySize:   &p.RawKeySize,
+		propRawValueSize: &p.RawValueSize,
+	}
+	for name, dst := range uvarints {
+		if v, ok := props[name]; ok {
+			u, n := binary.Uvarint(v)
+			if n <= 0 {
+				return nil, fmt.Errorf("sst properties: bad value for %s", name)
+			}
+			*dst = u
+		}
+	}
+	if v, ok := props[propCreationTime]; ok {
+		u, _ := binary.Uvarint(v)
+		p.CreationTime = int64(u)
+	}
+	p.Compression = string(props[propCompression])
+	p.FilterPolicy = string(props[propFilterPolicy])
+	p.SmallestKey = props[propSmallestKey]
+	p.LargestKey = props[propLargestKey]
+	return p, nil
+}
+
+// appendSorted appends the name/value pairs to b in name order.
+func appendSorted(b *Block, entries map[string][]byte) {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.Append([]byte(name), entries[name])
+	}
+}
+
+// readNamedBlock decodes a block written by appendSorted.
+func readNamedBlock(block []byte) (map[string][]byte, error) {
+	data, _, err := DecodeBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(data)
+
+	entries := make(map[string][]byte)
+	prevKey := make([]byte, 0)
+	for {
+		key, value, err := ReadRecord(prevKey, buf)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries[string(key)] = value
+		prevKey = key
+	}
+}
+
+func encodeHandle(h BlockHandle) []byte {
+	buf := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, h.Offset)
+	n += binary.PutUvarint(buf[n:], h.Size)
+	return buf[:n]
+}
+
+func decodeHandle(buf []byte) (BlockHandle, error) {
+	offset, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return BlockHandle{}, errors.New("bad block handle")
+	}
+	size, m := binary.Uvarint(buf[n:])
+	if m <= 0 {
+		return BlockHandle{}, errors.New("bad block handle")
+	}
+	return BlockHandle{Offset: offset, Size: size}, nil
+}
+
+// ReadMetaIndex returns the handles of the table's meta blocks by name.
+func (r *SStReader) ReadMetaIndex() (map[string]BlockHandle, error) {
+	if r.Footer == nil {
+		if err := r.ReadFooter(); err != nil {
+			return nil, err
+		}
+	}
+	handles := make(map[string]BlockHandle)
+	if r.Footer.Meta.IsZero() {
+		return handles, nil
+	}
+
+	data, err := r.ReadBlock(r.Footer.Meta.Offset, r.Footer.Meta.Size)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readNamedBlock(data)
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range entries {
+		h, err := decodeHandle(v)
+		if err != nil {
+			return nil, fmt.Errorf("meta index %s: %v", name, err)
+		}
+		handles[name] = h
+	}
+	return handles, nil
+}
+
+func (r *SStReader) ReadProperties() (*Properties, error) {
+	handles, err := r.ReadMetaIndex()
+	if err != nil {
+		return nil, err
+	}
+	h, ok := handles[metaPropertiesName]
+	if !ok {
+		return nil, ErrNoProperties
+	}
+	data, err := r.ReadBlock(h.Offset, h.Size)
+	if err != nil {
+		return nil, err
+	}
+	return decodeProperties(data)
+}
@@ -0,0 +1,111 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/memtable"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/peterouob/gocloud/db/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadProperties(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	w, err := NewSStWriter("1.sst", conf)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		kind := KindValue
+		if i%10 == 0 {
+			kind = KindDeletion
+		}
+		w.AppendEntry([]byte(fmt.Sprintf("key%03d", i)), []byte("value"), kind, uint64(i+5))
+	}
+	_, _, _, err = w.Finish()
+	assert.NoError(t, err)
+	w.Close()
+
+	r, err := NewSStReader("1.sst", conf)
+	assert.NoError(t, err)
+	props, err := r.ReadProperties()
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint64(100), props.NumEntries)
+	assert.Equal(t, uint64(10), props.NumDeletions)
+	assert.Equal(t, uint64(600), props.RawKeySize)
+	assert.Equal(t, uint64(500), props.RawValueSize)
+	assert.Equal(t, []byte("key000"), props.SmallestKey)
+	assert.Equal(t, []byte("key099"), props.LargestKey)
+	assert.Equal(t, uint64(5), props.MinSeq)
+	assert.Equal(t, uint64(104), props.MaxSeq)
+	assert.Equal(t, "snappy", props.Compression)
+	assert.Equal(t, "bloomfilter.10", props.FilterPolicy)
+	assert.Greater(t, props.DataSize, uint64(0))
+	assert.Greater(t, props.IndexSize, uint64(0))
+	assert.InDelta(t, time.Now().Unix(), props.CreationTime, 5)
+}
+
+func TestLevelStats(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+
+	buf := new(bytes.Buffer)
+	im := memtable.NewIMemTable[string, string]()
+	m := memtable.NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20,
+		wal.NewReader(buf), wal.NewWriter(buf), time.Hour, im, "stats", conf)
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, m.Put(fmt.Sprintf("key%02d", i), "v"))
+	}
+	m.Rep().Delete("key05")
+
+	assert.NoError(t, lsmt.FlushRecord(m, "test"))
+
+	stats, err := lsmt.LevelStats()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats[0].NumFiles)
+	assert.Equal(t, uint64(20), stats[0].NumEntries)
+	assert.Equal(t, uint64(1), stats[0].NumDeletions)
+	assert.Equal(t, []byte("key00"), stats[0].SmallestKey)
+	assert.Equal(t, []byte("key19"), stats[0].LargestKey)
+	assert.Equal(t, uint64(1), stats[0].MinSeq)
+	assert.Equal(t, lsmt.LastSequence(), stats[0].MaxSeq)
+	assert.Equal(t, 0, stats[1].NumFiles)
+
+	assert.Nil(t, getValue(t, lsmt, "key05"))
+	assert.Equal(t, []byte("v"), getValue(t, lsmt, "key06"))
+}
+
+func TestLevelStatsConcurrentReads(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	kvs := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		kvs[fmt.Sprintf("key%03d", i)] = "v"
+	}
+	flushTestMemTable(t, lsmt, conf, kvs)
+	for _, node := range lsmt.tree[0] {
+		node.props = nil // read lazily, as for tables opened without them
+	}
+
+	done := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		go func(g int) {
+			defer func() { done <- struct{}{} }()
+			for i := 0; i < 200; i++ {
+				if g%2 == 0 {
+					_, err := lsmt.LevelStats()
+					assert.NoError(t, err)
+					continue
+				}
+				assert.Equal(t, []byte("v"), getValue(t, lsmt, fmt.Sprintf("key%03d", (i*7+g)%200)))
+			}
+		}(g)
+	}
+	for g := 0; g < 4; g++ {
+		<-done
+	}
+}
@@ -0,0 +1,156 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/peterouob/gocloud/db/utils"
+)
+
+// metaRangeDelName names the meta block holding the range tombstones of a
+// table: one entry per tombstone keyed by its start key, the value being
+// seq(uvarint) | end key.
+const metaRangeDelName = "gocloud.range_del"
+
+// RangeTombstone deletes the keys in [Start, End) written before Seq.
+type RangeTombstone struct {
+	Start []byte
+	End   []byte
+	Seq   uint64
+}
+
+func (d RangeTombstone) contains(cmp utils.BytesComparator, key []byte) bool {
+	return cmp.Compare(d.Start, key) <= 0 && cmp.Compare(key, d.End) < 0
+}
+
+// AddRangeTombstone adds a tombstone deleting [start, end) to the table.
+// Tombstones may be added in any order, before Finish.
+func (w *SsWriter) AddRangeTombstone(start, end []byte, seq uint64) {
+	w.rangeDels = append(w.rangeDels, RangeTombstone{
+		Start: append([]byte(nil), start...),
+		End:   append([]byte(nil), end...),
+		Seq:   seq,
+	})
+	w.props.NumRangeDeletions++
+}
+
+// writeRangeDels writes the range deletion block at offset and returns its
+// handle, or a zero handle when the table has no tombstones.
+func (w *SsWriter) writeRangeDels(buf *bytes.Buffer, offset int64) (BlockHandle, error) {
+	if len(w.rangeDels) == 0 {
+		return BlockHandle{}, nil
+	}
+	dels := newRangeDelSet(w.conf.KeyComparator(), w.rangeDels)
+	b := NewBlock(w.conf)
+	for _, d := range dels {
+		v := binary.AppendUvarint(nil, d.Seq)
+		b.Append(d.Start, append(v, d.End...))
+	}
+	size, err := b.FlushBlockTo(buf)
+	if err != nil {
+		return BlockHandle{}, err
+	}
+	return BlockHandle{Offset: uint64(offset), Size: size}, nil
+}
+
+func decodeRangeDels(block []byte) ([]RangeTombstone, error) {
+	data, _, err := DecodeBlock(block)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(data)
+
+	var dels []RangeTombstone
+	prevKey := make([]byte, 0)
+	for {
+		key, value, err := ReadRecord(prevKey, buf)
+		if err == io.EOF {
+			return dels, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq, n := binary.Uvarint(value)
+		if n <= 0 {
+			return nil, errors.New("bad range tombstone")
+		}
+		dels = append(dels, RangeTombstone{Start: key, End: value[n:], Seq: seq})
+		prevKey = key
+	}
+}
+
+// ReadRangeDels returns the range tombstones of the table, if any.
+func (r *SStReader) ReadRangeDels() ([]RangeTombstone, error) {
+	handles, err := r.ReadMetaIndex()
+	if err != nil {
+		return nil, err
+	}
+	h, ok := handles[metaRangeDelName]
+	if !ok {
+		return nil, nil
+	}
+	data, err := r.ReadBlock(h.Offset, h.Size)
+	if err != nil {
+		return nil, err
+	}
+	dels, err := decodeRangeDels(data)
+	if err != nil {
+		return nil, fmt.Errorf("range deletion block: %v", err)
+	}
+	return dels, nil
+}
+
+// rangeDelSet is a set of tombstones ordered by start key, then newest first.
+// Its methods take the comparator the set was built with.
+type rangeDelSet []RangeTombstone
+
+func newRangeDelSet(cmp utils.BytesComparator, dels []RangeTombstone) rangeDelSet {
+	s := append(rangeDelSet(nil), dels...)
+	sort.SliceStable(s, func(i, j int) bool {
+		if c := cmp.Compare(s[i].Start, s[j].Start); c != 0 {
+			return c < 0
+		}
+		return s[i].Seq > s[j].Seq
+	})
+	return s
+}
+
+// maxSeq returns the highest sequence number of the tombstones covering key,
+// zero when none does.
+func (s rangeDelSet) maxSeq(cmp utils.BytesComparator, key []byte) uint64 {
+	n := sort.Search(len(s), func(i int) bool { return cmp.Compare(s[i].Start, key) > 0 })
+	var seq uint64
+	for _, d := range s[:n] {
+		if d.Seq > seq && d.contains(cmp, key) {
+			seq = d.Seq
+		}
+	}
+	return seq
+}
+
+// covers reports whether rec is deleted by a newer tombstone of s.
+func (s rangeDelSet) covers(cmp utils.BytesComparator, rec *Record) bool {
+	return s.maxSeq(cmp, rec.Key) > rec.Seq
+}
+
+// clip returns the tombstones of s restricted to [lo, hi); nil bounds are
+// open.
+func (s rangeDelSet) clip(cmp utils.BytesComparator, lo, hi []byte) rangeDelSet {
+	var clipped rangeDelSet
+	for _, d := range s {
+		if lo != nil && cmp.Compare(d.Start, lo) < 0 {
+			d.Start = lo
+		}
+		if hi != nil && cmp.Compare(d.End, hi) > 0 {
+			d.End = hi
+		}
+		if cmp.Compare(d.Start, d.End) < 0 {
+			clipped = append(clipped, d)
+		}
+	}
+	return clipped
+}
@@ -0,0 +1,161 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/peterouob/gocloud/db/memtable"
+	"github.com/peterouob/gocloud/db/utils"
+	"github.com/peterouob/gocloud/db/wal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeDelBlock(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	w, err := NewSStWriter("range.sst", conf)
+	assert.NoError(t, err)
+	w.AppendEntry([]byte("a"), []byte("1"), KindValue, 1)
+	w.AddRangeTombstone([]byte("m"), []byte("p"), 3)
+	w.AddRangeTombstone([]byte("c"), []byte("f"), 2)
+	_, _, _, err = w.Finish()
+	assert.NoError(t, err)
+	w.Close()
+
+	r, err := NewSStReader("range.sst", conf)
+	assert.NoError(t, err)
+	defer r.Close()
+	dels, err := r.ReadRangeDels()
+	assert.NoError(t, err)
+	assert.Equal(t, []RangeTombstone{
+		{Start: []byte("c"), End: []byte("f"), Seq: 2},
+		{Start: []byte("m"), End: []byte("p"), Seq: 3},
+	}, dels)
+	props, err := r.ReadProperties()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), props.NumRangeDeletions)
+	assert.Equal(t, uint64(1), props.NumEntries)
+
+	cmp := utils.BytewiseComparator
+	set := newRangeDelSet(cmp, dels)
+	assert.Equal(t, uint64(2), set.maxSeq(cmp, []byte("c")))
+	assert.Zero(t, set.maxSeq(cmp, []byte("f")))
+	assert.True(t, set.covers(cmp, &Record{Key: []byte("n"), Seq: 2}))
+	assert.False(t, set.covers(cmp, &Record{Key: []byte("n"), Seq: 4}))
+	assert.Equal(t, rangeDelSet{{Start: []byte("d"), End: []byte("f"), Seq: 2}, {Start: []byte("m"), End: []byte("n"), Seq: 3}},
+		set.clip(cmp, []byte("d"), []byte("n")))
+}
+
+func TestDeleteRange(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+
+	ref := make(map[string]string)
+	var older []Record
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		older = append(older, Record{Key: []byte(key), Value: []byte("old"), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)})
+		ref[key] = "old"
+	}
+	addTestNode(t, lsmt, 2, older)
+
+	buf := new(bytes.Buffer)
+	m := memtable.NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20,
+		wal.NewReader(buf), wal.NewWriter(buf), time.Hour, memtable.NewIMemTable[string, string](), "rangedel", conf)
+	assert.NoError(t, m.Put("key010", "before"))
+	assert.Error(t, m.DeleteRange("key050", "key020"))
+	assert.NoError(t, m.DeleteRange("key010", "key030"))
+	assert.NoError(t, m.Put("key015", "after"))
+	_, err := m.Get("key010")
+	assert.Error(t, err, "the memtable key is deleted")
+	for i := 10; i < 30; i++ {
+		delete(ref, fmt.Sprintf("key%03d", i))
+	}
+	ref["key015"] = "after"
+
+	assert.NoError(t, lsmt.FlushRecord(m, "test"))
+	props, err := lsmt.tree[0][0].Properties()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), props.NumRangeDeletions)
+
+	check := func() {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key%03d", i)
+			if v, ok := ref[key]; ok {
+				assert.Equal(t, v, string(getValue(t, lsmt, key)), key)
+			} else {
+				assert.Nil(t, getValue(t, lsmt, key), key)
+			}
+		}
+		assert.Equal(t, expectScan(ref, "", ""), scanTree(t, lsmt, nil, nil))
+		assert.Equal(t, expectScan(ref, "key005", "key025"), scanTree(t, lsmt, []byte("key005"), []byte("key025")))
+	}
+	check()
+
+	// level 2 holds covered keys: the tombstone is kept at level 1
+	assert.NoError(t, lsmt.compaction(0))
+	assert.Len(t, lsmt.tree[1], 1)
+	assert.Len(t, lsmt.tree[1][0].rangeDels, 1)
+	check()
+
+	// at the bottom the covered records and the tombstone are dropped
+	assert.NoError(t, lsmt.compaction(1))
+	assert.Empty(t, lsmt.tree[1])
+	records := levelRecords(lsmt.tree[2])
+	assert.Len(t, records, len(ref)+1, "the point deletion of key010 is kept")
+	for _, n := range lsmt.tree[2] {
+		assert.Empty(t, n.rangeDels)
+	}
+	check()
+}
+
+func TestDeleteRangeOnlyTombstones(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	addTestNode(t, lsmt, 1, []Record{
+		{Key: []byte("a"), Value: []byte("1"), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)},
+		{Key: []byte("b"), Value: []byte("2"), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)},
+		{Key: []byte("c"), Value: []byte("3"), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)},
+	})
+
+	buf := new(bytes.Buffer)
+	m := memtable.NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20,
+		wal.NewReader(buf), wal.NewWriter(buf), time.Hour, memtable.NewIMemTable[string, string](), "rangedel", conf)
+	assert.NoError(t, m.DeleteRange("b", "c"))
+	assert.NoError(t, lsmt.FlushRecord(m, "test"))
+
+	node := lsmt.tree[0][0]
+	assert.Equal(t, []byte("b"), node.startKey)
+	assert.Equal(t, []byte("c"), node.endKey)
+	assert.Nil(t, getValue(t, lsmt, "b"))
+	assert.Equal(t, [][2]string{{"a", "1"}, {"c", "3"}}, scanTree(t, lsmt, nil, nil))
+
+	restored, err := RestoreLSMTree[string, string](conf)
+	assert.NoError(t, err)
+	assert.Nil(t, getValue(t, restored, "b"))
+	assert.Equal(t, []byte("3"), getValue(t, restored, "c"))
+}
+
+func TestRangeTombstoneOverOlderLevel0File(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	lsmt := NewLSMTree[string, string](conf)
+	addTestNode(t, lsmt, 0, []Record{{Key: []byte("b"), Value: []byte("oldest"), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)}})
+	addTestNode(t, lsmt, 0, []Record{{Key: []byte("k"), Value: []byte("old"), Kind: KindValue, Seq: lsmt.lastSeq.Add(1)}})
+
+	buf := new(bytes.Buffer)
+	m := memtable.NewMemTable[string, string](&utils.OrderComparator[string]{}, 1<<20,
+		wal.NewReader(buf), wal.NewWriter(buf), time.Hour, memtable.NewIMemTable[string, string](), "rangedel", conf)
+	assert.NoError(t, m.DeleteRange("a", "z"))
+	assert.NoError(t, m.Put("x", "new"))
+	assert.NoError(t, lsmt.FlushRecord(m, "test"))
+	assert.Nil(t, getValue(t, lsmt, "k"))
+
+	// the compaction starts from the oldest file, [b, b], which the file
+	// holding k does not overlap while the newest file, [a, z], does
+	assert.NoError(t, lsmt.compaction(0))
+	assert.Nil(t, getValue(t, lsmt, "b"))
+	assert.Nil(t, getValue(t, lsmt, "k"), "k is not resurrected")
+	assert.Equal(t, [][2]string{{"x", "new"}}, scanTree(t, lsmt, nil, nil))
+}
@@ -0,0 +1,152 @@
+package sstable
+
+import (
+	"sync"
+	"time"
+)
+
+type IOPriority int
+
+const (
+	IOLow IOPriority = iota
+	IOHigh
+)
+
+// refillPeriod bounds the burst of a RateLimiter to the bytes of one period.
+const refillPeriod = 100 * time.Millisecond
+
+// RateLimiter is a token bucket limiting SST writes to a number of bytes per
+// second. Waiting high priority requests (flushes) are served before low
+// priority ones (compactions). A nil RateLimiter does not limit anything.
+type RateLimiter struct {
+	mu        sync.Mutex
+	base      int64
+	rate      int64
+	available int64
+	last      time.Time
+	waiting   [2]int
+	total     [2]int64
+
+	maxRate  int64
+	debtStep int64
+}
+
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{
+		base: bytesPerSec,
+		rate: bytesPerSec,
+		last: time.Now(),
+	}
+}
+
+// AutoTune lets Tune raise the rate by the configured rate for every
+// debtStep bytes of compaction debt, up to maxBytesPerSec.
+func (l *RateLimiter) AutoTune(maxBytesPerSec, debtStep int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxRate = maxBytesPerSec
+	l.debtStep = debtStep
+}
+
+// Tune sets the rate from the pending compaction debt when auto tuning is on.
+func (l *RateLimiter) Tune(debt int64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.debtStep <= 0 || l.base <= 0 {
+		return
+	}
+
+	l.refillLocked()
+	rate := l.maxRate
+	if steps := debt / l.debtStep; steps < l.maxRate/l.base {
+		rate = l.base * (1 + steps)
+	}
+	l.rate = max(rate, l.base)
+}
+
+func (l *RateLimiter) BytesPerSecond() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// TotalBytes returns the bytes granted to requests of priority pri.
+func (l *RateLimiter) TotalBytes(pri IOPriority) int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total[pri]
+}
+
+// Request blocks until n bytes may be written at priority pri. n is granted
+// in chunks of at most one burst, clamped again on every pass since Tune may
+// shrink the burst while the request waits.
+func (l *RateLimiter) Request(n int64, pri IOPriority) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.waiting[pri]++
+	defer func() { l.waiting[pri]-- }()
+	for n > 0 {
+		l.refillLocked()
+		if l.rate <= 0 {
+			return
+		}
+		chunk := min(n, l.burstLocked())
+		if l.available >= chunk && (pri == IOHigh || l.waiting[IOHigh] == 0) {
+			l.available -= chunk
+			l.total[pri] += chunk
+			n -= chunk
+			continue
+		}
+
+		wait := time.Duration((chunk - l.available) * int64(time.Second) / l.rate)
+		if wait < time.Millisecond {
+			wait = time.Millisecond
+		}
+		l.mu.Unlock()
+		time.Sleep(wait)
+		l.mu.Lock()
+	}
+}
+
+// Burst returns the most bytes granted at once, or 0 when nothing is limited.
+func (l *RateLimiter) Burst() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	return l.burstLocked()
+}
+
+func (l *RateLimiter) burstLocked() int64 {
+	return max(l.rate*int64(refillPeriod)/int64(time.Second), 1)
+}
+
+func (l *RateLimiter) refillLocked() {
+	now := time.Now()
+	elapsed := min(now.Sub(l.last), refillPeriod)
+	l.available += int64(elapsed) * l.rate / int64(time.Second)
+	l.last = now
+	if burst := l.burstLocked(); l.available > burst {
+		l.available = burst
+	}
+}
@@ -0,0 +1,126 @@
+package sstable
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peterouob/gocloud/db/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterThrottles(t *testing.T) {
+	l := NewRateLimiter(1 << 20)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		l.Request(50<<10, IOLow)
+	}
+	// 300KB at 1MB/s, less the first burst of 100ms
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+	assert.Equal(t, int64(300<<10), l.TotalBytes(IOLow))
+
+	var nilLimiter *RateLimiter
+	nilLimiter.Request(1<<30, IOHigh)
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+	l := NewRateLimiter(100 << 10)
+	l.Request(10<<10, IOLow) // drain the initial burst
+
+	var mu sync.Mutex
+	var order []IOPriority
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l.Request(60<<10, IOLow)
+		mu.Lock()
+		order = append(order, IOLow)
+		mu.Unlock()
+	}()
+	go func() {
+		defer wg.Done()
+		time.Sleep(20 * time.Millisecond)
+		l.Request(20<<10, IOHigh)
+		mu.Lock()
+		order = append(order, IOHigh)
+		mu.Unlock()
+	}()
+	wg.Wait()
+	assert.Equal(t, []IOPriority{IOHigh, IOLow}, order)
+}
+
+func TestRateLimiterAutoTune(t *testing.T) {
+	l := NewRateLimiter(1000)
+	l.Tune(1 << 30)
+	assert.Equal(t, int64(1000), l.BytesPerSecond(), "tuning is off by default")
+
+	l.AutoTune(8000, 100)
+	l.Tune(0)
+	assert.Equal(t, int64(1000), l.BytesPerSecond())
+	l.Tune(350)
+	assert.Equal(t, int64(4000), l.BytesPerSecond())
+	l.Tune(1 << 30)
+	assert.Equal(t, int64(8000), l.BytesPerSecond())
+	l.Tune(0)
+	assert.Equal(t, int64(1000), l.BytesPerSecond())
+}
+
+func TestRateLimiterTuneDownWhileWaiting(t *testing.T) {
+	l := NewRateLimiter(1 << 20)
+	l.AutoTune(2<<20, 1)
+	l.Tune(1) // burst of 200KB
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Request(200<<10, IOHigh)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// the pending request no longer fits in the burst of 100KB
+	l.Tune(0)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("request stuck after the rate was lowered")
+	}
+	assert.Equal(t, int64(200<<10), l.TotalBytes(IOHigh))
+}
+
+func TestFlushRateLimited(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	conf.RateLimitBytesPerSec = 1 << 30
+	lsmt := NewLSMTree[string, string](conf)
+
+	kvs := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		kvs[fmt.Sprintf("key%03d", i)] = "value"
+	}
+	flushTestMemTable(t, lsmt, conf, kvs)
+	assert.Equal(t, lsmt.tree[0][0].FileSize, lsmt.limiter.TotalBytes(IOHigh))
+	assert.Zero(t, lsmt.limiter.TotalBytes(IOLow))
+}
+
+func TestWriteChunkedByBurst(t *testing.T) {
+	conf := config.NewConfig(t.TempDir())
+	w, err := NewSStWriter("chunked.sst", conf)
+	assert.NoError(t, err)
+	defer w.Close()
+	l := NewRateLimiter(10 << 10) // burst of 1KB
+	w.SetRateLimiter(l, IOLow)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := w.write(make([]byte, 10<<10))
+		assert.NoError(t, err)
+		assert.Equal(t, 10<<10, n)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	info, err := w.fd.Stat()
+	assert.NoError(t, err)
+	assert.Greater(t, info.Size(), int64(0), "granted chunks are written while the rest waits")
+	assert.Less(t, info.Size(), int64(10<<10))
+	<-done
+	assert.Equal(t, int64(10<<10), l.TotalBytes(IOLow))
+}
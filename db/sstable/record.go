@@ -1,69 +1,45 @@
 package sstable
 
 import (
-	"bytes"
+	"encoding/binary"
+	"errors"
 )
 
-type Record struct {
-	Key   []byte
-	Value []byte
-	Idx   int
-	next  *Record
-}
+type ValueKind uint8
 
-func (r *Record) push(key, value []byte, idx int) (*Record, int) {
-	h := r
-	cur := r
-	var prev *Record
-	for {
-		if cur == nil {
-			if prev != nil {
-				prev.next = &Record{Key: key, Value: value, Idx: idx}
-			} else {
-				h = &Record{Key: key, Value: value, Idx: idx}
-			}
-			break
-		}
+const (
+	KindDeletion ValueKind = iota
+	KindValue
+	// KindBlobIndex values are an encoded BlobRef.
+	KindBlobIndex
+)
 
-		cmp := bytes.Compare(key, cur.Key)
-		if cmp == 0 {
-			if idx >= r.Idx {
-				oldIdx := cur.Idx
-				cur.Key = key
-				cur.Value = value
-				cur.Idx = idx
-				return h, oldIdx
-			} else {
-				return h, idx
-			}
-		} else if cmp < 0 {
-			if prev != nil {
-				prev.next = &Record{Key: key, Value: value, Idx: idx}
-			} else {
-				h = &Record{Key: key, Value: value, Idx: idx}
-			}
-			break
-		} else {
-			prev = cur
-			cur = cur.next
-		}
-	}
-	return h, -1
+var errBadValue = errors.New("malformed sst value")
+
+// EncodeValue tags value with its kind and sequence number, the record value
+// layout from FormatV2 on: kind(1) | seq(uvarint) | value.
+func EncodeValue(kind ValueKind, seq uint64, value []byte) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen64+len(value))
+	buf[0] = byte(kind)
+	n := 1 + binary.PutUvarint(buf[1:], seq)
+	n += copy(buf[n:], value)
+	return buf[:n]
 }
 
-func (r *Record) Fill(source []*Node, idx int) *Record {
-	record := r
-	k, v := source[idx].nextRecord()
-	if k != nil {
-		record, idx = record.push(k, v, idx)
-		for idx > -1 {
-			k, v := source[idx].nextRecord()
-			if k != nil {
-				record, idx = record.push(k, v, idx)
-			} else {
-				idx = -1
-			}
-		}
+func DecodeValue(data []byte) (ValueKind, uint64, []byte, error) {
+	if len(data) < 2 || ValueKind(data[0]) > KindBlobIndex {
+		return 0, 0, nil, errBadValue
 	}
-	return record
+	seq, n := binary.Uvarint(data[1:])
+	if n <= 0 {
+		return 0, 0, nil, errBadValue
+	}
+	return ValueKind(data[0]), seq, data[1+n:], nil
+}
+
+type Record struct {
+	Key   []byte
+	Value []byte
+	Kind  ValueKind
+	Seq   uint64
 }
@@ -1,7 +1,6 @@
 package sstable
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
@@ -13,7 +12,8 @@ import (
 	"log"
 	"os"
 	"path"
-	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type SStReaderInterface interface {
@@ -24,16 +24,21 @@ type SStReaderInterface interface {
 	Destroy()
 }
 
+// SStReader reads the blocks of an SST with ReadAt, so concurrent reads share
+// it without a lock.
 type SStReader struct {
-	mu           sync.Mutex
 	conf         *config.Config
 	fd           *os.File
-	reader       *bufio.Reader
 	FilterOffset int64
 	FilterSize   int64
 	IndexOffset  int64
 	IndexSize    int64
+	Footer       *Footer
 	compress     []byte
+	// data maps the whole file when conf.MmapReads is set; blocks are then
+	// sliced out of it without taking mu.
+	data       []byte
+	blockReads atomic.Uint64
 }
 
 var _ SStReaderInterface = (*SStReader)(nil)
@@ -44,11 +49,17 @@ func NewSStReader(file string, conf *config.Config) (*SStReader, error) {
 		return nil, errors.New("error in open file : " + err.Error())
 	}
 
-	return &SStReader{
-		conf:   conf,
-		fd:     fd,
-		reader: bufio.NewReader(fd),
-	}, nil
+	r := &SStReader{
+		conf: conf,
+		fd:   fd,
+	}
+	if conf.MmapReads {
+		if r.data, err = mmapFile(fd); err != nil {
+			fd.Close()
+			return nil, errors.New("error in mmap file : " + err.Error())
+		}
+	}
+	return r, nil
 }
 
 func (r *SStReader) ReadFooter() error {
@@ -58,123 +69,158 @@ func (r *SStReader) ReadFooter() error {
 	}
 	fileSize := fileInfo.Size()
 
-	if _, err := r.fd.Seek(fileSize-int64(r.conf.SstFooterSize), io.SeekStart); err != nil {
-		return err
-	}
-
-	footerData := make([]byte, r.conf.SstFooterSize)
-	if _, err := io.ReadFull(r.reader, footerData); err != nil {
-		return err
-	}
-
-	buf := bytes.NewBuffer(footerData)
-
-	filterOffset, err := binary.ReadUvarint(buf)
-	if err != nil {
-		return err
-	}
-
-	filterSize, err := binary.ReadUvarint(buf)
-	if err != nil {
-		return err
-	}
-
-	indexOffset, err := binary.ReadUvarint(buf)
-	if err != nil {
-		return err
-	}
-
-	indexSize, err := binary.ReadUvarint(buf)
-	if err != nil {
-		return err
+	var footer *Footer
+	if fileSize >= FooterSize {
+		footerData := make([]byte, FooterSize)
+		if _, err := r.fd.ReadAt(footerData, fileSize-FooterSize); err != nil {
+			return err
+		}
+		if HasFooterMagic(footerData) {
+			if footer, err = DecodeFooter(footerData); err != nil {
+				return err
+			}
+		}
 	}
 
-	if filterOffset == 0 || filterSize == 0 || indexOffset == 0 || indexSize == 0 {
-		return errors.New("sst footer data error: zero values detected")
+	if footer == nil {
+		if fileSize < int64(r.conf.SstFooterSize) {
+			return ErrBadMagic
+		}
+		footerData := make([]byte, r.conf.SstFooterSize)
+		if _, err := r.fd.ReadAt(footerData, fileSize-int64(r.conf.SstFooterSize)); err != nil {
+			return err
+		}
+		if footer, err = decodeLegacyFooter(footerData, fileSize); err != nil {
+			return err
+		}
 	}
 
-	if filterOffset >= uint64(fileSize) || indexOffset >= uint64(fileSize) {
+	if footer.Filter.Offset >= uint64(fileSize) || footer.Index.Offset >= uint64(fileSize) {
 		return errors.New("sst footer data error: invalid offsets")
 	}
 
-	r.FilterOffset = int64(filterOffset)
-	r.FilterSize = int64(filterSize)
-	r.IndexOffset = int64(indexOffset)
-	r.IndexSize = int64(indexSize)
+	r.Footer = footer
+	r.FilterOffset = int64(footer.Filter.Offset)
+	r.FilterSize = int64(footer.Filter.Size)
+	r.IndexOffset = int64(footer.Index.Offset)
+	r.IndexSize = int64(footer.Index.Size)
 
 	return nil
-
 }
 
 func (r *SStReader) ReadBlock(offset, size uint64) ([]byte, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	if _, err := r.fd.Seek(int64(offset), io.SeekStart); err != nil {
-		return nil, fmt.Errorf("seek error: %v", err)
+	r.blockReads.Add(1)
+	if r.data != nil {
+		block, err := r.mapped(offset, size)
+		if err != nil {
+			return nil, err
+		}
+		return r.verifyBlock(block, offset)
 	}
 
-	compressed := make([]byte, size-4) // -4 for CRC
-	if _, err := io.ReadFull(r.reader, compressed); err != nil {
-		return nil, fmt.Errorf("read error: %v", err)
-	}
+	return r.readAt(int64(offset), int64(size))
+}
+
+// BlockReads returns the number of blocks read through ReadBlock.
+func (r *SStReader) BlockReads() uint64 {
+	return r.blockReads.Load()
+}
 
-	crc := make([]byte, 4)
-	if _, err := io.ReadFull(r.reader, crc); err != nil {
-		return nil, fmt.Errorf("crc read error: %v", err)
+func (r *SStReader) readBlock(offset, size int64) ([]byte, error) {
+	if r.data != nil {
+		block, err := r.mapped(uint64(offset), uint64(size))
+		if err != nil {
+			return nil, err
+		}
+		return r.verifyBlock(block, uint64(offset))
 	}
 
-	expectedCRC := binary.LittleEndian.Uint32(crc)
-	actualCRC := utils.CompressedCheckSum(compressed)
+	return r.readAt(offset, size)
+}
 
-	if expectedCRC != actualCRC {
-		return nil, fmt.Errorf("CRC mismatch: expected %d, got %d", expectedCRC, actualCRC)
+// readAt reads and verifies the block at offset from the file.
+func (r *SStReader) readAt(offset, size int64) ([]byte, error) {
+	block := make([]byte, size)
+	if _, err := r.fd.ReadAt(block, offset); err != nil {
+		return nil, r.readError(uint64(offset), err)
 	}
+	return r.verifyBlock(block, uint64(offset))
+}
 
-	decompressed, err := snappy.Decode(nil, compressed)
-	if err != nil {
-		return nil, fmt.Errorf("decompress error: %v", err)
+// readError reports a block cut short by the end of the file as corruption.
+func (r *SStReader) readError(offset uint64, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return r.corruption(offset, fmt.Errorf("read error: %v", err))
 	}
+	return fmt.Errorf("read error: %v", err)
+}
 
-	return decompressed, nil
+func (r *SStReader) corruption(offset uint64, err error) error {
+	return utils.NewCorruptionError(path.Base(r.fd.Name()), offset, err)
 }
 
-func (r *SStReader) readBlock(offset, size int64) ([]byte, error) {
-	if _, err := r.fd.Seek(offset, io.SeekStart); err != nil {
-		return nil, errors.New("error in r.fd.Seek : " + err.Error())
+// mapped returns the block at offset from the mapped file.
+func (r *SStReader) mapped(offset, size uint64) ([]byte, error) {
+	if size < 4 || offset+size > uint64(len(r.data)) {
+		return nil, r.corruption(offset, fmt.Errorf("read error: block %d+%d out of file size %d", offset, size, len(r.data)))
 	}
-	r.reader.Reset(r.fd)
+	return r.data[offset : offset+size], nil
+}
 
-	compress, err := r.read(size)
-	if err != nil {
-		return nil, errors.New("error in read size : " + err.Error())
+// verifyBlock checks the CRC trailer of the block read at offset and
+// decompresses it. The result never aliases block, which may be part of the
+// mapped file.
+func (r *SStReader) verifyBlock(block []byte, offset uint64) ([]byte, error) {
+	n := len(block) - 4 // -4 for CRC
+	if n < 0 {
+		return nil, r.corruption(offset, fmt.Errorf("read error: block too short"))
 	}
-	crc := binary.LittleEndian.Uint32(compress[size-4:])
-	compressData := compress[:size-4]
-	if utils.CompressedCheckSum(compressData) != crc {
-		return nil, errors.New("error in check crc ")
+	expectedCRC := binary.LittleEndian.Uint32(block[n:])
+	actualCRC := utils.CompressedCheckSum(block[:n])
+	if r.checksum() == ChecksumCRC32C && expectedCRC != actualCRC {
+		return nil, r.corruption(offset, fmt.Errorf("CRC mismatch: expected %d, got %d", expectedCRC, actualCRC))
 	}
 
-	data, err := snappy.Decode(nil, compressData)
+	if r.Footer != nil && r.Footer.Compression == CompressionNone {
+		return append([]byte(nil), block[:n]...), nil
+	}
+	decompressed, err := r.decompress(block[:n])
 	if err != nil {
-		return nil, errors.New("error in snappy decode compressData")
+		return nil, r.corruption(offset, fmt.Errorf("decompress error: %v", err))
 	}
-	return data, nil
+	return decompressed, nil
 }
 
-func (r *SStReader) read(size int64) (b []byte, err error) {
-	b = make([]byte, size)
-	_, err = io.ReadFull(r.reader, b)
-	return
+func (r *SStReader) checksum() ChecksumType {
+	if r.Footer == nil {
+		return ChecksumCRC32C
+	}
+	return r.Footer.Checksum
+}
+
+func (r *SStReader) decompress(data []byte) ([]byte, error) {
+	if r.Footer != nil && r.Footer.Compression == CompressionNone {
+		return data, nil
+	}
+	return snappy.Decode(nil, data)
 }
 
 func DecodeBlock(block []byte) ([]byte, []int, error) {
 	n := len(block)
+	if n < 4 {
+		return nil, nil, errors.New("block too short")
+	}
 	nRestartPoint := int(binary.LittleEndian.Uint32(block[n-4:]))
 	oRestartPoint := n - (nRestartPoint * 4) - 4
+	if nRestartPoint > n/4 || oRestartPoint < 0 {
+		return nil, nil, fmt.Errorf("bad restart point count %d", nRestartPoint)
+	}
 	restartPoint := make([]int, nRestartPoint)
 	for i := 0; i < nRestartPoint; i++ {
 		restartPoint[i] = int(binary.LittleEndian.Uint32(block[oRestartPoint+i*4:]))
+		if restartPoint[i] > oRestartPoint {
+			return nil, nil, fmt.Errorf("restart point %d out of block", restartPoint[i])
+		}
 	}
 	return block[:oRestartPoint], restartPoint, nil
 }
@@ -224,11 +270,10 @@ func ReadRecord(prevKey []byte, buf *bytes.Buffer) ([]byte, []byte, error) {
 	return key, value, nil
 }
 
-func ReadFilter(index []byte) map[uint64][]byte {
+func ReadFilter(index []byte) (map[uint64][]byte, error) {
 	data, _, err := DecodeBlock(index)
 	if err != nil {
-		log.Printf("error in DecodeBlock: %v", err)
-		return nil
+		return nil, errors.New("error in DecodeBlock : " + err.Error())
 	}
 	buf := bytes.NewBuffer(data)
 
@@ -237,16 +282,18 @@ func ReadFilter(index []byte) map[uint64][]byte {
 
 	for {
 		key, value, err := ReadRecord(prevKey, buf)
-		if err != nil {
-			panic(errors.New("error in readRecord(prvKey,buf) : " + err.Error()))
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, errors.New("error in readRecord(prvKey,buf) : " + err.Error())
+		}
 
 		offset, _ := binary.Uvarint(key)
 		filterMap[offset] = value
 		prevKey = key
 	}
-	return filterMap
+	return filterMap, nil
 }
 func (r *SStReader) ReadFilter() (map[uint64][]byte, error) {
 	if r.FilterOffset == 0 {
@@ -260,7 +307,11 @@ func (r *SStReader) ReadFilter() (map[uint64][]byte, error) {
 		return nil, err
 	}
 
-	return ReadFilter(data), nil
+	filter, err := ReadFilter(data)
+	if err != nil {
+		return nil, r.corruption(uint64(r.FilterOffset), err)
+	}
+	return filter, nil
 }
 
 func (r *SStReader) ReadIndex() ([]*Index, error) {
@@ -270,19 +321,22 @@ func (r *SStReader) ReadIndex() ([]*Index, error) {
 		}
 	}
 
-	index, err := r.readBlock(r.IndexOffset, r.IndexSize)
+	data, err := r.readBlock(r.IndexOffset, r.IndexSize)
 	if err != nil {
 		return nil, err
 	}
 
-	return ReadIndex(index), nil
+	index, err := ReadIndex(data)
+	if err != nil {
+		return nil, r.corruption(uint64(r.IndexOffset), err)
+	}
+	return index, nil
 }
 
-func ReadIndex(index []byte) []*Index {
+func ReadIndex(index []byte) ([]*Index, error) {
 	data, _, err := DecodeBlock(index)
 	if err != nil {
-		log.Printf("error in DecodeBlock: %v", err)
-		return nil
+		return nil, errors.New("error in DecodeBlock : " + err.Error())
 	}
 	indexBuf := bytes.NewBuffer(data)
 
@@ -291,12 +345,20 @@ func ReadIndex(index []byte) []*Index {
 
 	for {
 		key, value, err := ReadRecord(prevKey, indexBuf)
-		if err != nil {
-			fmt.Errorf("error in readRecord(prvKey,indexBuf): %s\n" + err.Error())
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, errors.New("error in readRecord(prvKey,indexBuf) : " + err.Error())
+		}
 		offset, n := binary.Uvarint(value)
-		size, _ := binary.Uvarint(value[n:])
+		if n <= 0 {
+			return nil, errors.New("bad index entry for " + string(key))
+		}
+		size, m := binary.Uvarint(value[n:])
+		if m <= 0 {
+			return nil, errors.New("bad index entry for " + string(key))
+		}
 
 		indexes = append(indexes, &Index{
 			Key:        key,
@@ -306,11 +368,27 @@ func ReadIndex(index []byte) []*Index {
 		prevKey = key
 	}
 
-	return indexes
+	return indexes, nil
+}
+
+// Close releases the file without removing it.
+func (r *SStReader) Close() error {
+	if r.data != nil {
+		if err := munmap(r.data); err != nil {
+			return err
+		}
+		r.data = nil
+	}
+	return r.fd.Close()
 }
 
 func (r *SStReader) Destroy() {
-	r.reader.Reset(r.fd)
+	if r.data != nil {
+		if err := munmap(r.data); err != nil {
+			panic(errors.New("error in munmap : " + err.Error()))
+		}
+		r.data = nil
+	}
 	if err := r.fd.Close(); err != nil {
 		panic(errors.New("error in close fd : " + err.Error()))
 	}
@@ -342,12 +420,19 @@ type SsWriter struct {
 	prevKey         []byte
 	prevBlockOffset uint64
 	prevBlockSize   uint64
+	props           Properties
+	rangeDels       []RangeTombstone
+	limiter         *RateLimiter
+	priority        IOPriority
 }
 
 var _ SsWriterInterface = (*SsWriter)(nil)
 
 func NewSStWriter(file string, conf *config.Config) (*SsWriter, error) {
-	fd, err := os.OpenFile(path.Join(conf.Dir, file), os.O_RDWR|os.O_CREATE, 0777)
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	fd, err := os.OpenFile(path.Join(conf.Dir, file), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0777)
 	if os.IsNotExist(err) {
 		fd, err = os.Create(path.Join(conf.Dir, file))
 		if err != nil {
@@ -365,7 +450,7 @@ func NewSStWriter(file string, conf *config.Config) (*SsWriter, error) {
 		indexBuf:    bytes.NewBuffer(make([]byte, 0)),
 		index:       make([]*Index, 0),
 		filter:      make(map[uint64][]byte),
-		bf:          utils.NewBloomFilter(10),
+		bf:          utils.NewBloomFilter(filterBitsPerKey),
 		dataBlock:   NewBlock(conf),
 		filterBlock: NewBlock(conf),
 		indexBlock:  NewBlock(conf),
@@ -380,6 +465,15 @@ type Index struct {
 }
 
 func (w *SsWriter) Append(key, value []byte) {
+	w.AppendEntry(key, value, KindValue, 0)
+}
+
+// AppendEntry appends key with a value of the given kind written at sequence
+// number seq. Keys must be appended in increasing order.
+func (w *SsWriter) AppendEntry(key, value []byte, kind ValueKind, seq uint64) {
+	w.props.add(key, value, kind, seq)
+	value = EncodeValue(kind, seq, value)
+
 	if w.dataBlock.n == 0 {
 		skey := make([]byte, len(key))
 		copy(skey, key)
@@ -398,7 +492,10 @@ func (w *SsWriter) Append(key, value []byte) {
 func (w *SsWriter) addIndex(key []byte) {
 	n := binary.PutUvarint(w.indexScratch[0:], w.prevBlockOffset)
 	n += binary.PutUvarint(w.indexScratch[n:], w.prevBlockSize)
-	separator := GetSeparator(w.prevKey, key)
+	separator := append([]byte(nil), key...)
+	if len(w.prevKey) > 0 {
+		separator = w.conf.KeyComparator().Separator(w.prevKey, key)
+	}
 
 	w.indexBlock.Append(separator, w.indexScratch[:n])
 	w.index = append(w.index, &Index{Key: separator, PrevOffset: w.prevBlockOffset, PrevSize: w.prevBlockSize})
@@ -428,41 +525,89 @@ func (w *SsWriter) Finish() (int64, map[uint64][]byte, []*Index, error) {
 	}
 
 	dataSize := int64(w.dataBuf.Len())
-	if _, err := w.fd.Write(w.dataBuf.Bytes()); err != nil {
+	if _, err := w.write(w.dataBuf.Bytes()); err != nil {
 		return 0, nil, nil, err
 	}
 
 	filterOffset := dataSize
-	filterSize, err := w.filterBlock.FlushBlockTo(w.fileBuf)
+	w.addIndex(w.prevKey)
+
+	var filterSize, indexSize uint64
+	var indexOffset int64
+	if w.conf.PartitionIndex {
+		filter, index, err := w.writePartitioned(dataSize)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		filterOffset, filterSize = int64(filter.Offset), filter.Size
+		indexOffset, indexSize = int64(index.Offset), index.Size
+	} else {
+		var err error
+		filterSize, err = w.filterBlock.FlushBlockTo(w.fileBuf)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if _, err := w.write(w.fileBuf.Bytes()); err != nil {
+			return 0, nil, nil, err
+		}
+
+		indexOffset = filterOffset + int64(w.fileBuf.Len())
+		indexSize, err = w.indexBlock.FlushBlockTo(w.indexBuf)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if _, err := w.write(w.indexBuf.Bytes()); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+
+	w.props.DataSize = uint64(dataSize)
+	w.props.FilterSize = filterSize
+	w.props.IndexSize = indexSize
+	w.props.CreationTime = time.Now().Unix()
+	w.props.Compression = "snappy"
+	w.props.FilterPolicy = fmt.Sprintf("bloomfilter.%d", filterBitsPerKey)
+
+	metaBuf := bytes.NewBuffer(make([]byte, 0))
+	rangeDels, err := w.writeRangeDels(metaBuf, indexOffset+int64(indexSize))
 	if err != nil {
 		return 0, nil, nil, err
 	}
-	if _, err := w.fd.Write(w.fileBuf.Bytes()); err != nil {
+	propsOffset := indexOffset + int64(indexSize) + int64(rangeDels.Size)
+	propsBlock := NewBlock(w.conf)
+	w.props.encodeTo(propsBlock)
+	propsSize, err := propsBlock.FlushBlockTo(metaBuf)
+	if err != nil {
 		return 0, nil, nil, err
 	}
 
-	indexOffset := filterOffset + int64(w.fileBuf.Len())
-	w.addIndex(w.prevKey)
-	indexSize, err := w.indexBlock.FlushBlockTo(w.indexBuf)
+	metaOffset := propsOffset + int64(propsSize)
+	metaIndex := NewBlock(w.conf)
+	handles := map[string][]byte{
+		metaPropertiesName: encodeHandle(BlockHandle{Offset: uint64(propsOffset), Size: propsSize}),
+	}
+	if !rangeDels.IsZero() {
+		handles[metaRangeDelName] = encodeHandle(rangeDels)
+	}
+	appendSorted(metaIndex, handles)
+	metaSize, err := metaIndex.FlushBlockTo(metaBuf)
 	if err != nil {
 		return 0, nil, nil, err
 	}
-	if _, err := w.fd.Write(w.indexBuf.Bytes()); err != nil {
+	if _, err := w.write(metaBuf.Bytes()); err != nil {
 		return 0, nil, nil, err
 	}
 
-	footer := make([]byte, w.conf.SstFooterSize)
-	var n int
-	n = binary.PutUvarint(footer[0:], uint64(filterOffset))
-	n += binary.PutUvarint(footer[n:], filterSize)
-	n += binary.PutUvarint(footer[n:], uint64(indexOffset))
-	n += binary.PutUvarint(footer[n:], indexSize)
-
-	if _, err := w.fd.Write(footer); err != nil {
+	footer := NewFooter(
+		BlockHandle{Offset: uint64(filterOffset), Size: filterSize},
+		BlockHandle{Offset: uint64(indexOffset), Size: indexSize},
+	)
+	footer.Meta = BlockHandle{Offset: uint64(metaOffset), Size: metaSize}
+	if _, err := w.write(footer.Encode()); err != nil {
 		return 0, nil, nil, err
 	}
 
-	totalSize := indexOffset + int64(w.indexBuf.Len()) + int64(w.conf.SstFooterSize)
+	totalSize := metaOffset + int64(metaSize) + FooterSize
 
 	if err := w.verifyFooter(); err != nil {
 		return 0, nil, nil, errors.New("footer verification failed" + err.Error())
@@ -471,20 +616,37 @@ func (w *SsWriter) Finish() (int64, map[uint64][]byte, []*Index, error) {
 	return totalSize, w.filter, w.index, nil
 }
 
-func GetSeparator(a, b []byte) []byte {
-	if len(a) == 0 {
-		n := len(b) - 1
-		c := b[n] - 1
-		return append(b[0:n], c)
-	}
+// Properties returns the table properties collected so far; complete once
+// Finish has returned.
+func (w *SsWriter) Properties() *Properties {
+	return &w.props
+}
 
-	n := SharedPrefixLen(a, b)
-	if n == 0 || n == len(a) {
-		return a
-	} else {
-		c := a[n] + 1
-		return append(a[0:n], c)
+// SetRateLimiter makes the writes of w wait on l at priority pri.
+func (w *SsWriter) SetRateLimiter(l *RateLimiter, pri IOPriority) {
+	w.limiter = l
+	w.priority = pri
+}
+
+// write writes p in chunks of at most one burst of the limiter, each granted
+// right before it is written, so a large block does not reach the disk all at
+// once after waiting for the whole of it.
+func (w *SsWriter) write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := len(p)
+		if burst := w.limiter.Burst(); burst > 0 && int64(chunk) > burst {
+			chunk = int(burst)
+		}
+		w.limiter.Request(int64(chunk), w.priority)
+		n, err := w.fd.Write(p[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[chunk:]
 	}
+	return written, nil
 }
 
 func (w *SsWriter) Size() int {
@@ -511,21 +673,14 @@ func SharedPrefixLen(a, b []byte) int {
 }
 
 func (w *SsWriter) verifyFooter() error {
-	if _, err := w.fd.Seek(-int64(w.conf.SstFooterSize), io.SeekEnd); err != nil {
+	footerData := make([]byte, FooterSize)
+	fileInfo, err := w.fd.Stat()
+	if err != nil {
 		return err
 	}
-	footerData := make([]byte, w.conf.SstFooterSize)
-	if _, err := io.ReadFull(bufio.NewReader(w.fd), footerData); err != nil {
+	if _, err := w.fd.ReadAt(footerData, fileInfo.Size()-FooterSize); err != nil {
 		return errors.New("error in footer data : " + err.Error())
 	}
-	//
-	//buf := bytes.NewBuffer(footerData)
-	//filterOffset, _ := binary.ReadUvarint(buf)
-	//filterSize, _ := binary.ReadUvarint(buf)
-	//indexOffset, _ := binary.ReadUvarint(buf)
-	//indexSize, _ := binary.ReadUvarint(buf)
-	//
-	//log.Printf("Footer verification - FilterOffset: %d, FilterSize: %d, IndexOffset: %d, IndexSize: %d",
-	//	filterOffset, filterSize, indexOffset, indexSize)
-	return nil
+	_, err = DecodeFooter(footerData)
+	return err
 }
@@ -1,6 +1,9 @@
 package utils
 
-import "cmp"
+import (
+	"bytes"
+	"cmp"
+)
 
 type Comparator[T any] interface {
 	Compare(T, T) int
@@ -13,3 +16,95 @@ var _ Comparator[int] = (*OrderComparator[int])(nil)
 func (o *OrderComparator[T]) Compare(a T, b T) int {
 	return cmp.Compare[T](a, b)
 }
+
+// BytesComparator orders the encoded keys stored in SSTs. Its Name is
+// recorded in the MANIFEST, and a database is only reopened with a comparator
+// of the same name. Compare must only return 0 for equal keys.
+type BytesComparator interface {
+	Comparator[[]byte]
+	Name() string
+	// Separator returns a short key k with a <= k, and k < b when a < b.
+	Separator(a, b []byte) []byte
+}
+
+var (
+	BytewiseComparator        BytesComparator = bytewiseComparator{}
+	ReverseBytewiseComparator BytesComparator = reverseBytewiseComparator{}
+	CaseInsensitiveComparator BytesComparator = caseInsensitiveComparator{}
+	Uint64Comparator          BytesComparator = uint64Comparator{}
+)
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Name() string { return "gocloud.BytewiseComparator" }
+
+func (bytewiseComparator) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+
+func (bytewiseComparator) Separator(a, b []byte) []byte {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	if n < len(a) && n < len(b) && a[n] < 0xff && a[n]+1 < b[n] {
+		sep := append([]byte(nil), a[:n+1]...)
+		sep[n]++
+		return sep
+	}
+	return append([]byte(nil), a...)
+}
+
+// reverseBytewiseComparator orders keys as bytes.Compare, backwards.
+type reverseBytewiseComparator struct{}
+
+func (reverseBytewiseComparator) Name() string { return "gocloud.ReverseBytewiseComparator" }
+
+func (reverseBytewiseComparator) Compare(a, b []byte) int { return bytes.Compare(b, a) }
+
+func (reverseBytewiseComparator) Separator(a, b []byte) []byte { return append([]byte(nil), a...) }
+
+// caseInsensitiveComparator orders keys as their ASCII lower case, keys which
+// only differ by case bytewise.
+type caseInsensitiveComparator struct{}
+
+func (caseInsensitiveComparator) Name() string { return "gocloud.CaseInsensitiveComparator" }
+
+func (caseInsensitiveComparator) Compare(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := cmp.Compare(lower(a[i]), lower(b[i])); c != 0 {
+			return c
+		}
+	}
+	if c := cmp.Compare(len(a), len(b)); c != 0 {
+		return c
+	}
+	return bytes.Compare(a, b)
+}
+
+func (caseInsensitiveComparator) Separator(a, b []byte) []byte { return append([]byte(nil), a...) }
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// uint64Comparator orders keys holding big-endian unsigned integers of any
+// length by value; keys with the same value, but a different number of
+// leading zeros, bytewise.
+type uint64Comparator struct{}
+
+func (uint64Comparator) Name() string { return "gocloud.Uint64Comparator" }
+
+func (uint64Comparator) Compare(a, b []byte) int {
+	ta, tb := bytes.TrimLeft(a, "\x00"), bytes.TrimLeft(b, "\x00")
+	if c := cmp.Compare(len(ta), len(tb)); c != 0 {
+		return c
+	}
+	if c := bytes.Compare(ta, tb); c != 0 {
+		return c
+	}
+	return bytes.Compare(a, b)
+}
+
+func (uint64Comparator) Separator(a, b []byte) []byte { return append([]byte(nil), a...) }
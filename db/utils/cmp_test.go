@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBytesComparators(t *testing.T) {
+	keys := [][]byte{[]byte(""), []byte("A"), []byte("a"), []byte("ab"), []byte("B"), []byte("abc"), []byte("b\xff"), []byte("ba")}
+
+	sorted := func(c BytesComparator) []string {
+		s := append([][]byte(nil), keys...)
+		sort.Slice(s, func(i, j int) bool { return c.Compare(s[i], s[j]) < 0 })
+		var got []string
+		for _, k := range s {
+			got = append(got, string(k))
+		}
+		return got
+	}
+	assert.Equal(t, []string{"", "A", "B", "a", "ab", "abc", "ba", "b\xff"}, sorted(BytewiseComparator))
+	assert.Equal(t, []string{"b\xff", "ba", "abc", "ab", "a", "B", "A", ""}, sorted(ReverseBytewiseComparator))
+	assert.Equal(t, []string{"", "A", "a", "ab", "abc", "B", "ba", "b\xff"}, sorted(CaseInsensitiveComparator))
+
+	nums := [][]byte{{0x01, 0x00}, {0xff}, {0x00, 0x05}, {0x05}, binary.BigEndian.AppendUint64(nil, 7)}
+	sort.Slice(nums, func(i, j int) bool { return Uint64Comparator.Compare(nums[i], nums[j]) < 0 })
+	assert.Equal(t, [][]byte{{0x00, 0x05}, {0x05}, binary.BigEndian.AppendUint64(nil, 7), {0xff}, {0x01, 0x00}}, nums)
+
+	for _, c := range []BytesComparator{BytewiseComparator, ReverseBytewiseComparator, CaseInsensitiveComparator, Uint64Comparator} {
+		for _, a := range keys {
+			for _, b := range keys {
+				assert.Equal(t, bytes.Equal(a, b), c.Compare(a, b) == 0, "%s %q %q", c.Name(), a, b)
+				if c.Compare(a, b) >= 0 {
+					continue
+				}
+				sep := c.Separator(a, b)
+				assert.True(t, c.Compare(a, sep) <= 0 && c.Compare(sep, b) < 0, "%s %q %q: %q", c.Name(), a, b, sep)
+			}
+		}
+	}
+	assert.Equal(t, []byte("b"), BytewiseComparator.Separator([]byte("abc"), []byte("c")))
+}
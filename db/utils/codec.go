@@ -0,0 +1,390 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// KeyCodec turns keys into bytes. The encoding preserves the order of the
+// keys: bytes.Compare on two encodings orders them as the keys themselves, so
+// the memtable, the WAL and the SSTs all agree on the key order.
+type KeyCodec[K any] interface {
+	EncodeKey(K) ([]byte, error)
+	DecodeKey([]byte) (K, error)
+}
+
+// ValueCodec turns values into bytes.
+type ValueCodec[V any] interface {
+	EncodeValue(V) ([]byte, error)
+	DecodeValue([]byte) (V, error)
+}
+
+type Signed interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64
+}
+
+type Unsigned interface {
+	~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+type Float interface {
+	~float32 | ~float64
+}
+
+// DefaultKeyCodec returns the codec of the built-in key types: strings, byte
+// slices, integers, floats and time.Time. The codec of any other type fails
+// with ErrUnsupportedType.
+func DefaultKeyCodec[K any]() KeyCodec[K] {
+	if c, ok := defaultCodec[K]().(KeyCodec[K]); ok {
+		return c
+	}
+	return unsupportedCodec[K]{}
+}
+
+// DefaultValueCodec returns the codec of the built-in value types, as
+// DefaultKeyCodec does for keys.
+func DefaultValueCodec[V any]() ValueCodec[V] {
+	if c, ok := defaultCodec[V]().(ValueCodec[V]); ok {
+		return c
+	}
+	return unsupportedCodec[V]{}
+}
+
+func defaultCodec[T any]() any {
+	var v T
+	switch any(v).(type) {
+	case string:
+		return StringCodec{}
+	case []byte:
+		return BytesCodec{}
+	case int:
+		return IntCodec[int]{}
+	case int8:
+		return IntCodec[int8]{}
+	case int16:
+		return IntCodec[int16]{}
+	case int32:
+		return IntCodec[int32]{}
+	case int64:
+		return IntCodec[int64]{}
+	case uint:
+		return UintCodec[uint]{}
+	case uint8:
+		return UintCodec[uint8]{}
+	case uint16:
+		return UintCodec[uint16]{}
+	case uint32:
+		return UintCodec[uint32]{}
+	case uint64:
+		return UintCodec[uint64]{}
+	case float32:
+		return FloatCodec[float32]{}
+	case float64:
+		return FloatCodec[float64]{}
+	case time.Time:
+		return TimeCodec{}
+	}
+	return nil
+}
+
+type unsupportedCodec[T any] struct{}
+
+func (unsupportedCodec[T]) EncodeKey(k T) ([]byte, error) {
+	return nil, fmt.Errorf("%w: key %T", ErrUnsupportedType, k)
+}
+
+func (unsupportedCodec[T]) DecodeKey([]byte) (T, error) {
+	var k T
+	return k, fmt.Errorf("%w: key %T", ErrUnsupportedType, k)
+}
+
+func (unsupportedCodec[T]) EncodeValue(v T) ([]byte, error) {
+	return nil, fmt.Errorf("%w: value %T", ErrUnsupportedType, v)
+}
+
+func (unsupportedCodec[T]) DecodeValue([]byte) (T, error) {
+	var v T
+	return v, fmt.Errorf("%w: value %T", ErrUnsupportedType, v)
+}
+
+// StringCodec stores strings as their bytes.
+type StringCodec struct{}
+
+func (StringCodec) EncodeKey(k string) ([]byte, error) { return []byte(k), nil }
+
+func (StringCodec) DecodeKey(b []byte) (string, error) { return string(b), nil }
+
+func (StringCodec) EncodeValue(v string) ([]byte, error) { return []byte(v), nil }
+
+func (StringCodec) DecodeValue(b []byte) (string, error) { return string(b), nil }
+
+// BytesCodec stores byte slices as they are.
+type BytesCodec struct{}
+
+func (BytesCodec) EncodeKey(k []byte) ([]byte, error) { return k, nil }
+
+func (BytesCodec) DecodeKey(b []byte) ([]byte, error) { return append([]byte(nil), b...), nil }
+
+func (BytesCodec) EncodeValue(v []byte) ([]byte, error) { return v, nil }
+
+func (BytesCodec) DecodeValue(b []byte) ([]byte, error) { return append([]byte(nil), b...), nil }
+
+// IntCodec stores signed integers as 8 big-endian bytes with the sign bit
+// flipped, so that negative numbers sort before the positive ones.
+type IntCodec[T Signed] struct{}
+
+func (IntCodec[T]) EncodeKey(k T) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, uint64(k)^(1<<63)), nil
+}
+
+func (IntCodec[T]) DecodeKey(b []byte) (T, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("%w: int of %d bytes", ErrCorruption, len(b))
+	}
+	return T(int64(binary.BigEndian.Uint64(b) ^ (1 << 63))), nil
+}
+
+func (c IntCodec[T]) EncodeValue(v T) ([]byte, error) { return c.EncodeKey(v) }
+
+func (c IntCodec[T]) DecodeValue(b []byte) (T, error) { return c.DecodeKey(b) }
+
+// UintCodec stores unsigned integers as 8 big-endian bytes.
+type UintCodec[T Unsigned] struct{}
+
+func (UintCodec[T]) EncodeKey(k T) ([]byte, error) {
+	return binary.BigEndian.AppendUint64(nil, uint64(k)), nil
+}
+
+func (UintCodec[T]) DecodeKey(b []byte) (T, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("%w: uint of %d bytes", ErrCorruption, len(b))
+	}
+	return T(binary.BigEndian.Uint64(b)), nil
+}
+
+func (c UintCodec[T]) EncodeValue(v T) ([]byte, error) { return c.EncodeKey(v) }
+
+func (c UintCodec[T]) DecodeValue(b []byte) (T, error) { return c.DecodeKey(b) }
+
+// FloatCodec stores floats as the 8 big-endian bytes of their float64 bits,
+// with the sign bit flipped for positive numbers and every bit flipped for
+// negative ones. -0 is stored as 0 and NaN sorts before every number, as
+// cmp.Compare orders them.
+type FloatCodec[T Float] struct{}
+
+func (FloatCodec[T]) EncodeKey(k T) ([]byte, error) {
+	f := float64(k)
+	if math.IsNaN(f) {
+		return make([]byte, 8), nil
+	}
+	if f == 0 {
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(nil, bits), nil
+}
+
+func (FloatCodec[T]) DecodeKey(b []byte) (T, error) {
+	if len(b) != 8 {
+		return 0, fmt.Errorf("%w: float of %d bytes", ErrCorruption, len(b))
+	}
+	bits := binary.BigEndian.Uint64(b)
+	if bits == 0 {
+		return T(math.NaN()), nil
+	}
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return T(math.Float64frombits(bits)), nil
+}
+
+func (c FloatCodec[T]) EncodeValue(v T) ([]byte, error) { return c.EncodeKey(v) }
+
+func (c FloatCodec[T]) DecodeValue(b []byte) (T, error) { return c.DecodeKey(b) }
+
+// TimeCodec stores times as their Unix time in nanoseconds, encoded as by
+// IntCodec. Decoded times are in UTC.
+type TimeCodec struct{}
+
+func (TimeCodec) EncodeKey(k time.Time) ([]byte, error) {
+	return IntCodec[int64]{}.EncodeKey(k.UnixNano())
+}
+
+func (TimeCodec) DecodeKey(b []byte) (time.Time, error) {
+	n, err := IntCodec[int64]{}.DecodeKey(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, n).UTC(), nil
+}
+
+func (c TimeCodec) EncodeValue(v time.Time) ([]byte, error) { return c.EncodeKey(v) }
+
+func (c TimeCodec) DecodeValue(b []byte) (time.Time, error) { return c.DecodeKey(b) }
+
+// Tuple2 is a composite key ordered by First, then Second.
+type Tuple2[A any, B any] struct {
+	First  A
+	Second B
+}
+
+// Tuple2Codec encodes a Tuple2 with the codecs of its fields. Every field but
+// the last is escaped and terminated, so that a field which is a prefix of
+// another still sorts first.
+type Tuple2Codec[A any, B any] struct {
+	First  KeyCodec[A]
+	Second KeyCodec[B]
+}
+
+func (c Tuple2Codec[A, B]) EncodeKey(k Tuple2[A, B]) ([]byte, error) {
+	first, err := c.First.EncodeKey(k.First)
+	if err != nil {
+		return nil, err
+	}
+	second, err := c.Second.EncodeKey(k.Second)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendEscaped(nil, first), second...), nil
+}
+
+func (c Tuple2Codec[A, B]) DecodeKey(b []byte) (Tuple2[A, B], error) {
+	var k Tuple2[A, B]
+	first, rest, err := splitEscaped(b)
+	if err != nil {
+		return k, err
+	}
+	if k.First, err = c.First.DecodeKey(first); err != nil {
+		return k, err
+	}
+	k.Second, err = c.Second.DecodeKey(rest)
+	return k, err
+}
+
+func (c Tuple2Codec[A, B]) EncodeValue(v Tuple2[A, B]) ([]byte, error) { return c.EncodeKey(v) }
+
+func (c Tuple2Codec[A, B]) DecodeValue(b []byte) (Tuple2[A, B], error) { return c.DecodeKey(b) }
+
+// Tuple3 is a composite key ordered by First, then Second, then Third.
+type Tuple3[A any, B any, C any] struct {
+	First  A
+	Second B
+	Third  C
+}
+
+// Tuple3Codec encodes a Tuple3 as Tuple2Codec does.
+type Tuple3Codec[A any, B any, C any] struct {
+	First  KeyCodec[A]
+	Second KeyCodec[B]
+	Third  KeyCodec[C]
+}
+
+func (c Tuple3Codec[A, B, C]) EncodeKey(k Tuple3[A, B, C]) ([]byte, error) {
+	first, err := c.First.EncodeKey(k.First)
+	if err != nil {
+		return nil, err
+	}
+	second, err := c.Second.EncodeKey(k.Second)
+	if err != nil {
+		return nil, err
+	}
+	third, err := c.Third.EncodeKey(k.Third)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendEscaped(appendEscaped(nil, first), second), third...), nil
+}
+
+func (c Tuple3Codec[A, B, C]) DecodeKey(b []byte) (Tuple3[A, B, C], error) {
+	var k Tuple3[A, B, C]
+	first, rest, err := splitEscaped(b)
+	if err != nil {
+		return k, err
+	}
+	second, rest, err := splitEscaped(rest)
+	if err != nil {
+		return k, err
+	}
+	if k.First, err = c.First.DecodeKey(first); err != nil {
+		return k, err
+	}
+	if k.Second, err = c.Second.DecodeKey(second); err != nil {
+		return k, err
+	}
+	k.Third, err = c.Third.DecodeKey(rest)
+	return k, err
+}
+
+func (c Tuple3Codec[A, B, C]) EncodeValue(v Tuple3[A, B, C]) ([]byte, error) {
+	return c.EncodeKey(v)
+}
+
+func (c Tuple3Codec[A, B, C]) DecodeValue(b []byte) (Tuple3[A, B, C], error) {
+	return c.DecodeKey(b)
+}
+
+// appendEscaped appends b to dst with every 0x00 written as 0x00 0xff, then
+// the terminator 0x00 0x01, which sorts before any escaped byte.
+func appendEscaped(dst, b []byte) []byte {
+	for _, c := range b {
+		dst = append(dst, c)
+		if c == 0x00 {
+			dst = append(dst, 0xff)
+		}
+	}
+	return append(dst, 0x00, 0x01)
+}
+
+// splitEscaped undoes appendEscaped on the head of b and returns the rest.
+func splitEscaped(b []byte) ([]byte, []byte, error) {
+	var field []byte
+	for i := 0; i < len(b); i++ {
+		if b[i] != 0x00 {
+			field = append(field, b[i])
+			continue
+		}
+		if i+1 == len(b) {
+			break
+		}
+		switch b[i+1] {
+		case 0xff:
+			field = append(field, 0x00)
+			i++
+		case 0x01:
+			return field, b[i+2:], nil
+		default:
+			return nil, nil, fmt.Errorf("%w: bad escape in tuple key", ErrCorruption)
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: unterminated tuple key", ErrCorruption)
+}
+
+// CodecComparator orders keys as their encodings, with Order or bytewise,
+// for the key types that are not cmp.Ordered, such as tuples, or for the
+// memtables of a database with a custom BytesComparator. Keys which fail to
+// encode sort first.
+type CodecComparator[K any] struct {
+	Codec KeyCodec[K]
+	Order BytesComparator
+}
+
+var _ Comparator[Tuple2[int, string]] = (*CodecComparator[Tuple2[int, string]])(nil)
+
+func (c *CodecComparator[K]) Compare(a K, b K) int {
+	ea, _ := c.Codec.EncodeKey(a)
+	eb, _ := c.Codec.EncodeKey(b)
+	if c.Order != nil {
+		return c.Order.Compare(ea, eb)
+	}
+	return bytes.Compare(ea, eb)
+}
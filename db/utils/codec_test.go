@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func checkOrder[T any](t *testing.T, c KeyCodec[T], keys []T, compare func(a, b T) int) {
+	t.Helper()
+	for i, a := range keys {
+		ea, err := c.EncodeKey(a)
+		assert.NoError(t, err)
+		for _, b := range keys[i:] {
+			eb, err := c.EncodeKey(b)
+			assert.NoError(t, err)
+			assert.Equal(t, compare(a, b), bytes.Compare(ea, eb), "%v %v", a, b)
+		}
+	}
+}
+
+func TestIntCodec(t *testing.T) {
+	keys := []int{math.MinInt64, -1000, -10, -9, -1, 0, 1, 9, 10, 1000, math.MaxInt64}
+	checkOrder[int](t, IntCodec[int]{}, keys, cmp.Compare[int])
+	for _, k := range keys {
+		b, _ := IntCodec[int]{}.EncodeKey(k)
+		got, err := IntCodec[int]{}.DecodeKey(b)
+		assert.NoError(t, err)
+		assert.Equal(t, k, got)
+	}
+	_, err := IntCodec[int]{}.DecodeKey([]byte("10"))
+	assert.True(t, errors.Is(err, ErrCorruption))
+
+	small := []int8{math.MinInt8, -1, 0, 1, math.MaxInt8}
+	checkOrder[int8](t, IntCodec[int8]{}, small, cmp.Compare[int8])
+	b, _ := IntCodec[int8]{}.EncodeKey(-5)
+	got, err := IntCodec[int8]{}.DecodeKey(b)
+	assert.NoError(t, err)
+	assert.Equal(t, int8(-5), got)
+}
+
+func TestUintCodec(t *testing.T) {
+	keys := []uint64{0, 1, 9, 10, 255, 256, math.MaxUint64}
+	checkOrder[uint64](t, UintCodec[uint64]{}, keys, cmp.Compare[uint64])
+	b, _ := UintCodec[uint16]{}.EncodeKey(300)
+	got, err := UintCodec[uint16]{}.DecodeKey(b)
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(300), got)
+}
+
+func TestFloatCodec(t *testing.T) {
+	keys := []float64{math.NaN(), math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64, 0,
+		math.SmallestNonzeroFloat64, 1, 1.5, 10, math.MaxFloat64, math.Inf(1)}
+	checkOrder[float64](t, FloatCodec[float64]{}, keys, cmp.Compare[float64])
+	checkOrder[float64](t, FloatCodec[float64]{}, []float64{math.Copysign(0, -1), 0}, cmp.Compare[float64])
+	for _, k := range keys[1:] {
+		b, _ := FloatCodec[float64]{}.EncodeKey(k)
+		got, err := FloatCodec[float64]{}.DecodeKey(b)
+		assert.NoError(t, err)
+		assert.Equal(t, k, got)
+	}
+	b, _ := FloatCodec[float32]{}.EncodeKey(float32(math.NaN()))
+	nan, err := FloatCodec[float32]{}.DecodeKey(b)
+	assert.NoError(t, err)
+	assert.True(t, math.IsNaN(float64(nan)))
+}
+
+func TestTimeCodec(t *testing.T) {
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	keys := []time.Time{time.Unix(0, 0).Add(-time.Hour), time.Unix(0, 0), base, base.Add(time.Nanosecond), base.Add(time.Hour)}
+	checkOrder[time.Time](t, TimeCodec{}, keys, func(a, b time.Time) int { return a.Compare(b) })
+	b, _ := TimeCodec{}.EncodeKey(base.In(time.FixedZone("x", 3600)))
+	got, err := TimeCodec{}.DecodeKey(b)
+	assert.NoError(t, err)
+	assert.True(t, base.Equal(got))
+}
+
+func TestTupleCodec(t *testing.T) {
+	c := Tuple2Codec[string, int]{First: StringCodec{}, Second: IntCodec[int]{}}
+	keys := []Tuple2[string, int]{{"", 5}, {"a", -1}, {"a", 9}, {"a", 10}, {"a\x00", 0}, {"a\x00b", 0}, {"ab", -5}, {"b", 0}}
+	compare := func(a, b Tuple2[string, int]) int {
+		if c := cmp.Compare(a.First, b.First); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Second, b.Second)
+	}
+	checkOrder[Tuple2[string, int]](t, c, keys, compare)
+	assert.True(t, sort.SliceIsSorted(keys, func(i, j int) bool {
+		return (&CodecComparator[Tuple2[string, int]]{Codec: c}).Compare(keys[i], keys[j]) < 0
+	}))
+	for _, k := range keys {
+		b, _ := c.EncodeKey(k)
+		got, err := c.DecodeKey(b)
+		assert.NoError(t, err)
+		assert.Equal(t, k, got)
+	}
+	_, err := c.DecodeKey([]byte("abc"))
+	assert.True(t, errors.Is(err, ErrCorruption))
+
+	c3 := Tuple3Codec[string, string, uint32]{First: StringCodec{}, Second: StringCodec{}, Third: UintCodec[uint32]{}}
+	k := Tuple3[string, string, uint32]{First: "user\x00", Second: "", Third: 7}
+	b, err := c3.EncodeKey(k)
+	assert.NoError(t, err)
+	got, err := c3.DecodeKey(b)
+	assert.NoError(t, err)
+	assert.Equal(t, k, got)
+}
+
+func TestDefaultCodec(t *testing.T) {
+	b, err := DefaultKeyCodec[string]().EncodeKey("key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("key"), b)
+	_, ok := DefaultKeyCodec[int32]().(IntCodec[int32])
+	assert.True(t, ok)
+	_, ok = DefaultValueCodec[time.Time]().(TimeCodec)
+	assert.True(t, ok)
+
+	_, err = DefaultKeyCodec[struct{}]().EncodeKey(struct{}{})
+	assert.True(t, errors.Is(err, ErrUnsupportedType))
+	_, err = DefaultValueCodec[map[string]int]().EncodeValue(nil)
+	assert.True(t, errors.Is(err, ErrUnsupportedType))
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound        = errors.New("key not found")
+	ErrClosed          = errors.New("db closed")
+	ErrCorruption      = errors.New("corruption")
+	ErrUnsupportedType = errors.New("unsupported type")
+)
+
+// CorruptionError reports data that failed to decode or verify, at Offset of
+// File. It matches ErrCorruption with errors.Is.
+type CorruptionError struct {
+	File   string
+	Offset uint64
+	Err    error
+}
+
+func NewCorruptionError(file string, offset uint64, err error) *CorruptionError {
+	return &CorruptionError{File: file, Offset: offset, Err: err}
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corruption in %s at offset %d: %v", e.File, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error { return e.Err }
+
+func (e *CorruptionError) Is(target error) bool { return target == ErrCorruption }
@@ -4,7 +4,7 @@ import (
 	"fmt"
 )
 
-func FormatKeyValue2Byte[K any, V any](key K, value V) ([]byte, []byte) {
+func FormatKeyValue2Byte[K any, V any](key K, value V) ([]byte, []byte, error) {
 	var bKey []byte
 	var bValue []byte
 
@@ -14,7 +14,7 @@ func FormatKeyValue2Byte[K any, V any](key K, value V) ([]byte, []byte) {
 	case []byte:
 		bKey = v
 	default:
-		panic("Unsupported key type")
+		return nil, nil, fmt.Errorf("%w: key %T", ErrUnsupportedType, key)
 	}
 
 	switch v := any(value).(type) {
@@ -23,54 +23,9 @@ func FormatKeyValue2Byte[K any, V any](key K, value V) ([]byte, []byte) {
 	case []byte:
 		bValue = v
 	default:
-		panic("Unsupported value type")
+		return nil, nil, fmt.Errorf("%w: value %T", ErrUnsupportedType, value)
 	}
-	return bKey, bValue
-}
-
-func FormatKeyValue(k interface{}, v interface{}) ([]byte, []byte) {
-	var keyBytes []byte
-	var valueBytes []byte
-
-	switch k := k.(type) {
-	case int:
-		keyBytes = []byte(fmt.Sprintf("%d", k))
-	case string:
-		keyBytes = []byte(k)
-	case []byte:
-		keyBytes = k
-	default:
-		panic("Unsupported key type")
-	}
-
-	switch v := v.(type) {
-	case int:
-		valueBytes = []byte(fmt.Sprintf("%d", v))
-	case string:
-		valueBytes = []byte(v)
-	case []byte:
-		valueBytes = v
-	default:
-		panic("Unsupported value type")
-	}
-
-	return keyBytes, valueBytes
-}
-
-func FormatKeyV(k interface{}) []byte {
-	var keyBytes []byte
-
-	switch k := k.(type) {
-	case int:
-		keyBytes = []byte(fmt.Sprintf("%d", k))
-	case string:
-		keyBytes = []byte(k)
-	case []byte:
-		keyBytes = k
-	default:
-		panic("Unsupported key type")
-	}
-	return keyBytes
+	return bKey, bValue, nil
 }
 
 func FormatName(level, seqNo int, extra string) string {
@@ -7,6 +7,8 @@ import (
 
 func SetupRouter(r *gin.Engine) {
 	r.POST("/", service.WriteData)
+	r.POST("/kv/get", service.ReadData)
+	r.POST("/kv/mget", service.MultiGetData)
 	r.PUT("/upload", service.UploadToBucket)
 	r.GET("/file/:key", service.ReadFile)
 	r.GET("/", func(c *gin.Context) {
@@ -1,10 +1,12 @@
 package service
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/peterouob/gocloud/db"
 	"github.com/peterouob/gocloud/db/config"
 	"github.com/peterouob/gocloud/db/sstable"
+	"github.com/peterouob/gocloud/db/utils"
 	s3bucket "github.com/peterouob/gocloud/s3"
 	"net/http"
 	"time"
@@ -16,15 +18,22 @@ func WriteData(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	lsm := sstable.NewLSMTree[string, string]
-	d.LsmTree = lsm(config.NewConfig(d.FileName))
+	lsm, err := sstable.RestoreLSMTree[string, string](config.NewConfig(d.FileName))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer lsm.Close()
+	d.LsmTree = lsm
 	m := db.NewTableString(d.FileName, 10*time.Minute)
+	defer m.Close()
 	if err := m.Put(d.Key, d.Value); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
-	err := d.LsmTree.FlushRecord(m, d.FileName)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := d.LsmTree.FlushRecord(m, d.FileName); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{"data": d})
 }
@@ -33,12 +42,70 @@ func ReadData(c *gin.Context) {
 	d := db.DB{}
 	if err := c.ShouldBindJSON(&d); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lsm, err := sstable.RestoreLSMTree[string, string](config.NewConfig(d.FileName))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer lsm.Close()
+	value, found, err := lsm.Get(d.Key)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": utils.ErrNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": string(value)})
+}
+
+// errorStatus maps the errors of the db to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, utils.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, utils.ErrUnsupportedType):
+		return http.StatusBadRequest
+	case errors.Is(err, utils.ErrClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type MultiGetRequest struct {
+	FileName string   `json:"file_name"`
+	Keys     []string `json:"keys"`
+}
+
+func MultiGetData(c *gin.Context) {
+	req := MultiGetRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	lsm, err := sstable.RestoreLSMTree[string, string](config.NewConfig(req.FileName))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer lsm.Close()
+	values, err := db.MultiGet[string, string](nil, lsm, req.Keys)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
-	lsm := sstable.NewLSMTree[string, string]
-	d.LsmTree = lsm(config.NewConfig(d.FileName))
-	data := string(d.LsmTree.Get(d.Key))
-	if data == "" {
-		c.JSON(http.StatusOK, gin.H{"data": nil})
+	data := make(map[string]*string, len(req.Keys))
+	for i, key := range req.Keys {
+		if values[i] == nil {
+			data[key] = nil
+			continue
+		}
+		v := string(values[i])
+		data[key] = &v
 	}
 	c.JSON(http.StatusOK, gin.H{"data": data})
 }